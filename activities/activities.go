@@ -2,11 +2,20 @@ package activities
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"time"
 
 	"reminders/app"
+	"reminders/app/notifiers"
+	"reminders/app/storage"
 	"reminders/app/utils"
+	"reminders/app/webhooks"
 	"reminders/app/whatsapp"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
 )
 
 func Create(ctx context.Context, reminderDetails utils.ReminderDetails) error {
@@ -14,22 +23,44 @@ func Create(ctx context.Context, reminderDetails utils.ReminderDetails) error {
 		"\nCreating reminder %s (%s) to alert at %s. workflowId=%s runId=%s\n",
 		reminderDetails.ReminderName,
 		reminderDetails.ReminderText,
-		utils.GetReminderTime(reminderDetails.FromTime, reminderDetails.NMinutes).Format(app.TIME_FORMAT),
+		reminderDetails.GetReminderTime().Format(app.TIME_FORMAT),
 		reminderDetails.WorkflowId,
 		reminderDetails.RunId,
 	)
+	recordEvent(ctx, storage.EventCreated, reminderDetails)
 	return nil
 }
 
+// Update records a change to a pending reminder: either an edit, or the
+// recipient snoozing it.
 func Update(ctx context.Context, reminderDetails utils.ReminderDetails) error {
+	event := storage.EventUpdated
+	if reminderDetails.IsSnoozed() {
+		event = storage.EventSnoozed
+	}
 	fmt.Printf(
-		"\nSnoozing reminder %s (%s) until %s. workflowId=%s runId=%s\n",
+		"\nReminder %s (%s) %s until %s. workflowId=%s runId=%s\n",
 		reminderDetails.ReminderName,
 		reminderDetails.ReminderText,
-		utils.GetReminderTime(reminderDetails.FromTime, reminderDetails.NMinutes).Format(app.TIME_FORMAT),
+		event,
+		reminderDetails.GetReminderTime().Format(app.TIME_FORMAT),
 		reminderDetails.WorkflowId,
 		reminderDetails.RunId,
 	)
+	recordEvent(ctx, event, reminderDetails)
+	return nil
+}
+
+// Acknowledge records that the recipient marked a fired reminder done.
+func Acknowledge(ctx context.Context, reminderDetails utils.ReminderDetails) error {
+	fmt.Printf(
+		"\nReminder %s (%s) acknowledged. workflowId=%s runId=%s\n",
+		reminderDetails.ReminderName,
+		reminderDetails.ReminderText,
+		reminderDetails.WorkflowId,
+		reminderDetails.RunId,
+	)
+	recordEvent(ctx, storage.EventAcknowledged, reminderDetails)
 	return nil
 }
 
@@ -41,10 +72,12 @@ func Delete(ctx context.Context, reminderDetails utils.ReminderDetails) error {
 		reminderDetails.WorkflowId,
 		reminderDetails.RunId,
 	)
+	recordEvent(ctx, storage.EventCancelled, reminderDetails)
 	return nil
 }
 
-func SendReminder(ctx context.Context, reminderDetails utils.ReminderDetails) error {
+// SendReminder returns the ID of the WhatsApp message sent.
+func SendReminder(ctx context.Context, reminderDetails utils.ReminderDetails) (string, error) {
 	fmt.Printf(
 		"\nSending reminder to %s: %s (%s)! workflowId=%s runId=%s\n",
 		reminderDetails.Phone,
@@ -54,7 +87,186 @@ func SendReminder(ctx context.Context, reminderDetails utils.ReminderDetails) er
 		reminderDetails.RunId,
 	)
 	message := makeReminderMessage(reminderDetails)
-	return whatsapp.GetWhatsappClient().SendMessage(reminderDetails.Phone, message)
+	wc := whatsapp.GetWhatsappClient()
+	interactive := reminderDetails.AckWindow > 0 && reminderDetails.ReferenceId != ""
+	var messageId string
+	var err error
+	if template, ok := makeReminderTemplate(reminderDetails, interactive); ok && !notifiers.IsSessionOpen(ctx, reminderDetails.Phone) {
+		messageId, err = wc.SendTemplateMessage(ctx, reminderDetails.Phone, template)
+	} else if interactive {
+		messageId, err = wc.SendInteractiveMessage(ctx, reminderDetails.Phone, message, makeReminderButtons(reminderDetails))
+	} else {
+		messageId, err = wc.SendMessage(ctx, reminderDetails.Phone, message)
+	}
+	if err != nil {
+		return "", whatsappError(err)
+	}
+	reminderDetails.MessageId = messageId
+	recordEvent(ctx, storage.EventFired, reminderDetails)
+	return messageId, nil
+}
+
+// Notify delivers a fired reminder to one of its notification channels.
+// Channels that can't be used at all fail without being retried.
+func Notify(ctx context.Context, reminderDetails utils.ReminderDetails, channel utils.NotificationChannel) error {
+	fmt.Printf(
+		"\nSending reminder %s (%s) by %s to %s. workflowId=%s runId=%s\n",
+		reminderDetails.ReminderName,
+		reminderDetails.ReminderText,
+		channel.Type,
+		channel.Target,
+		reminderDetails.WorkflowId,
+		reminderDetails.RunId,
+	)
+	notifier, err := notifiers.GetNotifier(channel.Type)
+	if err != nil {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "NotifierError", err)
+	}
+	return notifier.Notify(ctx, channel.Target, makeReminderMessage(reminderDetails), reminderDetails)
+}
+
+// Escalate tells the step's phone that the reminder hasn't been acknowledged.
+func Escalate(ctx context.Context, reminderDetails utils.ReminderDetails, step utils.EscalationStep) error {
+	fmt.Printf(
+		"\nEscalating reminder %s (%s) to %s. workflowId=%s runId=%s\n",
+		reminderDetails.ReminderName,
+		reminderDetails.ReminderText,
+		step.Phone,
+		reminderDetails.WorkflowId,
+		reminderDetails.RunId,
+	)
+	wc := whatsapp.GetWhatsappClient()
+	var err error
+	if template, ok := makeEscalationTemplate(reminderDetails); ok && !notifiers.IsSessionOpen(ctx, step.Phone) {
+		_, err = wc.SendTemplateMessage(ctx, step.Phone, template)
+	} else {
+		message := fmt.Sprintf(
+			"Reminder for %s has not been acknowledged: %s: %s",
+			reminderDetails.Phone,
+			reminderDetails.ReminderName,
+			reminderDetails.ReminderText,
+		)
+		_, err = wc.SendMessage(ctx, step.Phone, message)
+	}
+	if err != nil {
+		return whatsappError(err)
+	}
+	recordEvent(ctx, storage.EventEscalated, reminderDetails)
+	return nil
+}
+
+// The type of application errors returned for failed Graph API requests.
+const WhatsappErrorType = "WhatsappGraphApiError"
+
+// whatsappError tells Temporal whether a failed WhatsApp request is worth
+// retrying. Retryable errors carry the Retry-After delay, if any, as details.
+func whatsappError(err error) error {
+	var rateErr *whatsapp.RateLimitedError
+	if errors.As(err, &rateErr) {
+		return temporal.NewApplicationError(rateErr.Error(), WhatsappErrorType, rateErr.RetryAfter)
+	}
+	var apiErr *whatsapp.GraphApiError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	if !apiErr.Retryable() {
+		return temporal.NewNonRetryableApplicationError(apiErr.Error(), WhatsappErrorType, apiErr)
+	}
+	return temporal.NewApplicationError(apiErr.Error(), WhatsappErrorType, apiErr.RetryAfter)
+}
+
+// RecordDelivery records a change in the delivery status of the reminder's
+// latest message.
+func RecordDelivery(ctx context.Context, reminderDetails utils.ReminderDetails) error {
+	fmt.Printf(
+		"\nReminder %s message %s is %s. workflowId=%s runId=%s\n",
+		reminderDetails.ReminderName,
+		reminderDetails.MessageId,
+		reminderDetails.DeliveryStatus,
+		reminderDetails.WorkflowId,
+		reminderDetails.RunId,
+	)
+	recordEvent(ctx, storage.DeliveryEvents[reminderDetails.DeliveryStatus], reminderDetails)
+	return nil
+}
+
+// PublishEvent sends a lifecycle event to every webhook subscription that
+// wants it, logging each attempt. It fails while any subscription can still
+// be retried, so that Temporal retries it; subscriptions that already have
+// the event, or have rejected it outright, are skipped on later attempts.
+func PublishEvent(ctx context.Context, event string, reminderDetails utils.ReminderDetails) error {
+	repository, err := storage.GetRepository()
+	if errors.Is(err, storage.ErrRepositoryNotConfigured) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	subscriptions, err := repository.ListSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	info := activity.GetInfo(ctx)
+	webhookEvent := webhooks.NewEvent(
+		fmt.Sprintf("%s-%s", info.WorkflowExecution.RunID, info.ActivityID),
+		event,
+		info.ScheduledTime,
+		reminderDetails,
+	)
+	previousDeliveries, err := repository.ListEventDeliveries(ctx, webhookEvent.Id)
+	if err != nil {
+		return err
+	}
+	finished := map[string]bool{}
+	for _, delivery := range previousDeliveries {
+		if delivery.Succeeded || webhooks.IsPermanentFailure(delivery.StatusCode) {
+			finished[delivery.SubscriptionId] = true
+		}
+	}
+
+	var firstErr error
+	for _, subscription := range subscriptions {
+		if !subscription.Matches(event) || finished[subscription.Id] {
+			continue
+		}
+		statusCode, err := webhooks.Deliver(subscription.Url, subscription.Secret, webhookEvent)
+		delivery := storage.WebhookDelivery{
+			SubscriptionId: subscription.Id,
+			EventId:        webhookEvent.Id,
+			Event:          event,
+			WorkflowId:     reminderDetails.WorkflowId,
+			Attempt:        int(info.Attempt),
+			StatusCode:     statusCode,
+			Succeeded:      err == nil,
+			AttemptedAt:    time.Now(),
+		}
+		if err != nil {
+			log.Println("Unable to send", event, "event to webhook", subscription.Id, err)
+			delivery.Error = err.Error()
+			if !webhooks.IsPermanentFailure(statusCode) && firstErr == nil {
+				firstErr = err
+			}
+		}
+		if err := repository.RecordWebhookDelivery(ctx, delivery); err != nil {
+			log.Println("Unable to record webhook delivery", subscription.Id, err)
+		}
+	}
+	return firstErr
+}
+
+// recordEvent saves the reminder to the repository, if there is one. Failures
+// are logged rather than returned, since Temporal holds the reminder itself.
+func recordEvent(ctx context.Context, event string, reminderDetails utils.ReminderDetails) {
+	repository, err := storage.GetRepository()
+	if errors.Is(err, storage.ErrRepositoryNotConfigured) {
+		return
+	}
+	if err == nil {
+		err = repository.RecordEvent(ctx, event, reminderDetails)
+	}
+	if err != nil {
+		log.Println("Unable to record reminder event", event, reminderDetails.WorkflowId, err)
+	}
 }
 
 func makeReminderMessage(reminderDetails utils.ReminderDetails) string {
@@ -64,3 +276,42 @@ func makeReminderMessage(reminderDetails utils.ReminderDetails) string {
 		reminderDetails.ReminderText,
 	)
 }
+
+// makeReminderTemplate picks the configured template for the reminder, if any.
+// Interactive reminders fall back to the plain template, and so can't be
+// snoozed from it, when there's no interactive one.
+func makeReminderTemplate(reminderDetails utils.ReminderDetails, interactive bool) (whatsapp.Template, bool) {
+	if interactive && app.WhatsappInteractiveReminderTemplate != "" {
+		template := whatsapp.Template{
+			Name:       app.WhatsappInteractiveReminderTemplate,
+			Language:   app.WhatsappTemplateLanguage,
+			Parameters: []string{reminderDetails.ReminderName, reminderDetails.ReminderText},
+		}
+		for _, button := range makeReminderButtons(reminderDetails) {
+			template.QuickReplyPayloads = append(template.QuickReplyPayloads, button.Id)
+		}
+		return template, true
+	}
+	return notifiers.MakeReminderTemplate(reminderDetails)
+}
+
+// makeEscalationTemplate returns the template escalations are sent with
+// outside of the session window, if one is configured.
+func makeEscalationTemplate(reminderDetails utils.ReminderDetails) (whatsapp.Template, bool) {
+	template := whatsapp.Template{
+		Name:       app.WhatsappEscalationTemplate,
+		Language:   app.WhatsappTemplateLanguage,
+		Parameters: []string{reminderDetails.Phone, reminderDetails.ReminderName, reminderDetails.ReminderText},
+	}
+	return template, template.Name != ""
+}
+
+// makeReminderButtons offers snooze and dismiss replies. Button IDs are the
+// equivalent text commands, so the webhook handles taps like typed replies.
+func makeReminderButtons(reminderDetails utils.ReminderDetails) []whatsapp.ReplyButton {
+	return []whatsapp.ReplyButton{
+		{Id: fmt.Sprintf("Snooze %s: 15m", reminderDetails.ReferenceId), Title: "Snooze 15m"},
+		{Id: fmt.Sprintf("Snooze %s: 1h", reminderDetails.ReferenceId), Title: "Snooze 1h"},
+		{Id: fmt.Sprintf("Done %s", reminderDetails.ReferenceId), Title: "Done"},
+	}
+}
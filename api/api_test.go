@@ -9,6 +9,7 @@ import (
 	"net/http/httptest"
 	"reminders/app"
 	"reminders/app/utils"
+	"reminders/app/whatsapp"
 	"testing"
 	"time"
 
@@ -19,6 +20,7 @@ import (
 )
 
 const FAKE_FROM_PHONE = "16505551111"
+const FAKE_APP_SECRET = "test-app-secret"
 
 type UnitTestSuite struct {
 	suite.Suite
@@ -29,6 +31,8 @@ type UnitTestSuite struct {
 
 func (s *UnitTestSuite) SetupTest() {
 	s.env = s.NewTestWorkflowEnvironment()
+	app.ENV = "TEST"
+	app.WhatsappAppSecret = FAKE_APP_SECRET
 }
 
 func (s *UnitTestSuite) AfterTest(suiteName, testName string) {
@@ -56,6 +60,49 @@ func (t *UnitTestSuite) TestCreateReminderHandlerEmpty() {
 	t.True(status == http.StatusBadRequest, fmt.Sprintf("status = %v, expected %v", status, http.StatusBadRequest))
 }
 
+func (t *UnitTestSuite) TestReminderListHandlerInvalidFilter() {
+	// Unparseable filters are rejected before Temporal is queried.
+	for _, url := range []string{"/reminders?from=yesterday", "/reminders?pageSize=0", "/reminders?pageToken=!!!", "/reminders?status=bogus", "/reminders?sort=bogus"} {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fail(err.Error())
+		}
+		r := httptest.NewRecorder()
+		m := mux.NewRouter()
+		requestHandler := RequestHandler{utils.MockWorkflowClient{}}
+		m.HandleFunc("/reminders", requestHandler.HandleList)
+		m.ServeHTTP(r, req)
+		t.True(r.Code == http.StatusBadRequest, fmt.Sprintf("%s: status = %v, expected %v", url, r.Code, http.StatusBadRequest))
+	}
+}
+
+func (t *UnitTestSuite) TestGetReminderHandlerUnknownReference() {
+	req, err := http.NewRequest("GET", "/reminders/not-a-reference", nil)
+	if err != nil {
+		t.Fail(err.Error())
+	}
+	r := httptest.NewRecorder()
+	m := mux.NewRouter()
+	requestHandler := RequestHandler{utils.MockWorkflowClient{}}
+	m.HandleFunc("/reminders/{referenceId}", requestHandler.HandleGet)
+	m.ServeHTTP(r, req)
+	t.True(r.Code == http.StatusNotFound, fmt.Sprintf("status = %v, expected %v", r.Code, http.StatusNotFound))
+}
+
+func (t *UnitTestSuite) TestDeleteReminderHandlerForgedReference() {
+	// Unsigned, base64-encoded workflow IDs were once valid references.
+	req, err := http.NewRequest("DELETE", "/reminders/cmVtaW5kZXItMTY1MDU1NTExMTEtMQ==", nil)
+	if err != nil {
+		t.Fail(err.Error())
+	}
+	r := httptest.NewRecorder()
+	m := mux.NewRouter()
+	requestHandler := RequestHandler{utils.MockWorkflowClient{}}
+	m.HandleFunc("/reminders/{referenceId}", requestHandler.HandleDelete)
+	m.ServeHTTP(r, req)
+	t.True(r.Code == http.StatusNotFound, fmt.Sprintf("status = %v, expected %v", r.Code, http.StatusNotFound))
+}
+
 func (t *UnitTestSuite) TestCreateReminderHandler() {
 	r := httptest.NewRecorder()
 	m := mux.NewRouter()
@@ -63,6 +110,23 @@ func (t *UnitTestSuite) TestCreateReminderHandler() {
 	t.True(resp.ReminderTime != "", "Empty reminder time.")
 }
 
+func (t *UnitTestSuite) TestCreateReminderHandlerIdempotent() {
+	// Retrying a request with the same idempotency key returns the original reminder.
+	body := fmt.Sprintf(`{
+		"NMinutes": 1,
+		"ReminderText": "Book return flight",
+		"ReminderName": "Flights",
+		"Phone": "%s",
+		"IdempotencyKey": "%d"
+	}`, FAKE_FROM_PHONE, time.Now().UnixNano())
+	requestHandler := RequestHandler{utils.MockWorkflowClient{}}
+	m := mux.NewRouter()
+	_, first := post(t, httptest.NewRecorder(), m, "/reminders", requestHandler.HandleCreate, body)
+	_, second := post(t, httptest.NewRecorder(), m, "/reminders", requestHandler.HandleCreate, body)
+	t.True(first.ReferenceId != "", "Empty reference ID.")
+	t.Equal(first.ReferenceId, second.ReferenceId)
+}
+
 func (t *UnitTestSuite) TestUpdateReminderHandler() {
 	r := httptest.NewRecorder()
 	m := mux.NewRouter()
@@ -158,6 +222,94 @@ func (t *UnitTestSuite) TestWhatsappResponseHandlerDelete() {
 	sendWhatsappMessageReminderRequest(t, r, m, whatsappDeleteBody)
 }
 
+func (t *UnitTestSuite) TestWhatsappResponseHandlerButtonReply() {
+	r := httptest.NewRecorder()
+	m := mux.NewRouter()
+	sendWhatsappMessageReminderRequest(t, r, m, whatsappCreateBody)
+	sendWhatsappMessageReminderRequest(t, r, m, whatsappButtonReplyBody)
+}
+
+func (t *UnitTestSuite) TestWhatsappResponseHandlerStatus() {
+	// Delivery receipts are acknowledged even when no reminder sent the message.
+	r := httptest.NewRecorder()
+	m := mux.NewRouter()
+	sendWhatsappMessageReminderRequest(t, r, m, whatsappStatusBody)
+}
+
+func (t *UnitTestSuite) TestWhatsappResponseHandlerRejectsUnsigned() {
+	// Forged and unsigned webhooks are rejected before anything is parsed.
+	signatures := map[string]string{
+		"missing":   "",
+		"forged":    whatsapp.Sign([]byte(whatsappCreateBody), "not-the-app-secret"),
+		"malformed": "sha256=zz",
+	}
+	for name, signature := range signatures {
+		r := httptest.NewRecorder()
+		m := mux.NewRouter()
+		requestHandler := RequestHandler{utils.MockWorkflowClient{}}
+		m.HandleFunc("/external/reminders/whatsapp", requestHandler.HandleWhatsappCallback)
+		req, err := http.NewRequest("POST", "/external/reminders/whatsapp", bytes.NewBufferString(whatsappCreateBody))
+		if err != nil {
+			t.Fail(err.Error())
+		}
+		if signature != "" {
+			req.Header.Set(whatsapp.SignatureHeader, signature)
+		}
+		m.ServeHTTP(r, req)
+		t.True(r.Code == http.StatusUnauthorized, fmt.Sprintf("%s: status = %v, expected %v", name, r.Code, http.StatusUnauthorized))
+	}
+}
+
+func (t *UnitTestSuite) TestOperatorHandlersRequireOperatorToken() {
+	// Subscriptions and debug counters are only served with OPERATOR_TOKEN.
+	defer func(token string) { app.OperatorToken = token }(app.OperatorToken)
+	tests := []struct {
+		token         string
+		authorization string
+		status        int
+	}{
+		{"", "", http.StatusServiceUnavailable},
+		{"operator-token", "", http.StatusUnauthorized},
+		{"operator-token", "Bearer not-the-token", http.StatusUnauthorized},
+	}
+	requestHandler := RequestHandler{utils.MockWorkflowClient{}}
+	for _, test := range tests {
+		app.OperatorToken = test.token
+		for _, route := range []struct {
+			method  string
+			url     string
+			handler http.HandlerFunc
+		}{
+			{"POST", "/webhooks", requestHandler.HandleWebhookCreate},
+			{"GET", "/debug/vars", requestHandler.HandleDebugVars},
+		} {
+			r := httptest.NewRecorder()
+			m := mux.NewRouter()
+			m.HandleFunc(route.url, route.handler)
+			req, err := http.NewRequest(route.method, route.url, bytes.NewBufferString(`{"Url": "https://example.com/hook"}`))
+			if err != nil {
+				t.Fail(err.Error())
+			}
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			m.ServeHTTP(r, req)
+			t.True(r.Code == test.status, fmt.Sprintf("%s %q: status = %v, expected %v", route.url, test.authorization, r.Code, test.status))
+		}
+	}
+
+	app.OperatorToken = "operator-token"
+	r := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/debug/vars", nil)
+	if err != nil {
+		t.Fail(err.Error())
+	}
+	req.Header.Set("Authorization", "Bearer operator-token")
+	requestHandler.HandleDebugVars(r, req)
+	t.True(r.Code == http.StatusOK, fmt.Sprintf("status = %v, expected %v", r.Code, http.StatusOK))
+	t.Contains(r.Body.String(), "whatsapp_signature_rejections")
+}
+
 func createReminder(t *UnitTestSuite, r *httptest.ResponseRecorder, m *mux.Router) utils.ReminderResponse {
 	body := fmt.Sprintf(`{
 		"NMinutes": 1,
@@ -173,20 +325,23 @@ func createReminder(t *UnitTestSuite, r *httptest.ResponseRecorder, m *mux.Route
 
 func sendWhatsappMessageReminderRequest(t *UnitTestSuite, r *httptest.ResponseRecorder, m *mux.Router, body string) {
 	requestHandler := RequestHandler{utils.MockWorkflowClient{}}
-	status, _ := post(t, r, m, "/external/reminders/whatsapp", requestHandler.HandleWhatsappCreate, body)
+	status, _ := post(t, r, m, "/external/reminders/whatsapp", requestHandler.HandleWhatsappCallback, body, whatsapp.Sign([]byte(body), FAKE_APP_SECRET))
 	t.True(status == http.StatusOK, fmt.Sprintf("status %v, expected %v", status, http.StatusOK))
 }
 
 func post(
 	t *UnitTestSuite, r *httptest.ResponseRecorder, m *mux.Router,
 	url string, handler func(http.ResponseWriter, *http.Request,
-	), body string) (int, utils.ReminderResponse) {
+	), body string, signature ...string) (int, utils.ReminderResponse) {
 	var query = []byte(body)
 	m.HandleFunc(url, handler)
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(query))
 	if err != nil {
 		t.Fail(err.Error())
 	}
+	for _, s := range signature {
+		req.Header.Set(whatsapp.SignatureHeader, s)
+	}
 	m.ServeHTTP(r, req)
 
 	var resp utils.ReminderResponse
@@ -326,3 +481,82 @@ var whatsappDeleteBody = fmt.Sprintf(`{
 	]
 	}
 `, FAKE_FROM_PHONE, FAKE_FROM_PHONE, FAKE_FROM_PHONE)
+
+var whatsappButtonReplyBody = fmt.Sprintf(`{
+	"object": "whatsapp_business_account",
+	"entry": [
+		{
+		"id": "0",
+		"changes": [
+			{
+			"value": {
+				"messaging_product": "whatsapp",
+				"metadata": {
+				"display_phone_number": "16505551111",
+				"phone_number_id": "123456123"
+				},
+				"contacts": [
+				{
+					"profile": {
+					"name": "test user name"
+					},
+					"wa_id": "%s"
+				}
+				],
+				"messages": [
+				{
+					"context": {
+					"from": "%s",
+					"id": "ABGGFlA5Fpa"
+					},
+					"from": "%s",
+					"id": "wamid.HBgLMTUwMjc0MTI0ODAVAgASGBQzRUIwMkJDMDQ5MkNCMzc1NUY0NgA=",
+					"timestamp": "1657724009",
+					"interactive": {
+					"type": "button_reply",
+					"button_reply": {
+						"id": "Snooze XXXXXXX: 15m",
+						"title": "Snooze 15m"
+					}
+					},
+					"type": "interactive"
+				}
+				]
+			},
+			"field": "messages"
+			}
+		]
+		}
+	]
+	}
+`, FAKE_FROM_PHONE, FAKE_FROM_PHONE, FAKE_FROM_PHONE)
+
+var whatsappStatusBody = fmt.Sprintf(`{
+	"object": "whatsapp_business_account",
+	"entry": [
+		{
+		"id": "0",
+		"changes": [
+			{
+			"value": {
+				"messaging_product": "whatsapp",
+				"metadata": {
+				"display_phone_number": "16505551111",
+				"phone_number_id": "123456123"
+				},
+				"statuses": [
+				{
+					"id": "wamid.HBgLMTUwMjc0MTI0ODAVAgARGBI0RjM2QjA0QzQ1NjI4RkYzNjUA",
+					"status": "delivered",
+					"timestamp": "1657724010",
+					"recipient_id": "%s"
+				}
+				]
+			},
+			"field": "messages"
+			}
+		]
+		}
+	]
+	}
+`, FAKE_FROM_PHONE)
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"reminders/app"
+	"reminders/app/codec"
+
+	"go.temporal.io/sdk/converter"
+)
+
+// CodecServerHandler serves POST /codec/encode and /codec/decode for the
+// Temporal Web UI, so operators can read reminders' encrypted payloads. Only
+// requests carrying CODEC_SERVER_TOKEN as a bearer token are served.
+func (h *RequestHandler) CodecServerHandler(w http.ResponseWriter, r *http.Request) {
+	if app.CodecServerCorsOrigin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", app.CodecServerCorsOrigin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Namespace")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Vary", "Origin")
+	}
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	payloadCodec, err := codec.GetPayloadCodec()
+	if errors.Is(err, codec.ErrPayloadEncryptionNotConfigured) || app.CodecServerToken == "" {
+		http.Error(w, "Codec server not configured; set PAYLOAD_ENCRYPTION_KEYS and CODEC_SERVER_TOKEN.", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !hasBearerToken(r, app.CodecServerToken) {
+		log.Println("Rejected unauthorized codec server request from", r.RemoteAddr)
+		http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+		return
+	}
+	converter.NewPayloadCodecHTTPHandler(payloadCodec).ServeHTTP(w, r)
+}
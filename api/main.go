@@ -1,27 +1,123 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"reminders/app"
+	"reminders/app/codec"
+	"reminders/app/storage"
 	"reminders/app/utils"
 	"reminders/app/whatsapp"
 	"reminders/app/workflows"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/tidwall/gjson"
+	"go.temporal.io/api/serviceerror"
 	"go.temporal.io/sdk/client"
+	"golang.org/x/exp/slices"
 )
 
 func (h *RequestHandler) ReminderListHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := makeReminderListFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reminders, nextPageToken, err := h.listReminders(r.Context(), filter)
+	if err != nil {
+		log.Printf("Failed to list workflows: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := utils.ReminderListResponse{
+		Reminders:     []utils.ReminderResponse{},
+		NextPageToken: base64.URLEncoding.EncodeToString(nextPageToken),
+	}
+	for _, reminder := range reminders {
+		resp.Reminders = append(resp.Reminders, utils.ReminderResponse{
+			ReferenceId:  reminder.ReferenceId,
+			ShortCode:    reminder.GetShortCode(),
+			ReminderName: reminder.ReminderName,
+			ReminderText: reminder.ReminderText,
+			ReminderTime: reminder.GetReminderTime().Format(app.TIME_FORMAT),
+			Recurrence:   reminder.Recurrence,
+			Status:       reminder.Status,
+			Phone:        reminder.Phone,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "RemindersList: %s\n", "")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// listReminders reads from the reminder repository when one is configured,
+// and from Temporal's visibility store otherwise.
+func (h *RequestHandler) listReminders(ctx context.Context, filter utils.ReminderListFilter) ([]utils.ReminderDetails, []byte, error) {
+	repository, err := storage.GetRepository()
+	if err == nil {
+		return repository.ListReminders(ctx, filter)
+	}
+	if !errors.Is(err, storage.ErrRepositoryNotConfigured) {
+		return nil, nil, err
+	}
+
+	c, err := h.c.GetClient()
+	if err != nil {
+		log.Println("Unable to create Temporal client", err)
+		return nil, nil, err
+	}
+	defer c.Close()
+	return workflows.ListWorkflows(c, filter)
+}
+
+// makeReminderListFilter reads the GET /reminders query string:
+// ?phone=&status=&from=<RFC3339>&to=<RFC3339>&sort=&pageSize=&pageToken=
+func makeReminderListFilter(r *http.Request) (utils.ReminderListFilter, error) {
+	query := r.URL.Query()
+	filter := utils.ReminderListFilter{
+		Phone:  query.Get("phone"),
+		Status: query.Get("status"),
+		Sort:   query.Get("sort"),
+	}
+	if filter.Status != "" && !slices.Contains(utils.ReminderStatuses, filter.Status) {
+		return filter, errors.New(fmt.Sprintf("Invalid status %s; expected one of %s", filter.Status, strings.Join(utils.ReminderStatuses, ", ")))
+	}
+	if filter.Sort != "" && !slices.Contains(utils.ReminderListSorts, filter.Sort) {
+		return filter, errors.New(fmt.Sprintf("Invalid sort %s; expected one of %s", filter.Sort, strings.Join(utils.ReminderListSorts, ", ")))
+	}
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return filter, errors.New(fmt.Sprintf("Invalid from time %s; expected RFC 3339", from))
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return filter, errors.New(fmt.Sprintf("Invalid to time %s; expected RFC 3339", to))
+		}
+	}
+	if pageSize := query.Get("pageSize"); pageSize != "" {
+		if filter.PageSize, err = strconv.Atoi(pageSize); err != nil || filter.PageSize < 1 {
+			return filter, errors.New(fmt.Sprintf("Invalid pageSize %s", pageSize))
+		}
+	}
+	if pageToken := query.Get("pageToken"); pageToken != "" {
+		if filter.NextPageToken, err = base64.URLEncoding.DecodeString(pageToken); err != nil {
+			return filter, errors.New("Invalid pageToken")
+		}
+	}
+	return filter, nil
 }
 
 func (h *RequestHandler) CreateReminderHandler(w http.ResponseWriter, r *http.Request) {
@@ -38,8 +134,20 @@ func (h *RequestHandler) CreateReminderHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 	input.FromTime = time.Now()
+	if input.IdempotencyKey == "" {
+		input.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	}
+	if err := input.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	c, err := client.NewClient(client.Options{})
+	options, err := codec.ClientOptions()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c, err := client.NewClient(options)
 	if err != nil {
 		log.Fatalln("unable to create Temporal client", err)
 	}
@@ -62,21 +170,90 @@ func makeReminderResponse(w http.ResponseWriter, r utils.ReminderDetails) {
 	json.NewEncoder(w).Encode(
 		map[string]string{
 			"ReferenceId":  r.ReferenceId,
+			"ShortCode":    r.GetShortCode(),
 			"ReminderName": r.ReminderName,
 			"ReminderText": r.ReminderText,
-			"ReminderTime": utils.GetReminderTime(r.FromTime, r.NMinutes).Format(app.TIME_FORMAT),
+			"ReminderTime": r.GetReminderTime().Format(app.TIME_FORMAT),
+			"Recurrence":   r.Recurrence,
 		})
 }
 
 func (h *RequestHandler) GetReminderHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	referenceId := vars["referenceId"]
+	workflowId, runId, err := utils.GetInternalIdsFromReferenceId(referenceId)
+	if err != nil {
+		http.Error(w, "Reminder not found.", http.StatusNotFound)
+		return
+	}
+
+	reminderDetails, err := h.getReminder(r.Context(), workflowId, runId)
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) || errors.Is(err, storage.ErrReminderNotFound) {
+		http.Error(w, "Reminder not found.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to query workflow %s (runID %s): %v", workflowId, runId, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	io.WriteString(w, `{"alive": true}`)
+	json.NewEncoder(w).Encode(utils.ReminderResponse{
+		ReferenceId:    referenceId,
+		ShortCode:      utils.MakeShortCode(workflowId),
+		ReminderName:   reminderDetails.ReminderName,
+		ReminderText:   reminderDetails.ReminderText,
+		ReminderTime:   reminderDetails.GetReminderTime().Format(app.TIME_FORMAT),
+		Recurrence:     reminderDetails.Recurrence,
+		Status:         reminderDetails.Status,
+		Phone:          reminderDetails.Phone,
+		SnoozeHistory:  reminderDetails.SnoozeHistory,
+		DeliveryStatus: reminderDetails.DeliveryStatus,
+		DeliveredAt:    formatOptionalTime(reminderDetails.DeliveredAt),
+		ReadAt:         formatOptionalTime(reminderDetails.ReadAt),
+		DeliveryError:  reminderDetails.DeliveryError,
+		Escalation:     reminderDetails.Escalation,
+		Channels:       reminderDetails.Channels,
+	})
+}
+
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(app.TIME_FORMAT)
+}
+
+// getReminder reads from the reminder repository when one is configured, and
+// queries the workflow otherwise.
+func (h *RequestHandler) getReminder(ctx context.Context, workflowId string, runId string) (utils.ReminderDetails, error) {
+	repository, err := storage.GetRepository()
+	if err == nil {
+		return repository.GetReminder(ctx, workflowId)
+	}
+	if !errors.Is(err, storage.ErrRepositoryNotConfigured) {
+		return utils.ReminderDetails{}, err
+	}
+
+	c, err := h.c.GetClient()
+	if err != nil {
+		log.Println("Unable to create Temporal client", err)
+		return utils.ReminderDetails{}, err
+	}
+	defer c.Close()
+	return workflows.GetWorkflow(c, workflowId, runId)
 }
 
 func (h *RequestHandler) UpdateReminderHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	referenceId := vars["referenceId"]
 	workflowId, runId, err := utils.GetInternalIdsFromReferenceId(referenceId)
+	if errors.Is(err, utils.ErrInvalidReferenceId) {
+		http.Error(w, "Reminder not found.", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -88,14 +265,32 @@ func (h *RequestHandler) UpdateReminderHandler(w http.ResponseWriter, r *http.Re
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	input.FromTime = time.Now()
+	if input.IdempotencyKey == "" {
+		input.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	}
+	if err := input.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	c, err := client.NewClient(client.Options{})
+	options, err := codec.ClientOptions()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c, err := client.NewClient(options)
 	if err != nil {
 		log.Fatalln("unable to create Temporal client", err)
 	}
 	defer c.Close()
 
 	reminderInfo, err := workflows.UpdateWorkflow(c, workflowId, runId, &input)
+	var rejected *workflows.UpdateRejectedError
+	if errors.As(err, &rejected) {
+		http.Error(w, rejected.Reason, http.StatusConflict)
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to update workflow: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -113,6 +308,10 @@ func (h *RequestHandler) DeleteReminderHandler(w http.ResponseWriter, r *http.Re
 	referenceId := vars["referenceId"]
 
 	workflowId, runId, err := utils.GetInternalIdsFromReferenceId(referenceId)
+	if errors.Is(err, utils.ErrInvalidReferenceId) {
+		http.Error(w, "Reminder not found.", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -155,48 +354,160 @@ func (h *RequestHandler) WhatsappResponseHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
-	results := gjson.GetManyBytes(
-		body,
-		"entry.0.changes.0.value.messages.0.from",
-		"entry.0.changes.0.value.messages.0.timestamp",
-		"entry.0.changes.0.value.messages.0.text.body",
-	)
-
-	fromPhone := results[0].Str
-	timestampStr := results[1].Str
-	message := results[2].Str
-
-	if fromPhone == "" {
-		http.Error(w, "From phone number not found in request.", http.StatusBadRequest)
+	err = whatsapp.VerifySignature(body, r.Header.Get(whatsapp.SignatureHeader), app.WhatsappAppSecret)
+	var signatureErr *whatsapp.SignatureError
+	if errors.As(err, &signatureErr) {
+		log.Println("Rejected WhatsApp webhook", err)
+		whatsappSignatureRejections.Add(signatureErr.Reason, 1)
+		http.Error(w, "Invalid signature.", http.StatusUnauthorized)
 		return
 	}
 
-	timestampInt, err := strconv.ParseInt(timestampStr, 10, 64)
+	payload, err := whatsapp.ParseWebhook(body)
 	if err != nil {
+		log.Println("Invalid WhatsApp webhook", err)
 		http.Error(w, "Invalid WhatsApp request.", http.StatusBadRequest)
 		return
 	}
-	fromTime := time.Unix(timestampInt, 0)
 
-	c, err := client.NewClient(client.Options{})
+	recordInboundMessages(r.Context(), payload)
+
+	// Failures are answered over WhatsApp; a non-200 response would only make
+	// Meta redeliver the whole batch.
+	handler := &whatsappWebhookHandler{ctx: r.Context()}
+	defer handler.Close()
+	if err := whatsapp.DispatchWebhook(payload, handler); err != nil {
+		log.Println("Error handling WhatsApp webhook", err)
+	}
+	w.WriteHeader(http.StatusOK)
+	if handler.reminderInfo.ReferenceId != "" {
+		makeReminderResponse(w, handler.reminderInfo)
+	}
+}
+
+// recordInboundMessages keeps track of when each sender last messaged us,
+// which decides whether reminders to them need a template.
+func recordInboundMessages(ctx context.Context, payload whatsapp.WebhookPayload) {
+	repository, err := storage.GetRepository()
 	if err != nil {
-		log.Fatalln("unable to create Temporal client", err)
+		return
 	}
-	defer c.Close()
+	for _, entry := range payload.Entry {
+		for _, change := range entry.Changes {
+			for _, message := range change.Value.Messages {
+				receivedAt, err := message.GetTime()
+				if err == nil {
+					err = repository.RecordInboundMessage(ctx, message.From, receivedAt)
+				}
+				if err != nil {
+					log.Println("Unable to record WhatsApp message from", message.From, err)
+				}
+			}
+		}
+	}
+}
 
-	reminderInfo, err := doMessageAction(c, fromPhone, message, fromTime)
+// whatsappWebhookHandler carries out the messages in a WhatsApp webhook.
+type whatsappWebhookHandler struct {
+	ctx          context.Context // the webhook request's
+	c            client.Client
+	reminderInfo utils.ReminderDetails // the last reminder acted on
+}
 
+func (h *whatsappWebhookHandler) HandleText(message whatsapp.Message, text string) error {
+	fromTime, err := message.GetTime()
 	if err != nil {
+		return err
+	}
+	c, err := h.getClient()
+	if err != nil {
+		return err
+	}
+
+	reminderInfo, err := doMessageAction(h.ctx, c, message.From, message.Id, text, fromTime)
+	var rejected *workflows.UpdateRejectedError
+	var commandErr *app.CommandError
+	if errors.As(err, &rejected) {
+		log.Print("Sending Whatsapp update rejected message")
+		whatsapp.GetWhatsappClient().SendMessage(h.ctx, message.From, fmt.Sprintf("Unable to update reminder: %s", rejected.Reason))
+	} else if errors.As(err, &commandErr) {
+		log.Print("Sending Whatsapp command error message")
+		sendErrorMessage(h.ctx, whatsapp.GetWhatsappClient(), message.From, commandErr)
+	} else if err != nil {
 		log.Print("Sending Whatsapp Error message")
-		whatsapp.GetWhatsappClient().SendMessage(fromPhone, "Unable to create reminder; unrecognized request format.")
-		// http.Error(w, "Unrecognized reminder request format.", http.StatusBadRequest)
-		w.WriteHeader(http.StatusOK)
+		whatsapp.GetWhatsappClient().SendMessage(h.ctx, message.From, "Unable to carry out your request; please try again.")
 	} else {
-		w.WriteHeader(http.StatusOK)
-		makeReminderResponse(w, reminderInfo)
+		h.reminderInfo = reminderInfo
 	}
+	return err
 }
 
+// HandleButtonReply treats a tap like the text command the button stands for,
+// since reply button IDs are those commands.
+func (h *whatsappWebhookHandler) HandleButtonReply(message whatsapp.Message, id string) error {
+	return h.HandleText(message, id)
+}
+
+func (h *whatsappWebhookHandler) HandleReaction(message whatsapp.Message, reaction whatsapp.Reaction) error {
+	log.Printf("WhatsApp reaction %q from %s to message %s", reaction.Emoji, message.From, reaction.MessageId)
+	return nil
+}
+
+// HandleStatus passes delivery and read receipts on to the reminder that sent
+// the message.
+func (h *whatsappWebhookHandler) HandleStatus(status whatsapp.Status) error {
+	log.Printf("WhatsApp message %s to %s is %s", status.Id, status.RecipientId, status.Status)
+	timestamp, err := status.GetTime()
+	if err != nil {
+		return err
+	}
+	deliveryStatus := utils.DeliveryStatusSignal{
+		MessageId: status.Id,
+		Status:    status.Status,
+		Timestamp: timestamp,
+	}
+	if len(status.Errors) > 0 {
+		deliveryStatus.Error = fmt.Sprintf("%d: %s", status.Errors[0].Code, status.Errors[0].Title)
+	}
+	c, err := h.getClient()
+	if err != nil {
+		return err
+	}
+	return workflows.SignalDeliveryStatus(c, deliveryStatus)
+}
+
+func (h *whatsappWebhookHandler) HandleUnsupported(message whatsapp.Message) error {
+	log.Printf("Unsupported WhatsApp %s message %s from %s", message.Type, message.Id, message.From)
+	_, err := whatsapp.GetWhatsappClient().SendMessage(h.ctx, message.From, "Sorry, reminders can only be created from text messages.")
+	return err
+}
+
+// getClient connects to Temporal the first time a message needs it.
+func (h *whatsappWebhookHandler) getClient() (client.Client, error) {
+	if h.c == nil {
+		options, err := codec.ClientOptions()
+		if err != nil {
+			return nil, err
+		}
+		c, err := client.NewClient(options)
+		if err != nil {
+			log.Println("Unable to create Temporal client", err)
+			return nil, err
+		}
+		h.c = c
+	}
+	return h.c, nil
+}
+
+func (h *whatsappWebhookHandler) Close() {
+	if h.c != nil {
+		h.c.Close()
+	}
+}
+
+// Count of webhook POSTs rejected for a bad signature, by reason; served at /debug/vars.
+var whatsappSignatureRejections = expvar.NewMap("whatsapp_signature_rejections")
+
 func handleVerification(w http.ResponseWriter, r *http.Request) {
 	queryString := r.URL.Query()
 	verifyToken, tokenFound := queryString["hub.verify_token"]
@@ -212,23 +523,70 @@ func handleVerification(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
-func doMessageAction(c client.Client, phone string, message string, fromTime time.Time) (utils.ReminderDetails, error) {
-	if name, text, nMinutes, err := app.ParseCreateReminderMessage(message); err == nil {
-		return createReminderFromMessage(c, phone, name, text, nMinutes, fromTime)
+// doMessageAction carries out the command in a WhatsApp message. The message
+// ID doubles as an idempotency key, since WhatsApp redelivers webhooks that
+// weren't acknowledged.
+func doMessageAction(ctx context.Context, c client.Client, phone string, messageId string, message string, fromTime time.Time) (utils.ReminderDetails, error) {
+	command, err := app.ParseCommand(message, fromTime, getSenderLocation(ctx, phone))
+	if err != nil {
+		return utils.ReminderDetails{}, err
+	}
+	if command.TimeZone != "" {
+		setSenderTimeZone(ctx, phone, command.TimeZone)
+	}
+	switch command.Type {
+	case app.CommandCreate, app.CommandCreateRecurring:
+		return createReminderFromMessage(ctx, c, phone, messageId, command.Name, command.Text, command.Schedule, command.ReminderTime, fromTime)
+	case app.CommandUpdate:
+		return updateReminderFromMessage(ctx, c, phone, command.ReferenceId, command.ReminderTime, fromTime)
+	case app.CommandSnooze:
+		return reminderActionFromMessage(ctx, c, phone, command.ReferenceId, utils.ReminderActionSignal{Action: utils.ReminderActionSnooze, SnoozeFor: command.SnoozeFor})
+	default:
+		return reminderActionFromMessage(ctx, c, phone, command.ReferenceId, utils.ReminderActionSignal{Action: command.Action})
+	}
+}
+
+// getSenderLocation returns the time zone phone last named, so that its
+// times needn't always name one, or else the default.
+func getSenderLocation(ctx context.Context, phone string) *time.Location {
+	repository, err := storage.GetRepository()
+	if err != nil {
+		return app.GetDefaultLocation()
+	}
+	timeZone, err := repository.GetTimeZone(ctx, phone)
+	if err != nil {
+		log.Println("Unable to look up time zone for", phone, err)
+	}
+	if timeZone == "" {
+		return app.GetDefaultLocation()
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		log.Println("Unrecognized time zone", timeZone, "for", phone, err)
+		return app.GetDefaultLocation()
+	}
+	return location
+}
+
+func setSenderTimeZone(ctx context.Context, phone string, timeZone string) {
+	repository, err := storage.GetRepository()
+	if err != nil {
+		return
 	}
-	if referenceId, nMinutes, err := app.ParseUpdateReminderMessage(message); err == nil {
-		return updateReminderFromMessage(c, phone, referenceId, nMinutes, fromTime)
+	if err := repository.SetTimeZone(ctx, phone, timeZone); err != nil {
+		log.Println("Unable to record time zone for", phone, err)
 	}
-	return utils.ReminderDetails{}, app.ReminderParseError(fmt.Sprintf("Unable to create reminder from request %s", message))
 }
 
-func createReminderFromMessage(c client.Client, phone string, reminderName string, reminderText string, nMinutes int, fromTime time.Time) (utils.ReminderDetails, error) {
+func createReminderFromMessage(ctx context.Context, c client.Client, phone string, messageId string, reminderName string, reminderText string, recurrence string, reminderTime time.Time, fromTime time.Time) (utils.ReminderDetails, error) {
 	input := utils.ReminderInput{
-		FromTime:     fromTime,
-		NMinutes:     nMinutes,
-		ReminderText: reminderText,
-		ReminderName: reminderName,
-		Phone:        phone,
+		FromTime:       fromTime,
+		ReminderTime:   reminderTime,
+		Recurrence:     recurrence,
+		IdempotencyKey: messageId,
+		ReminderText:   reminderText,
+		ReminderName:   reminderName,
+		Phone:          phone,
 	}
 	reminderInfo, err := workflows.StartWorkflow(c, &input)
 	log.Printf("Creating reminder for Phone %s", input.Phone)
@@ -237,30 +595,69 @@ func createReminderFromMessage(c client.Client, phone string, reminderName strin
 		return reminderInfo, err
 	}
 	log.Printf("Created reminder for workflowId %s runId %s", reminderInfo.WorkflowId, reminderInfo.RunId)
-	err = whatsapp.GetWhatsappClient().SendMessage(
-		phone,
-		fmt.Sprintf(
-			"Scheduled reminder %s: %s to remind at %s. Reference ID=%s",
-			reminderInfo.ReminderName,
-			reminderInfo.ReminderText,
-			utils.GetReminderTime(reminderInfo.FromTime, reminderInfo.NMinutes).Format(app.TIME_FORMAT),
-			reminderInfo.ReferenceId,
-		),
+	message := fmt.Sprintf(
+		"Scheduled reminder %s: %s to remind at %s. Code=%s",
+		reminderInfo.ReminderName,
+		reminderInfo.ReminderText,
+		reminderInfo.GetReminderTime().Format(app.TIME_FORMAT),
+		reminderInfo.GetShortCode(),
 	)
+	if reminderInfo.Recurrence != "" {
+		message = fmt.Sprintf("%s. Repeats %s", message, reminderInfo.Recurrence)
+	}
+	_, err = whatsapp.GetWhatsappClient().SendMessage(ctx, phone, message)
 	return reminderInfo, err
 }
 
-func updateReminderFromMessage(c client.Client, phone string, referenceId string, nMinutes int, fromTime time.Time) (utils.ReminderDetails, error) {
-	workflowId, runId, err := utils.GetInternalIdsFromReferenceId(referenceId)
+// resolveReference finds the reminder a WhatsApp command refers to, by either
+// its short code, which is looked up among the phone's reminders, or its
+// full reference ID.
+func resolveReference(c client.Client, phone string, reference string) (string, string, error) {
+	if shortCode, ok := utils.NormalizeShortCode(reference); ok {
+		return workflows.FindWorkflowByShortCode(c, phone, shortCode)
+	}
+	workflowId, runId, err := utils.GetInternalIdsFromReferenceId(reference)
+	if errors.Is(err, utils.ErrInvalidReferenceId) {
+		return resolveLegacyReference(c, phone, reference)
+	}
+	return workflowId, runId, err
+}
+
+// resolveLegacyReference accepts a reference issued before reference IDs were
+// signed, such as a button on a reminder already sent, but only from the
+// phone the reminder belongs to.
+func resolveLegacyReference(c client.Client, phone string, reference string) (string, string, error) {
+	workflowId, runId, err := utils.GetInternalIdsFromLegacyReferenceId(reference)
+	if err != nil {
+		return "", "", err
+	}
+	reminderPhone, err := workflows.GetPhone(c, workflowId, runId)
+	if err != nil || reminderPhone != phone {
+		log.Printf("Rejected legacy reference ID from %s. workflowId=%s runId=%s err=%v", phone, workflowId, runId, err)
+		return "", "", utils.ErrInvalidReferenceId
+	}
+	log.Printf("Accepted deprecated legacy reference ID from %s. workflowId=%s runId=%s", phone, workflowId, runId)
+	return workflowId, runId, nil
+}
+
+func updateReminderFromMessage(ctx context.Context, c client.Client, phone string, referenceId string, reminderTime time.Time, fromTime time.Time) (utils.ReminderDetails, error) {
+	workflowId, runId, err := resolveReference(c, phone, referenceId)
 	if err != nil {
 		log.Printf("Failed to update workflow; unrecognized reference ID: %s", referenceId)
 		return utils.ReminderDetails{}, err
 	}
+	reminderPhone, err := workflows.GetPhone(c, workflowId, runId)
+	if err != nil {
+		return utils.ReminderDetails{}, err
+	}
+	if reminderPhone != phone {
+		log.Printf("Phone %s attempted to update a reminder belonging to another phone. workflowId=%s runId=%s", phone, workflowId, runId)
+		return utils.ReminderDetails{}, errors.New("Unrecognized reference ID.")
+	}
 	log.Printf("Updating reminder for Phone %s. workflowId=%s runId=%s", phone, workflowId, runId)
 	input := utils.ReminderInput{
-		FromTime: fromTime,
-		NMinutes: nMinutes,
-		Phone:    phone,
+		FromTime:     fromTime,
+		ReminderTime: reminderTime,
 	}
 	reminderDetails, err := workflows.UpdateWorkflow(c, workflowId, runId, &input)
 	if err != nil {
@@ -268,24 +665,60 @@ func updateReminderFromMessage(c client.Client, phone string, referenceId string
 		return utils.ReminderDetails{}, err
 	}
 	log.Printf("Updated reminder for workflowId %s runId %s", reminderDetails.WorkflowId, reminderDetails.RunId)
-	err = whatsapp.GetWhatsappClient().SendMessage(
+	_, err = whatsapp.GetWhatsappClient().SendMessage(
+		ctx,
 		phone,
 		fmt.Sprintf(
-			"Updated reminder %s: %s at %s. referenceId=%s",
+			"Updated reminder %s: %s at %s. Code=%s",
 			reminderDetails.ReminderName,
 			reminderDetails.ReminderText,
-			utils.GetReminderTime(reminderDetails.FromTime, reminderDetails.NMinutes).Format(app.TIME_FORMAT),
-			reminderDetails.ReferenceId,
+			reminderDetails.GetReminderTime().Format(app.TIME_FORMAT),
+			reminderDetails.GetShortCode(),
 		),
 	)
 	return reminderDetails, err
 }
 
-func sendErrorMessage(wc whatsapp.IWhatsappClient, phone string, message string) {
-	wc.SendMessage(phone, fmt.Sprintf(
-		`Error creating reminder: "%s". Please use the format "New Reminder <Reminder Name>: <Reminder Text>: <1H 30M>"`,
-		message,
-	))
+func reminderActionFromMessage(ctx context.Context, c client.Client, phone string, referenceId string, action utils.ReminderActionSignal) (utils.ReminderDetails, error) {
+	var workflowId, runId string
+	var err error
+	if referenceId == "" {
+		workflowId, runId, err = workflows.FindFiredWorkflow(c, phone)
+	} else {
+		workflowId, runId, err = resolveReference(c, phone, referenceId)
+	}
+	if err != nil {
+		log.Printf("Failed to %s reminder for Phone %s: %v", action.Action, phone, err)
+		return utils.ReminderDetails{}, err
+	}
+	reminderPhone, err := workflows.GetPhone(c, workflowId, runId)
+	if err != nil {
+		return utils.ReminderDetails{}, err
+	}
+	if reminderPhone != phone {
+		log.Printf("Phone %s attempted to %s a reminder belonging to another phone. workflowId=%s runId=%s", phone, action.Action, workflowId, runId)
+		return utils.ReminderDetails{}, errors.New("Unrecognized reference ID.")
+	}
+	err = workflows.SignalReminderAction(c, workflowId, runId, action)
+	if err != nil {
+		log.Printf("Failed to %s workflow: %v", action.Action, err)
+		return utils.ReminderDetails{}, err
+	}
+	reminderDetails := utils.ReminderDetails{Phone: phone, WorkflowId: workflowId, RunId: runId}
+	reminderDetails.ReferenceId, _ = utils.MakeReferenceId(workflowId)
+	log.Printf("Sent %s action to workflowId %s runId %s", action.Action, workflowId, runId)
+	confirmation := "Reminder dismissed."
+	if action.Action == utils.ReminderActionSnooze {
+		confirmation = fmt.Sprintf("Reminder snoozed for %s.", action.SnoozeFor)
+	}
+	_, err = whatsapp.GetWhatsappClient().SendMessage(ctx, phone, confirmation)
+	return reminderDetails, err
+}
+
+// sendErrorMessage tells the sender what was wrong with their command and
+// how it should be written.
+func sendErrorMessage(ctx context.Context, wc whatsapp.IWhatsappClient, phone string, err *app.CommandError) {
+	wc.SendMessage(ctx, phone, fmt.Sprintf("%s. Please use the format:\n%s", err.Error(), err.Usage()))
 }
 
 type RequestHandler struct {
@@ -302,11 +735,15 @@ type WorkflowClient struct {
 }
 
 func (w WorkflowClient) GetClient() (client.Client, error) {
-	return client.NewClient(client.Options{})
+	options, err := codec.ClientOptions()
+	if err != nil {
+		return nil, err
+	}
+	return client.NewClient(options)
 }
 
 func (h RequestHandler) HandleList(writer http.ResponseWriter, reader *http.Request) {
-	h.DeleteReminderHandler(writer, reader)
+	h.ReminderListHandler(writer, reader)
 }
 
 func (h RequestHandler) HandleCreate(writer http.ResponseWriter, reader *http.Request) {
@@ -329,7 +766,38 @@ func (h RequestHandler) HandleWhatsappCallback(writer http.ResponseWriter, reade
 	h.WhatsappResponseHandler(writer, reader)
 }
 
+func (h RequestHandler) HandleCodecServer(writer http.ResponseWriter, reader *http.Request) {
+	h.CodecServerHandler(writer, reader)
+}
+
+func (h RequestHandler) HandleWebhookList(writer http.ResponseWriter, reader *http.Request) {
+	h.WebhookListHandler(writer, reader)
+}
+
+func (h RequestHandler) HandleWebhookCreate(writer http.ResponseWriter, reader *http.Request) {
+	h.CreateWebhookHandler(writer, reader)
+}
+
+func (h RequestHandler) HandleWebhookGet(writer http.ResponseWriter, reader *http.Request) {
+	h.GetWebhookHandler(writer, reader)
+}
+
+func (h RequestHandler) HandleWebhookDelete(writer http.ResponseWriter, reader *http.Request) {
+	h.DeleteWebhookHandler(writer, reader)
+}
+
+func (h RequestHandler) HandleWebhookDeliveries(writer http.ResponseWriter, reader *http.Request) {
+	h.WebhookDeliveryListHandler(writer, reader)
+}
+
+func (h RequestHandler) HandleDebugVars(writer http.ResponseWriter, reader *http.Request) {
+	h.DebugVarsHandler(writer, reader)
+}
+
 func main() {
+	if _, err := codec.ClientOptions(); err != nil {
+		log.Fatalln(err)
+	}
 	r := mux.NewRouter()
 	requestHandler := RequestHandler{WorkflowClient{}}
 	r.HandleFunc("/reminders", requestHandler.HandleList).Methods("GET")
@@ -337,8 +805,15 @@ func main() {
 	r.HandleFunc("/reminders/{referenceId}", requestHandler.HandleGet).Methods("GET")
 	r.HandleFunc("/reminders/{referenceId}", requestHandler.HandleUpdate).Methods("PUT")
 	r.HandleFunc("/reminders/{referenceId}", requestHandler.HandleDelete).Methods("DELETE")
+	r.HandleFunc("/webhooks", requestHandler.HandleWebhookList).Methods("GET")
+	r.HandleFunc("/webhooks", requestHandler.HandleWebhookCreate).Methods("POST")
+	r.HandleFunc("/webhooks/{id}", requestHandler.HandleWebhookGet).Methods("GET")
+	r.HandleFunc("/webhooks/{id}", requestHandler.HandleWebhookDelete).Methods("DELETE")
+	r.HandleFunc("/webhooks/{id}/deliveries", requestHandler.HandleWebhookDeliveries).Methods("GET")
 	r.HandleFunc("/external/reminders/whatsapp", requestHandler.HandleWhatsappCallback).Methods("GET")
 	r.HandleFunc("/external/reminders/whatsapp", requestHandler.HandleWhatsappCallback).Methods("POST")
+	r.HandleFunc("/codec/{operation:encode|decode}", requestHandler.HandleCodecServer).Methods("POST", "OPTIONS")
+	r.HandleFunc("/debug/vars", requestHandler.HandleDebugVars).Methods("GET")
 	http.Handle("/", r)
 
 	log.Fatal(http.ListenAndServe(":8000", r))
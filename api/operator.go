@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"log"
+	"net/http"
+
+	"reminders/app"
+)
+
+// authorizeOperator writes an error response and returns false unless the
+// request carries OPERATOR_TOKEN as a bearer token. Operator endpoints are
+// disabled while no token is set.
+func authorizeOperator(w http.ResponseWriter, r *http.Request) bool {
+	if app.OperatorToken == "" {
+		http.Error(w, "Operator endpoints not configured; set OPERATOR_TOKEN.", http.StatusServiceUnavailable)
+		return false
+	}
+	if !hasBearerToken(r, app.OperatorToken) {
+		log.Println("Rejected unauthorized operator request from", r.RemoteAddr)
+		http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// DebugVarsHandler serves the process's expvar counters to operators.
+func (h *RequestHandler) DebugVarsHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeOperator(w, r) {
+		return
+	}
+	expvar.Handler().ServeHTTP(w, r)
+}
+
+func hasBearerToken(r *http.Request, token string) bool {
+	expected := "Bearer " + token
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) == 1
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"reminders/app/storage"
+
+	"github.com/gorilla/mux"
+	"github.com/oklog/ulid/v2"
+)
+
+const defaultDeliveryListLimit = 50
+const maxDeliveryListLimit = 100
+
+type WebhookSubscriptionInput struct {
+	Url    string
+	Events []string // defaults to every event
+	Secret string   // generated when empty
+}
+
+type WebhookSubscriptionResponse struct {
+	Id        string
+	Url       string
+	Events    []string
+	CreatedAt time.Time
+	Secret    string `json:",omitempty"` // only returned on creation
+}
+
+func makeWebhookSubscriptionResponse(subscription storage.WebhookSubscription) WebhookSubscriptionResponse {
+	return WebhookSubscriptionResponse{
+		Id:        subscription.Id,
+		Url:       subscription.Url,
+		Events:    subscription.Events,
+		CreatedAt: subscription.CreatedAt,
+	}
+}
+
+// getSubscriptionRepository writes an error response and returns nil when
+// there's no repository; subscriptions can only be stored in one.
+func getSubscriptionRepository(w http.ResponseWriter) storage.Repository {
+	repository, err := storage.GetRepository()
+	if errors.Is(err, storage.ErrRepositoryNotConfigured) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	return repository
+}
+
+func (h *RequestHandler) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeOperator(w, r) {
+		return
+	}
+	var input WebhookSubscriptionInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	subscription := storage.WebhookSubscription{
+		Id:        ulid.Make().String(),
+		Url:       input.Url,
+		Secret:    input.Secret,
+		Events:    input.Events,
+		CreatedAt: time.Now().UTC(),
+	}
+	if subscription.Events == nil {
+		subscription.Events = []string{}
+	}
+	if err := subscription.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if subscription.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		subscription.Secret = hex.EncodeToString(secret)
+	}
+	repository := getSubscriptionRepository(w)
+	if repository == nil {
+		return
+	}
+	if err := repository.CreateSubscription(r.Context(), subscription); err != nil {
+		log.Printf("Failed to create webhook subscription: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Created webhook subscription %s for %s", subscription.Id, subscription.Url)
+	resp := makeWebhookSubscriptionResponse(subscription)
+	resp.Secret = subscription.Secret
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *RequestHandler) WebhookListHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeOperator(w, r) {
+		return
+	}
+	repository := getSubscriptionRepository(w)
+	if repository == nil {
+		return
+	}
+	subscriptions, err := repository.ListSubscriptions(r.Context())
+	if err != nil {
+		log.Printf("Failed to list webhook subscriptions: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := []WebhookSubscriptionResponse{}
+	for _, subscription := range subscriptions {
+		resp = append(resp, makeWebhookSubscriptionResponse(subscription))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *RequestHandler) GetWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeOperator(w, r) {
+		return
+	}
+	repository := getSubscriptionRepository(w)
+	if repository == nil {
+		return
+	}
+	subscription, err := repository.GetSubscription(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, storage.ErrSubscriptionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(makeWebhookSubscriptionResponse(subscription))
+}
+
+func (h *RequestHandler) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeOperator(w, r) {
+		return
+	}
+	repository := getSubscriptionRepository(w)
+	if repository == nil {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	err := repository.DeleteSubscription(r.Context(), id)
+	if errors.Is(err, storage.ErrSubscriptionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete webhook subscription %s: %v", id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Deleted webhook subscription %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebhookDeliveryListHandler serves GET /webhooks/{id}/deliveries?limit=,
+// latest first.
+func (h *RequestHandler) WebhookDeliveryListHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeOperator(w, r) {
+		return
+	}
+	limit := defaultDeliveryListLimit
+	if param := r.URL.Query().Get("limit"); param != "" {
+		var err error
+		if limit, err = strconv.Atoi(param); err != nil || limit < 1 {
+			http.Error(w, "Invalid limit "+param, http.StatusBadRequest)
+			return
+		}
+		if limit > maxDeliveryListLimit {
+			limit = maxDeliveryListLimit
+		}
+	}
+	repository := getSubscriptionRepository(w)
+	if repository == nil {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if _, err := repository.GetSubscription(r.Context(), id); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, storage.ErrSubscriptionNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	deliveries, err := repository.ListWebhookDeliveries(r.Context(), id, limit)
+	if err != nil {
+		log.Printf("Failed to list webhook deliveries for %s: %v", id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(deliveries)
+}
@@ -1,17 +1,116 @@
 package codec
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	b64 "encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 )
 
-func Encode(data string) string {
-	return b64.StdEncoding.EncodeToString([]byte(data))
+// Tokens are "<key ID>.<data>.<MAC>", with the data and MAC base64url
+// encoded, so they are safe in URLs and WhatsApp messages.
+const macLength = 16
+
+var encoding = b64.RawURLEncoding
+
+var keyIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+const minSecretLength = 16
+
+// ErrInvalidToken is returned for tokens that are malformed, have been
+// tampered with, or weren't signed by any key in the keyring.
+var ErrInvalidToken = errors.New("Invalid token.")
+
+func KeyringError(reason string) error {
+	return errors.New(fmt.Sprintf("Invalid keyring: %s", reason))
+}
+
+// Key is an HMAC key, identified in the tokens it signs.
+type Key struct {
+	Id     string
+	Secret []byte
+}
+
+// Keyring signs with its first key and verifies with any of them, so keys
+// can be rotated by adding a new key first and later dropping the old one.
+type Keyring struct {
+	keys []Key
 }
 
-func Decode(data string) (string, error) {
-	decoded, err := b64.StdEncoding.DecodeString(data)
+func NewKeyring(keys ...Key) (Keyring, error) {
+	if len(keys) == 0 {
+		return Keyring{}, KeyringError("no keys")
+	}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if !keyIdPattern.MatchString(key.Id) {
+			return Keyring{}, KeyringError(fmt.Sprintf("key ID %q must be letters, digits, - or _", key.Id))
+		}
+		if seen[key.Id] {
+			return Keyring{}, KeyringError(fmt.Sprintf("key ID %s is repeated", key.Id))
+		}
+		if len(key.Secret) < minSecretLength {
+			return Keyring{}, KeyringError(fmt.Sprintf("key %s is shorter than %d bytes", key.Id, minSecretLength))
+		}
+		seen[key.Id] = true
+	}
+	return Keyring{keys: keys}, nil
+}
+
+// ParseKeyring reads keys written as "id:secret,id:secret", newest first.
+func ParseKeyring(spec string) (Keyring, error) {
+	var keys []Key
+	for _, entry := range strings.Split(spec, ",") {
+		id, secret, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return Keyring{}, KeyringError("keys must be written as id:secret")
+		}
+		keys = append(keys, Key{Id: id, Secret: []byte(secret)})
+	}
+	return NewKeyring(keys...)
+}
+
+// Sign returns an opaque token for data.
+func (k Keyring) Sign(data string) (string, error) {
+	if len(k.keys) == 0 {
+		return "", KeyringError("no keys")
+	}
+	key := k.keys[0]
+	signed := key.Id + "." + encoding.EncodeToString([]byte(data))
+	return signed + "." + encoding.EncodeToString(mac(key, signed)), nil
+}
+
+// Verify returns the data a token was signed for, or ErrInvalidToken.
+func (k Keyring) Verify(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalidToken
+	}
+	tokenMac, err := encoding.DecodeString(parts[2])
 	if err != nil {
-		return "", err
+		return "", ErrInvalidToken
+	}
+	for _, key := range k.keys {
+		if key.Id != parts[0] {
+			continue
+		}
+		if !hmac.Equal(tokenMac, mac(key, parts[0]+"."+parts[1])) {
+			return "", ErrInvalidToken
+		}
+		data, err := encoding.DecodeString(parts[1])
+		if err != nil {
+			return "", ErrInvalidToken
+		}
+		return string(data), nil
 	}
-	return string(decoded), nil
+	return "", ErrInvalidToken
+}
+
+func mac(key Key, signed string) []byte {
+	h := hmac.New(sha256.New, key.Secret)
+	h.Write([]byte(signed))
+	return h.Sum(nil)[:macLength]
 }
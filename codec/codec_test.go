@@ -0,0 +1,62 @@
+package codec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SignAndVerify(t *testing.T) {
+	keyring, err := ParseKeyring("2022-07:a-long-enough-secret-1")
+	require.NoError(t, err)
+	token, err := keyring.Sign("reminder-16505551111-01G7ZR6RQH8K3N5M8V1X2Y3Z4A")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(token, "2022-07."))
+	require.NotContains(t, token, "reminder-16505551111")
+	data, err := keyring.Verify(token)
+	require.NoError(t, err)
+	require.Equal(t, "reminder-16505551111-01G7ZR6RQH8K3N5M8V1X2Y3Z4A", data)
+}
+
+func Test_VerifyRejectsTamperedTokens(t *testing.T) {
+	keyring, _ := ParseKeyring("2022-07:a-long-enough-secret-1")
+	foreign, _ := ParseKeyring("2022-07:someone-elses-secret-1")
+	token, _ := keyring.Sign("reminder-16505551111-1")
+	forged, _ := foreign.Sign("reminder-16505551111-1")
+	parts := strings.Split(token, ".")
+	otherData, _ := keyring.Sign("reminder-16505552222-1")
+	for _, tampered := range []string{
+		"",
+		"cmVtaW5kZXItMTY1MDU1NTExMTEtMQ==", // unsigned base64, as reference IDs used to be
+		forged,
+		parts[0] + "." + strings.Split(otherData, ".")[1] + "." + parts[2],
+		"2022-06." + parts[1] + "." + parts[2],
+		parts[0] + "." + parts[1] + "." + parts[2][1:],
+		token + ".",
+	} {
+		_, err := keyring.Verify(tampered)
+		require.ErrorIs(t, err, ErrInvalidToken, tampered)
+	}
+}
+
+func Test_KeyRotation(t *testing.T) {
+	old, _ := ParseKeyring("2022-07:a-long-enough-secret-1")
+	rotated, err := ParseKeyring("2022-08:a-long-enough-secret-2, 2022-07:a-long-enough-secret-1")
+	require.NoError(t, err)
+	oldToken, _ := old.Sign("reminder-1")
+	data, err := rotated.Verify(oldToken)
+	require.NoError(t, err)
+	require.Equal(t, "reminder-1", data)
+	newToken, _ := rotated.Sign("reminder-1")
+	require.True(t, strings.HasPrefix(newToken, "2022-08."))
+	_, err = old.Verify(newToken)
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func Test_ParseKeyringInvalid(t *testing.T) {
+	for _, spec := range []string{"", "no-secret", "k1:short", "k.1:a-long-enough-secret-1", "k1:a-long-enough-secret-1,k1:a-long-enough-secret-2"} {
+		_, err := ParseKeyring(spec)
+		require.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,163 @@
+package codec
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+
+	"reminders/app"
+
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+)
+
+// Metadata of encrypted payloads. The encrypted data is a whole serialized
+// payload, so its original metadata is hidden too.
+const (
+	MetadataEncodingEncrypted = "binary/encrypted"
+	MetadataEncryptionKeyId   = "encryption-key-id"
+)
+
+func PayloadDecryptionError(reason string) error {
+	return errors.New(fmt.Sprintf("Unable to decrypt payload: %s", reason))
+}
+
+// EncryptionCodec is a converter.PayloadCodec that encrypts payloads with
+// AES-256-GCM, using the SHA-256 of each key's secret as the AES key. It
+// encrypts with the keyring's first key and decrypts with any of them;
+// payloads that were never encrypted are passed through.
+type EncryptionCodec struct {
+	keyId string
+	aeads map[string]cipher.AEAD
+}
+
+func NewEncryptionCodec(keyring Keyring) (*EncryptionCodec, error) {
+	if len(keyring.keys) == 0 {
+		return nil, KeyringError("no keys")
+	}
+	codec := &EncryptionCodec{keyId: keyring.keys[0].Id, aeads: map[string]cipher.AEAD{}}
+	for _, key := range keyring.keys {
+		aesKey := sha256.Sum256(key.Secret)
+		block, err := aes.NewCipher(aesKey[:])
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		codec.aeads[key.Id] = aead
+	}
+	return codec, nil
+}
+
+func (e *EncryptionCodec) Encode(payloads []*commonpb.Payload) ([]*commonpb.Payload, error) {
+	aead := e.aeads[e.keyId]
+	result := make([]*commonpb.Payload, len(payloads))
+	for i, payload := range payloads {
+		plaintext, err := payload.Marshal()
+		if err != nil {
+			return payloads, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return payloads, err
+		}
+		result[i] = &commonpb.Payload{
+			Metadata: map[string][]byte{
+				converter.MetadataEncoding: []byte(MetadataEncodingEncrypted),
+				MetadataEncryptionKeyId:    []byte(e.keyId),
+			},
+			// The key ID is authenticated, so it can't be swapped for another
+			Data: aead.Seal(nonce, nonce, plaintext, []byte(e.keyId)),
+		}
+	}
+	return result, nil
+}
+
+func (e *EncryptionCodec) Decode(payloads []*commonpb.Payload) ([]*commonpb.Payload, error) {
+	result := make([]*commonpb.Payload, len(payloads))
+	for i, payload := range payloads {
+		if string(payload.GetMetadata()[converter.MetadataEncoding]) != MetadataEncodingEncrypted {
+			result[i] = payload
+			continue
+		}
+		keyId := string(payload.GetMetadata()[MetadataEncryptionKeyId])
+		aead, ok := e.aeads[keyId]
+		if !ok {
+			return payloads, PayloadDecryptionError(fmt.Sprintf("unknown key %s", keyId))
+		}
+		if len(payload.Data) < aead.NonceSize() {
+			return payloads, PayloadDecryptionError("data is too short")
+		}
+		nonce, ciphertext := payload.Data[:aead.NonceSize()], payload.Data[aead.NonceSize():]
+		plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(keyId))
+		if err != nil {
+			return payloads, PayloadDecryptionError(err.Error())
+		}
+		result[i] = &commonpb.Payload{}
+		if err := result[i].Unmarshal(plaintext); err != nil {
+			return payloads, PayloadDecryptionError(err.Error())
+		}
+	}
+	return result, nil
+}
+
+var payloadCodec *EncryptionCodec
+var payloadCodecErr error
+var payloadCodecOnce sync.Once
+
+func PayloadEncryptionKeysError(err error) error {
+	return errors.New(fmt.Sprintf("Unable to load PAYLOAD_ENCRYPTION_KEYS: %s", err.Error()))
+}
+
+// ErrPayloadEncryptionNotConfigured is returned by GetPayloadCodec when
+// PAYLOAD_ENCRYPTION_KEYS is unset, in which case payloads are stored as is.
+var ErrPayloadEncryptionNotConfigured = errors.New("Payload encryption not configured; set PAYLOAD_ENCRYPTION_KEYS.")
+
+// GetPayloadCodec returns the process-wide codec, configured from the
+// environment.
+func GetPayloadCodec() (*EncryptionCodec, error) {
+	payloadCodecOnce.Do(func() {
+		if app.PayloadEncryptionKeys == "" {
+			payloadCodecErr = ErrPayloadEncryptionNotConfigured
+			return
+		}
+		keyring, err := ParseKeyring(app.PayloadEncryptionKeys)
+		if err != nil {
+			payloadCodecErr = err
+			return
+		}
+		payloadCodec, payloadCodecErr = NewEncryptionCodec(keyring)
+	})
+	return payloadCodec, payloadCodecErr
+}
+
+// ClientOptions returns the options every Temporal client in the app is
+// created with, so that all of them agree on how payloads are encrypted.
+func ClientOptions() (client.Options, error) {
+	dataConverter, err := DataConverter()
+	if err != nil {
+		return client.Options{}, err
+	}
+	return client.Options{DataConverter: dataConverter}, nil
+}
+
+// DataConverter returns the converter clients write payloads, such as memos,
+// with. Search attributes are always written with the default converter. It
+// fails if PAYLOAD_ENCRYPTION_KEYS is set but can't be loaded.
+func DataConverter() (converter.DataConverter, error) {
+	payloadCodec, err := GetPayloadCodec()
+	if errors.Is(err, ErrPayloadEncryptionNotConfigured) {
+		return converter.GetDefaultDataConverter(), nil
+	}
+	if err != nil {
+		return nil, PayloadEncryptionKeysError(err)
+	}
+	return converter.NewCodecDataConverter(converter.GetDefaultDataConverter(), payloadCodec), nil
+}
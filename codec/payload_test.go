@@ -0,0 +1,102 @@
+package codec
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"reminders/app"
+
+	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/converter"
+)
+
+type testReminder struct {
+	Phone        string
+	ReminderText string
+}
+
+func newTestCodec(t *testing.T, spec string) *EncryptionCodec {
+	keyring, err := ParseKeyring(spec)
+	require.NoError(t, err)
+	codec, err := NewEncryptionCodec(keyring)
+	require.NoError(t, err)
+	return codec
+}
+
+func Test_EncryptionCodecRoundTrip(t *testing.T) {
+	dataConverter := converter.NewCodecDataConverter(converter.GetDefaultDataConverter(), newTestCodec(t, "2022-07:a-long-enough-secret-1"))
+	reminder := testReminder{Phone: "16505551111", ReminderText: "Pick up prescription"}
+	payload, err := dataConverter.ToPayload(reminder)
+	require.NoError(t, err)
+	require.Equal(t, MetadataEncodingEncrypted, string(payload.Metadata[converter.MetadataEncoding]))
+	require.Equal(t, "2022-07", string(payload.Metadata[MetadataEncryptionKeyId]))
+	require.False(t, bytes.Contains(payload.Data, []byte("16505551111")))
+	require.False(t, bytes.Contains(payload.Data, []byte("json/plain")))
+
+	var decoded testReminder
+	require.NoError(t, dataConverter.FromPayload(payload, &decoded))
+	require.Equal(t, reminder, decoded)
+}
+
+func Test_EncryptionCodecPassesThroughPlainPayloads(t *testing.T) {
+	plain, err := converter.GetDefaultDataConverter().ToPayload(testReminder{Phone: "16505551111"})
+	require.NoError(t, err)
+	decoded, err := newTestCodec(t, "2022-07:a-long-enough-secret-1").Decode([]*commonpb.Payload{plain})
+	require.NoError(t, err)
+	require.Equal(t, plain, decoded[0])
+}
+
+func Test_EncryptionCodecKeyRotation(t *testing.T) {
+	old := newTestCodec(t, "2022-07:a-long-enough-secret-1")
+	rotated := newTestCodec(t, "2022-08:a-long-enough-secret-2,2022-07:a-long-enough-secret-1")
+	plain := &commonpb.Payload{Metadata: map[string][]byte{converter.MetadataEncoding: []byte("json/plain")}, Data: []byte(`"hello"`)}
+
+	encrypted, err := old.Encode([]*commonpb.Payload{plain})
+	require.NoError(t, err)
+	decoded, err := rotated.Decode(encrypted)
+	require.NoError(t, err)
+	require.Equal(t, plain.Data, decoded[0].Data)
+
+	encrypted, err = rotated.Encode([]*commonpb.Payload{plain})
+	require.NoError(t, err)
+	require.Equal(t, "2022-08", string(encrypted[0].Metadata[MetadataEncryptionKeyId]))
+	_, err = old.Decode(encrypted)
+	require.Error(t, err)
+}
+
+func Test_EncryptionCodecRejectsTamperedPayloads(t *testing.T) {
+	codec := newTestCodec(t, "2022-07:a-long-enough-secret-1,2022-06:a-long-enough-secret-0")
+	plain := &commonpb.Payload{Metadata: map[string][]byte{converter.MetadataEncoding: []byte("json/plain")}, Data: []byte(`"hello"`)}
+	encrypted, err := codec.Encode([]*commonpb.Payload{plain})
+	require.NoError(t, err)
+
+	flipped := *encrypted[0]
+	flipped.Data = append([]byte{}, encrypted[0].Data...)
+	flipped.Data[len(flipped.Data)-1] ^= 1
+	relabelled := *encrypted[0]
+	relabelled.Metadata = map[string][]byte{
+		converter.MetadataEncoding: []byte(MetadataEncodingEncrypted),
+		MetadataEncryptionKeyId:    []byte("2022-06"),
+	}
+	truncated := *encrypted[0]
+	truncated.Data = encrypted[0].Data[:4]
+	for _, payload := range []*commonpb.Payload{&flipped, &relabelled, &truncated} {
+		_, err := codec.Decode([]*commonpb.Payload{payload})
+		require.Error(t, err)
+	}
+}
+
+func Test_DataConverterInvalidKeys(t *testing.T) {
+	defer func(keys string) {
+		app.PayloadEncryptionKeys = keys
+		payloadCodecOnce = sync.Once{}
+	}(app.PayloadEncryptionKeys)
+	app.PayloadEncryptionKeys = "2022-07:too-short"
+	payloadCodecOnce = sync.Once{}
+	_, err := DataConverter()
+	require.Error(t, err)
+	_, err = ClientOptions()
+	require.Error(t, err)
+}
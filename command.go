@@ -0,0 +1,372 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"reminders/app/recurrence"
+)
+
+// WhatsApp commands are made of fields separated by a colon followed by
+// whitespace, or by a line break:
+//
+//	New Reminder <Name>: <Text>: <Time>
+//	New Recurring Reminder <Name>: <Text>: <Schedule>: <Time>
+//	Update <Code | Reference ID>: <Time>
+//	Snooze [<Code | Reference ID>:] <Duration>
+//	Done [<Code | Reference ID>]
+//	Dismiss [<Code | Reference ID>]
+//
+// Keywords are case-insensitive. The text is everything between the name and
+// the time (or schedule), so it may contain colons and line breaks; a name or
+// text in double quotes is taken as is, colons included. A colon that isn't
+// followed by whitespace, as in "9:30", doesn't separate fields.
+
+const (
+	CommandCreate          = "New Reminder"
+	CommandCreateRecurring = "New Recurring Reminder"
+	CommandUpdate          = "Update"
+	CommandSnooze          = "Snooze"
+	CommandDismiss         = "Dismiss"
+)
+
+var commandUsages = map[string]string{
+	CommandCreate:          "New Reminder <Name>: <Text>: <1H 30M | tomorrow at 9am | YYYYMMDD HH:MM Area/City>",
+	CommandCreateRecurring: "New Recurring Reminder <Name>: <Text>: <Schedule>: <First time>",
+	CommandUpdate:          "Update <Code | Reference ID>: <Time>",
+	CommandSnooze:          "Snooze [<Code | Reference ID>:] <1H 30M>",
+	CommandDismiss:         "Done [<Code | Reference ID>]",
+}
+
+// Command is a parsed WhatsApp command. Only the fields its Type uses are set.
+type Command struct {
+	Type         string
+	Name         string
+	Text         string
+	Schedule     string // RRULE
+	ReferenceId  string // a short code or reference ID; optional for Snooze and Dismiss
+	ReminderTime time.Time
+	TimeZone     string // the IANA time zone the time named, if any
+	SnoozeFor    time.Duration
+	Action       string // "done" or "dismiss"
+}
+
+// CommandError says which part of a WhatsApp command couldn't be read.
+type CommandError struct {
+	Command  string // empty when the command itself wasn't recognized
+	Field    string // e.g. "name", "time"
+	Position int    // of the field in the message, counting characters from 1
+	Reason   string
+}
+
+func (e *CommandError) Error() string {
+	if e.Command == "" {
+		return fmt.Sprintf("Unrecognized request: %s", e.Reason)
+	}
+	return fmt.Sprintf("Unable to read the %s of %s at character %d: %s", e.Field, e.Command, e.Position, e.Reason)
+}
+
+// Usage returns the format of the command, or of every command when the
+// command wasn't recognized.
+func (e *CommandError) Usage() string {
+	if usage, ok := commandUsages[e.Command]; ok {
+		return usage
+	}
+	return strings.Join([]string{
+		commandUsages[CommandCreate],
+		commandUsages[CommandCreateRecurring],
+		commandUsages[CommandUpdate],
+		commandUsages[CommandSnooze],
+		commandUsages[CommandDismiss],
+	}, "\n")
+}
+
+// commandField is a field's trimmed extent in the message.
+type commandField struct {
+	start int
+	end   int
+}
+
+type commandParser struct {
+	message  string
+	location *time.Location // of times that don't name a zone
+	fields   []commandField
+	quotes   []commandField // quoted spans, quotes included
+	command  string
+}
+
+// ParseCommand reads a WhatsApp command, resolving its times against
+// fromTime in the sender's location unless they name a time zone. Errors are
+// *CommandError.
+func ParseCommand(message string, fromTime time.Time, location *time.Location) (Command, error) {
+	p := &commandParser{message: message, location: location}
+	if err := p.split(); err != nil {
+		return Command{}, err
+	}
+	if len(p.fields) == 0 {
+		return Command{}, &CommandError{Position: 1, Reason: "the message is empty"}
+	}
+	first := p.fields[0]
+	if first.start == first.end {
+		return Command{}, &CommandError{Position: p.position(first.start), Reason: "the message doesn't start with a command"}
+	}
+	if rest, ok := p.matchKeywords(first, "new", "recurring", "reminder"); ok {
+		return p.parseCreate(CommandCreateRecurring, rest, fromTime)
+	}
+	if rest, ok := p.matchKeywords(first, "new", "reminder"); ok {
+		return p.parseCreate(CommandCreate, rest, fromTime)
+	}
+	if rest, ok := p.matchKeywords(first, "update"); ok {
+		return p.parseUpdate(rest, fromTime)
+	}
+	if rest, ok := p.matchKeywords(first, "snooze"); ok {
+		return p.parseSnooze(rest)
+	}
+	for _, action := range []string{"done", "dismiss"} {
+		if rest, ok := p.matchKeywords(first, action); ok {
+			return p.parseDismiss(action, rest)
+		}
+	}
+	word := strings.Fields(p.message[first.start:first.end])[0]
+	return Command{}, &CommandError{Position: p.position(first.start), Reason: fmt.Sprintf("%q isn't a command", word)}
+}
+
+// split finds the message's fields. Blank lines are ignored.
+func (p *commandParser) split() error {
+	fieldStart := 0
+	afterNewline := true
+	atWordStart := true
+	addField := func(end int) {
+		field := p.trim(commandField{fieldStart, end})
+		if field.start < field.end || !afterNewline {
+			p.fields = append(p.fields, field)
+		}
+	}
+	for i := 0; i < len(p.message); {
+		r, size := utf8.DecodeRuneInString(p.message[i:])
+		switch {
+		case atWordStart && (r == '"' || r == '“'):
+			closing := "\""
+			if r == '“' {
+				closing = "”"
+			}
+			end := strings.Index(p.message[i+size:], closing)
+			if end < 0 {
+				return &CommandError{Field: "quote", Position: p.position(i), Reason: "the quote isn't closed"}
+			}
+			end += i + size + len(closing)
+			p.quotes = append(p.quotes, commandField{i, end})
+			i = end
+			atWordStart = false
+			continue
+		case r == '\n':
+			addField(i)
+			fieldStart, afterNewline = i+size, true
+		case r == ':' && (i+size == len(p.message) || p.isSpaceAt(i+size)):
+			addField(i)
+			fieldStart, afterNewline = i+size, false
+		}
+		atWordStart = unicode.IsSpace(r)
+		i += size
+	}
+	addField(len(p.message))
+	return nil
+}
+
+func (p *commandParser) isSpaceAt(offset int) bool {
+	r, _ := utf8.DecodeRuneInString(p.message[offset:])
+	return unicode.IsSpace(r)
+}
+
+func (p *commandParser) trim(field commandField) commandField {
+	for field.start < field.end {
+		r, size := utf8.DecodeRuneInString(p.message[field.start:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		field.start += size
+	}
+	for field.end > field.start {
+		r, size := utf8.DecodeLastRuneInString(p.message[:field.end])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		field.end -= size
+	}
+	return field
+}
+
+// value returns the trimmed text between start and end, without its quotes
+// if it is quoted as a whole.
+func (p *commandParser) value(start int, end int) string {
+	field := p.trim(commandField{start, end})
+	for _, quote := range p.quotes {
+		if quote == field {
+			_, openSize := utf8.DecodeRuneInString(p.message[quote.start:])
+			_, closeSize := utf8.DecodeLastRuneInString(p.message[:quote.end])
+			return p.message[quote.start+openSize : quote.end-closeSize]
+		}
+	}
+	return p.message[field.start:field.end]
+}
+
+// matchKeywords reports whether the field starts with the keywords, and
+// where the rest of the field starts.
+func (p *commandParser) matchKeywords(field commandField, keywords ...string) (int, bool) {
+	position := field.start
+	for _, keyword := range keywords {
+		for position < field.end && p.isSpaceAt(position) {
+			_, size := utf8.DecodeRuneInString(p.message[position:])
+			position += size
+		}
+		end := position
+		for end < field.end && !p.isSpaceAt(end) {
+			_, size := utf8.DecodeRuneInString(p.message[end:])
+			end += size
+		}
+		if !strings.EqualFold(p.message[position:end], keyword) {
+			return 0, false
+		}
+		position = end
+	}
+	return position, true
+}
+
+func (p *commandParser) position(offset int) int {
+	return utf8.RuneCountInString(p.message[:offset]) + 1
+}
+
+func (p *commandParser) errorAt(offset int, field string, reason string) error {
+	return &CommandError{Command: p.command, Field: field, Position: p.position(offset), Reason: reason}
+}
+
+func (p *commandParser) parseCreate(commandType string, nameStart int, fromTime time.Time) (Command, error) {
+	p.command = commandType
+	command := Command{Type: commandType}
+	first := p.fields[0]
+	command.Name = p.value(nameStart, first.end)
+	if command.Name == "" {
+		return command, p.errorAt(first.end, "name", "the reminder has no name")
+	}
+	after := []string{"text", "time"}
+	if commandType == CommandCreateRecurring {
+		after = []string{"text", "schedule", "time"}
+	}
+	if len(p.fields) <= len(after) {
+		missing := after[len(p.fields)-1:]
+		return command, p.errorAt(len(p.message), missing[0], fmt.Sprintf("missing the %s", strings.Join(missing, " and ")))
+	}
+
+	timeField := p.fields[len(p.fields)-1]
+	lastTextField := p.fields[len(p.fields)-2]
+	if commandType == CommandCreateRecurring {
+		scheduleField := p.fields[len(p.fields)-2]
+		lastTextField = p.fields[len(p.fields)-3]
+		schedule := p.value(scheduleField.start, scheduleField.end)
+		if schedule == "" {
+			return command, p.errorAt(scheduleField.start, "schedule", "the schedule is empty")
+		}
+		rrule, err := recurrence.ParseSchedule(schedule)
+		if err != nil {
+			return command, p.errorAt(scheduleField.start, "schedule", err.Error())
+		}
+		command.Schedule = rrule
+	}
+	command.Text = p.value(p.fields[1].start, lastTextField.end)
+	if command.Text == "" {
+		return command, p.errorAt(p.fields[1].start, "text", "the reminder has no text")
+	}
+	var err error
+	command.ReminderTime, command.TimeZone, err = p.parseTime(timeField, fromTime)
+	return command, err
+}
+
+// parseTime also returns the time zone the time named, if any.
+func (p *commandParser) parseTime(field commandField, fromTime time.Time) (time.Time, string, error) {
+	value := p.value(field.start, field.end)
+	if value == "" {
+		return time.Time{}, "", p.errorAt(field.start, "time", "the time is empty")
+	}
+	reminderTime, err := getReminderTimeFromMessage(value, fromTime, p.location)
+	if err != nil {
+		return time.Time{}, "", p.errorAt(field.start, "time", err.Error())
+	}
+	if timeZoneSuffix.MatchString(value) {
+		return reminderTime, reminderTime.Location().String(), nil
+	}
+	return reminderTime, "", nil
+}
+
+// parseReference reads the code or reference ID following a keyword.
+func (p *commandParser) parseReference(start int, end int, required bool) (string, error) {
+	reference := p.value(start, end)
+	if reference == "" && required {
+		return "", p.errorAt(end, "reference", "missing the reminder's code or Reference ID")
+	}
+	if strings.IndexFunc(reference, unicode.IsSpace) >= 0 {
+		return "", p.errorAt(p.trim(commandField{start, end}).start, "reference", fmt.Sprintf("%q has spaces, which codes and Reference IDs don't", reference))
+	}
+	return reference, nil
+}
+
+func (p *commandParser) parseUpdate(referenceStart int, fromTime time.Time) (Command, error) {
+	p.command = CommandUpdate
+	command := Command{Type: CommandUpdate}
+	first := p.fields[0]
+	reference, err := p.parseReference(referenceStart, first.end, true)
+	if err != nil {
+		return command, err
+	}
+	command.ReferenceId = reference
+	if len(p.fields) < 2 {
+		return command, p.errorAt(len(p.message), "time", "missing the time")
+	}
+	if len(p.fields) > 2 {
+		return command, p.errorAt(p.fields[2].start, "time", "unexpected text after the time")
+	}
+	command.ReminderTime, command.TimeZone, err = p.parseTime(p.fields[1], fromTime)
+	return command, err
+}
+
+func (p *commandParser) parseSnooze(restStart int) (Command, error) {
+	p.command = CommandSnooze
+	command := Command{Type: CommandSnooze}
+	first := p.fields[0]
+	durationField := commandField{restStart, first.end}
+	switch len(p.fields) {
+	case 1:
+	case 2:
+		reference, err := p.parseReference(restStart, first.end, true)
+		if err != nil {
+			return command, err
+		}
+		command.ReferenceId = reference
+		durationField = p.fields[1]
+	default:
+		return command, p.errorAt(p.fields[2].start, "duration", "unexpected text after the duration")
+	}
+	value := p.value(durationField.start, durationField.end)
+	if value == "" {
+		return command, p.errorAt(durationField.end, "duration", "missing how long to snooze for")
+	}
+	snoozeFor, err := ParseDuration(value)
+	if err != nil {
+		return command, p.errorAt(p.trim(durationField).start, "duration", err.Error())
+	}
+	command.SnoozeFor = snoozeFor
+	return command, nil
+}
+
+func (p *commandParser) parseDismiss(action string, referenceStart int) (Command, error) {
+	p.command = CommandDismiss
+	command := Command{Type: CommandDismiss, Action: action}
+	if len(p.fields) > 1 {
+		return command, p.errorAt(p.fields[1].start, "reference", "unexpected text after the reference")
+	}
+	reference, err := p.parseReference(referenceStart, p.fields[0].end, false)
+	command.ReferenceId = reference
+	return command, err
+}
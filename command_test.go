@@ -0,0 +1,144 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testFromTime is Wednesday, July 13 2022, 15:00 UTC.
+var testFromTime = time.Date(2022, time.July, 13, 15, 0, 0, 0, time.UTC)
+
+func Test_ParseCommand(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	tests := []struct {
+		message  string
+		expected Command
+	}{
+		{"New Reminder Family: call mom: 3h 5m", Command{
+			Type: CommandCreate, Name: "Family", Text: "call mom", ReminderTime: testFromTime.Add(3*time.Hour + 5*time.Minute),
+		}},
+		{"new reminder Family: call mom: 12h 30m", Command{
+			Type: CommandCreate, Name: "Family", Text: "call mom", ReminderTime: testFromTime.Add(12*time.Hour + 30*time.Minute),
+		}},
+		{"New Reminder Family: call mom: 20220714 9:30 America/New_York", Command{
+			Type: CommandCreate, Name: "Family", Text: "call mom", ReminderTime: time.Date(2022, time.July, 14, 9, 30, 0, 0, newYork), TimeZone: "America/New_York",
+		}},
+		{"New Reminder Family: call mom: tomorrow at 9:30am", Command{
+			Type: CommandCreate, Name: "Family", Text: "call mom", ReminderTime: july(14, 9, 30),
+		}},
+		{"New Reminder Family: call mom: next Friday 14:30 America/New_York", Command{
+			Type: CommandCreate, Name: "Family", Text: "call mom", ReminderTime: time.Date(2022, time.July, 15, 14, 30, 0, 0, newYork), TimeZone: "America/New_York",
+		}},
+		// Colons without whitespace after them don't separate fields
+		{"New Reminder Work: standup at 9:30: tomorrow at 9am", Command{
+			Type: CommandCreate, Name: "Work", Text: "standup at 9:30", ReminderTime: july(14, 9, 0),
+		}},
+		// Nor do colons within the text, which runs up to the time
+		{"New Reminder Shopping: list: milk, eggs: 1h", Command{
+			Type: CommandCreate, Name: "Shopping", Text: "list: milk, eggs", ReminderTime: testFromTime.Add(time.Hour),
+		}},
+		{`New Reminder "Team: Ops": "deploy: v2": 30m`, Command{
+			Type: CommandCreate, Name: "Team: Ops", Text: "deploy: v2", ReminderTime: testFromTime.Add(30 * time.Minute),
+		}},
+		{"New Reminder “Family”: call mom: 1h", Command{
+			Type: CommandCreate, Name: "Family", Text: "call mom", ReminderTime: testFromTime.Add(time.Hour),
+		}},
+		{"New Reminder Groceries\nmilk\neggs\n\n2h", Command{
+			Type: CommandCreate, Name: "Groceries", Text: "milk\neggs", ReminderTime: testFromTime.Add(2 * time.Hour),
+		}},
+		{"New Recurring Reminder Meds: take pills: every weekday: tomorrow at 9am", Command{
+			Type: CommandCreateRecurring, Name: "Meds", Text: "take pills", Schedule: "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR", ReminderTime: july(14, 9, 0),
+		}},
+		{"New Recurring Reminder Work: stand-up: every weekday: 20220714 9:00 America/New_York", Command{
+			Type: CommandCreateRecurring, Name: "Work", Text: "stand-up", Schedule: "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR", ReminderTime: time.Date(2022, time.July, 14, 9, 0, 0, 0, newYork), TimeZone: "America/New_York",
+		}},
+		{"Update 7K2QX9: 20220714 18:00 Europe/London", Command{
+			Type: CommandUpdate, ReferenceId: "7K2QX9", ReminderTime: time.Date(2022, time.July, 14, 17, 0, 0, 0, time.UTC), TimeZone: "Europe/London",
+		}},
+		{"Update XXXXXXX: in 2 days", Command{Type: CommandUpdate, ReferenceId: "XXXXXXX", ReminderTime: testFromTime.AddDate(0, 0, 2)}},
+		{"update 7k2-qx9: 1h", Command{Type: CommandUpdate, ReferenceId: "7k2-qx9", ReminderTime: testFromTime.Add(time.Hour)}},
+		{"Snooze 7K2QX9: 1h 30m", Command{Type: CommandSnooze, ReferenceId: "7K2QX9", SnoozeFor: 90 * time.Minute}},
+		{"snooze 7K2QX9: 15m", Command{Type: CommandSnooze, ReferenceId: "7K2QX9", SnoozeFor: 15 * time.Minute}},
+		{"snooze 10m", Command{Type: CommandSnooze, SnoozeFor: 10 * time.Minute}},
+		{"Done 7K2QX9", Command{Type: CommandDismiss, Action: "done", ReferenceId: "7K2QX9"}},
+		{"dismiss XXXXXXX", Command{Type: CommandDismiss, Action: "dismiss", ReferenceId: "XXXXXXX"}},
+		{"Done", Command{Type: CommandDismiss, Action: "done"}},
+		{"dismiss", Command{Type: CommandDismiss, Action: "dismiss"}},
+	}
+	for _, test := range tests {
+		t.Run(test.message, func(t *testing.T) {
+			command, err := ParseCommand(test.message, testFromTime, time.UTC)
+			require.NoError(t, err)
+			require.True(t, test.expected.ReminderTime.Equal(command.ReminderTime), "expected %s, got %s", test.expected.ReminderTime, command.ReminderTime)
+			command.ReminderTime = test.expected.ReminderTime
+			require.Equal(t, test.expected, command)
+		})
+	}
+}
+
+func Test_ParseCommandTimeZones(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	// Times are read in the sender's location
+	command, err := ParseCommand("New Reminder Family: call mom: tomorrow at 9am", testFromTime, newYork)
+	require.NoError(t, err)
+	require.True(t, time.Date(2022, time.July, 14, 9, 0, 0, 0, newYork).Equal(command.ReminderTime))
+	require.Equal(t, "", command.TimeZone)
+
+	// unless they name a zone, which is reported so it can be remembered
+	command, err = ParseCommand("New Reminder Family: call mom: tomorrow at 9am Asia/Jakarta", testFromTime, newYork)
+	require.NoError(t, err)
+	require.Equal(t, "Asia/Jakarta", command.TimeZone)
+	command, err = ParseCommand("Update 7K2QX9: tomorrow at 6pm utc", testFromTime, newYork)
+	require.NoError(t, err)
+	require.Equal(t, "UTC", command.TimeZone)
+
+	// Absolute times stay in the zone they name
+	command, err = ParseCommand("New Reminder Family: call mom: 20220714 9:30 America/New_York", testFromTime, time.UTC)
+	require.NoError(t, err)
+	require.Equal(t, "America/New_York", command.ReminderTime.Location().String())
+}
+
+func Test_ParseCommandErrors(t *testing.T) {
+	tests := []struct {
+		message  string
+		command  string
+		field    string
+		position int
+	}{
+		{"", "", "", 1},
+		{"Remind me to call mom", "", "", 1},
+		{": : x", "", "", 3},
+		{`New Reminder "Family: call mom: 1h`, "", "quote", 14},
+		{"New Reminder : call mom: 1h", CommandCreate, "name", 13},
+		{"New Reminder Family: call mom", CommandCreate, "time", 30},
+		{"New Reminder Family: call mom: whenever", CommandCreate, "time", 32},
+		{"New Reminder Family: call mom: 20220713 10:59 America/New_York", CommandCreate, "time", 32},
+		{"New Reminder Family: call mom: today at 9am", CommandCreate, "time", 32},
+		{"New Reminder Family: call mom: 20221340 09:30 America/New_York", CommandCreate, "time", 32},
+		{"New Reminder Family: call mom: 20220714 09:30 Nowhere/Atlantis", CommandCreate, "time", 32},
+		{"New Recurring Reminder Meds: take pills: now and then: 1h", CommandCreateRecurring, "schedule", 42},
+		{"New Recurring Reminder Work: stand-up: every blue moon: 1h", CommandCreateRecurring, "schedule", 40},
+		{"Update 7K2QX9", CommandUpdate, "time", 14},
+		{"Update 7K2 QX9: 1h", CommandUpdate, "reference", 8},
+		{"Snooze later", CommandSnooze, "duration", 8},
+		{"Snooze 7K2QX9: 1h: 2h", CommandSnooze, "duration", 20},
+		{"Done with this reminder", CommandDismiss, "reference", 6},
+	}
+	for _, test := range tests {
+		t.Run(test.message, func(t *testing.T) {
+			_, err := ParseCommand(test.message, testFromTime, time.UTC)
+			require.Error(t, err)
+			commandErr, ok := err.(*CommandError)
+			require.True(t, ok, "expected a *CommandError, got %T", err)
+			require.Equal(t, test.command, commandErr.Command)
+			require.Equal(t, test.field, commandErr.Field)
+			require.Equal(t, test.position, commandErr.Position)
+			require.NotEmpty(t, commandErr.Usage())
+		})
+	}
+}
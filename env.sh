@@ -1,3 +1,24 @@
 WHATSAPP_TOKEN=
 FB_VERIFY_TOKEN=test
 WHATSAPP_ACCOUNT_ID=102925089154632
+REMINDER_DB_PATH=
+WHATSAPP_APP_SECRET=
+SMTP_HOST=
+SMTP_PORT=587
+SMTP_USERNAME=
+SMTP_PASSWORD=
+SMTP_FROM=
+SMS_ACCOUNT_SID=
+SMS_AUTH_TOKEN=
+SMS_FROM=
+WHATSAPP_REMINDER_TEMPLATE=
+WHATSAPP_INTERACTIVE_REMINDER_TEMPLATE=
+WHATSAPP_TEMPLATE_LANGUAGE=en_US
+WHATSAPP_TOKEN_FILE=
+WHATSAPP_APP_ID=
+WHATSAPP_MESSAGES_PER_SECOND=20
+REFERENCE_ID_KEYS=
+PAYLOAD_ENCRYPTION_KEYS=
+CODEC_SERVER_TOKEN=
+CODEC_SERVER_CORS_ORIGIN=
+DEFAULT_TIME_ZONE=UTC
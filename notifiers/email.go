@@ -0,0 +1,59 @@
+package notifiers
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+
+	"reminders/app"
+	"reminders/app/utils"
+)
+
+// EmailNotifier sends the reminder through an SMTP server.
+type EmailNotifier struct {
+	Host     string
+	Port     string
+	Username string // optional; the server is used without auth when empty
+	Password string
+	From     string
+}
+
+func getEmailNotifier() (INotifier, error) {
+	if app.SMTPHost == "" || app.SMTPFrom == "" {
+		return nil, NotifierNotConfiguredError(utils.NotificationChannelEmail, "SMTP_HOST and SMTP_FROM")
+	}
+	return EmailNotifier{
+		Host:     app.SMTPHost,
+		Port:     app.SMTPPort,
+		Username: app.SMTPUsername,
+		Password: app.SMTPPassword,
+		From:     app.SMTPFrom,
+	}, nil
+}
+
+func (n EmailNotifier) Notify(ctx context.Context, target string, message string, reminderDetails utils.ReminderDetails) error {
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+	subject := fmt.Sprintf("Reminder: %s", reminderDetails.ReminderName)
+	return smtp.SendMail(net.JoinHostPort(n.Host, n.Port), auth, n.From, []string{target}, makeEmail(n.From, target, subject, message))
+}
+
+// makeEmail builds a plain text message. The subject is encoded, so reminder
+// names can't inject headers.
+func makeEmail(from string, to string, subject string, body string) []byte {
+	var email strings.Builder
+	fmt.Fprintf(&email, "From: %s\r\n", from)
+	fmt.Fprintf(&email, "To: %s\r\n", to)
+	fmt.Fprintf(&email, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	email.WriteString("MIME-Version: 1.0\r\n")
+	email.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	email.WriteString("\r\n")
+	email.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	email.WriteString("\r\n")
+	return []byte(email.String())
+}
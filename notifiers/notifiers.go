@@ -0,0 +1,103 @@
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"reminders/app"
+	"reminders/app/utils"
+
+	"golang.org/x/exp/slices"
+)
+
+// INotifier delivers a fired reminder over one kind of notification channel.
+// The target's format depends on the channel; see utils.NotificationChannel.
+type INotifier interface {
+	Notify(ctx context.Context, target string, message string, reminderDetails utils.ReminderDetails) error
+}
+
+func UnknownChannelError(channelType string) error {
+	return errors.New(fmt.Sprintf("Unrecognized notification channel %s", channelType))
+}
+
+func NotifierNotConfiguredError(channelType string, setting string) error {
+	return errors.New(fmt.Sprintf("Notification channel %s is not configured; set %s", channelType, setting))
+}
+
+func NotifierRequestError(channelType string, resp *http.Response) error {
+	return errors.New(fmt.Sprintf("Error sending %s notification. status=%s", channelType, resp.Status))
+}
+
+// GetNotifier returns the notifier for a channel type, configured from the
+// environment. Outside of PROD and DEV notifications are only logged.
+func GetNotifier(channelType string) (INotifier, error) {
+	if !slices.Contains([]string{"PROD", "DEV"}, app.ENV) {
+		if _, ok := liveNotifiers[channelType]; !ok {
+			return nil, UnknownChannelError(channelType)
+		}
+		return _MockNotifier{channelType}, nil
+	}
+	getNotifier, ok := liveNotifiers[channelType]
+	if !ok {
+		return nil, UnknownChannelError(channelType)
+	}
+	return getNotifier()
+}
+
+var liveNotifiers = map[string]func() (INotifier, error){
+	utils.NotificationChannelWhatsapp: func() (INotifier, error) { return WhatsappNotifier{}, nil },
+	utils.NotificationChannelEmail:    getEmailNotifier,
+	utils.NotificationChannelSMS:      getSMSNotifier,
+	utils.NotificationChannelSlack:    func() (INotifier, error) { return SlackNotifier{}, nil },
+	utils.NotificationChannelDiscord:  func() (INotifier, error) { return DiscordNotifier{}, nil },
+	utils.NotificationChannelWebhook:  func() (INotifier, error) { return WebhookNotifier{}, nil },
+}
+
+type _MockNotifier struct {
+	ChannelType string
+}
+
+func (n _MockNotifier) Notify(ctx context.Context, target string, message string, reminderDetails utils.ReminderDetails) error {
+	log.Println("Mock", n.ChannelType, "notification to", target+":", message)
+	return nil
+}
+
+// Notification targets are given by API callers, so only public addresses
+// are dialled.
+var httpClient = utils.NewOutboundHttpClient(30 * time.Second)
+
+// post sends a request body to a notification service, treating any
+// non-2xx response as a failure.
+func post(channelType string, req *http.Request) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		log.Println("Error sending", channelType, "notification. status:", resp.Status, "body:", string(body))
+		return NotifierRequestError(channelType, resp)
+	}
+	return nil
+}
+
+func postJSON(ctx context.Context, channelType string, url string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return post(channelType, req)
+}
@@ -0,0 +1,116 @@
+package notifiers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"reminders/app/utils"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testReminder = utils.ReminderDetails{
+	ReminderTime: time.Date(2022, time.July, 11, 9, 0, 0, 0, time.UTC),
+	ReminderName: "Flights",
+	ReminderText: "Book return flights from Jakarta",
+	Phone:        "16505551111",
+	ReferenceId:  "cmVtaW5kZXItMTY1MDU1NTExMTEtMQ==",
+}
+
+const testMessage = "Reminder: Flights: Book return flights from Jakarta"
+
+type receivedRequest struct {
+	path   string
+	header http.Header
+	body   string
+}
+
+// startServer records requests and responds with status.
+func startServer(t *testing.T, status int) (*httptest.Server, *[]receivedRequest) {
+	received := []receivedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, receivedRequest{r.URL.Path, r.Header, string(body)})
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	// The test server listens on loopback, which notifications can't reach
+	defaultClient := httpClient
+	httpClient = server.Client()
+	t.Cleanup(func() { httpClient = defaultClient })
+	return server, &received
+}
+
+func Test_ChatNotifiers(t *testing.T) {
+	server, received := startServer(t, http.StatusNoContent)
+	require.NoError(t, SlackNotifier{}.Notify(context.Background(), server.URL+"/slack", testMessage, testReminder))
+	require.NoError(t, DiscordNotifier{}.Notify(context.Background(), server.URL+"/discord", testMessage, testReminder))
+	require.Len(t, *received, 2)
+	require.JSONEq(t, `{"text": "Reminder: Flights: Book return flights from Jakarta"}`, (*received)[0].body)
+	require.JSONEq(t, `{"content": "Reminder: Flights: Book return flights from Jakarta"}`, (*received)[1].body)
+
+	server, _ = startServer(t, http.StatusNotFound)
+	require.EqualError(t, SlackNotifier{}.Notify(context.Background(), server.URL, testMessage, testReminder), "Error sending slack notification. status=404 Not Found")
+}
+
+func Test_WebhookNotifier(t *testing.T) {
+	server, received := startServer(t, http.StatusOK)
+	require.NoError(t, WebhookNotifier{}.Notify(context.Background(), server.URL+"/hooks/reminders", testMessage, testReminder))
+	require.Len(t, *received, 1)
+	require.Equal(t, "/hooks/reminders", (*received)[0].path)
+	require.Equal(t, "application/json", (*received)[0].header.Get("Content-Type"))
+	var notification WebhookNotification
+	require.NoError(t, json.Unmarshal([]byte((*received)[0].body), &notification))
+	require.Equal(t, WebhookNotification{
+		ReferenceId:  testReminder.ReferenceId,
+		ReminderName: "Flights",
+		ReminderText: "Book return flights from Jakarta",
+		ReminderTime: "2022-07-11T09:00:00Z",
+		Phone:        "16505551111",
+		Message:      testMessage,
+	}, notification)
+}
+
+func Test_SMSNotifier(t *testing.T) {
+	server, received := startServer(t, http.StatusCreated)
+	notifier := SMSNotifier{ApiUrl: server.URL + "/", AccountSid: "AC123", AuthToken: "secret", From: "+15550001111"}
+	require.NoError(t, notifier.Notify(context.Background(), "16505552222", testMessage, testReminder))
+	require.Len(t, *received, 1)
+	require.Equal(t, "/2010-04-01/Accounts/AC123/Messages.json", (*received)[0].path)
+	request, _ := http.NewRequest("POST", "/", nil)
+	request.Header = (*received)[0].header
+	username, password, ok := request.BasicAuth()
+	require.True(t, ok)
+	require.Equal(t, "AC123", username)
+	require.Equal(t, "secret", password)
+	form, err := url.ParseQuery((*received)[0].body)
+	require.NoError(t, err)
+	require.Equal(t, "+16505552222", form.Get("To"))
+	require.Equal(t, "+15550001111", form.Get("From"))
+	require.Equal(t, testMessage, form.Get("Body"))
+}
+
+func Test_MakeEmail(t *testing.T) {
+	email := string(makeEmail("reminders@example.com", "kerry@example.com", "Reminder: Flights\r\nBcc: everyone@example.com", "Line one\nLine two"))
+	headers, body, found := strings.Cut(email, "\r\n\r\n")
+	require.True(t, found)
+	require.Equal(t, "Line one\r\nLine two\r\n", body)
+	require.Contains(t, headers, "To: kerry@example.com\r\n")
+	require.NotContains(t, headers, "\r\nBcc:")
+}
+
+func Test_GetNotifier(t *testing.T) {
+	notifier, err := GetNotifier(utils.NotificationChannelEmail)
+	require.NoError(t, err)
+	require.NoError(t, notifier.Notify(context.Background(), "kerry@example.com", testMessage, testReminder))
+
+	_, err = GetNotifier("pager")
+	require.EqualError(t, err, "Unrecognized notification channel pager")
+}
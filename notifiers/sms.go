@@ -0,0 +1,49 @@
+package notifiers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"reminders/app"
+	"reminders/app/utils"
+)
+
+// SMSNotifier sends the reminder as a text message through Twilio's Messages
+// API, or any service compatible with it.
+type SMSNotifier struct {
+	ApiUrl     string
+	AccountSid string
+	AuthToken  string
+	From       string
+}
+
+func getSMSNotifier() (INotifier, error) {
+	if app.SMSAccountSid == "" || app.SMSAuthToken == "" || app.SMSFrom == "" {
+		return nil, NotifierNotConfiguredError(utils.NotificationChannelSMS, "SMS_ACCOUNT_SID, SMS_AUTH_TOKEN and SMS_FROM")
+	}
+	return SMSNotifier{
+		ApiUrl:     app.SMSApiUrl,
+		AccountSid: app.SMSAccountSid,
+		AuthToken:  app.SMSAuthToken,
+		From:       app.SMSFrom,
+	}, nil
+}
+
+func (n SMSNotifier) Notify(ctx context.Context, target string, message string, reminderDetails utils.ReminderDetails) error {
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", strings.TrimSuffix(n.ApiUrl, "/"), url.PathEscape(n.AccountSid))
+	form := url.Values{
+		"To":   {"+" + target},
+		"From": {n.From},
+		"Body": {message},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(n.AccountSid, n.AuthToken)
+	return post(utils.NotificationChannelSMS, req)
+}
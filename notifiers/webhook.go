@@ -0,0 +1,45 @@
+package notifiers
+
+import (
+	"context"
+	"time"
+
+	"reminders/app/utils"
+)
+
+// SlackNotifier posts the reminder to a Slack incoming webhook URL.
+type SlackNotifier struct{}
+
+func (n SlackNotifier) Notify(ctx context.Context, target string, message string, reminderDetails utils.ReminderDetails) error {
+	return postJSON(ctx, utils.NotificationChannelSlack, target, map[string]string{"text": message})
+}
+
+// DiscordNotifier posts the reminder to a Discord webhook URL.
+type DiscordNotifier struct{}
+
+func (n DiscordNotifier) Notify(ctx context.Context, target string, message string, reminderDetails utils.ReminderDetails) error {
+	return postJSON(ctx, utils.NotificationChannelDiscord, target, map[string]string{"content": message})
+}
+
+// WebhookNotifier posts the reminder as JSON to any URL.
+type WebhookNotifier struct{}
+
+type WebhookNotification struct {
+	ReferenceId  string `json:"referenceId"`
+	ReminderName string `json:"reminderName"`
+	ReminderText string `json:"reminderText"`
+	ReminderTime string `json:"reminderTime"` // RFC 3339
+	Phone        string `json:"phone"`
+	Message      string `json:"message"`
+}
+
+func (n WebhookNotifier) Notify(ctx context.Context, target string, message string, reminderDetails utils.ReminderDetails) error {
+	return postJSON(ctx, utils.NotificationChannelWebhook, target, WebhookNotification{
+		ReferenceId:  reminderDetails.ReferenceId,
+		ReminderName: reminderDetails.ReminderName,
+		ReminderText: reminderDetails.ReminderText,
+		ReminderTime: reminderDetails.GetReminderTime().Format(time.RFC3339),
+		Phone:        reminderDetails.Phone,
+		Message:      message,
+	})
+}
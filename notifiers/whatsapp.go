@@ -0,0 +1,55 @@
+package notifiers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"reminders/app"
+	"reminders/app/storage"
+	"reminders/app/utils"
+	"reminders/app/whatsapp"
+)
+
+// WhatsappNotifier sends the reminder to a WhatsApp phone other than the
+// reminder's own, with the reminder template once that phone's session
+// window has closed.
+type WhatsappNotifier struct{}
+
+func (n WhatsappNotifier) Notify(ctx context.Context, target string, message string, reminderDetails utils.ReminderDetails) error {
+	wc := whatsapp.GetWhatsappClient()
+	var err error
+	if template, ok := MakeReminderTemplate(reminderDetails); ok && !IsSessionOpen(ctx, target) {
+		_, err = wc.SendTemplateMessage(ctx, target, template)
+	} else {
+		_, err = wc.SendMessage(ctx, target, message)
+	}
+	return err
+}
+
+// MakeReminderTemplate returns the template reminders are sent with outside
+// of the session window, if one is configured.
+func MakeReminderTemplate(reminderDetails utils.ReminderDetails) (whatsapp.Template, bool) {
+	template := whatsapp.Template{
+		Name:       app.WhatsappReminderTemplate,
+		Language:   app.WhatsappTemplateLanguage,
+		Parameters: []string{reminderDetails.ReminderName, reminderDetails.ReminderText},
+	}
+	return template, template.Name != ""
+}
+
+// IsSessionOpen reports whether free-form messages can currently be sent to
+// phone. Without a repository to track inbound messages, the session is
+// assumed closed, since templates are delivered either way.
+func IsSessionOpen(ctx context.Context, phone string) bool {
+	repository, err := storage.GetRepository()
+	if err != nil {
+		return false
+	}
+	lastInbound, err := repository.GetLastInboundMessage(ctx, phone)
+	if err != nil {
+		log.Println("Unable to look up WhatsApp session for", phone, err)
+		return false
+	}
+	return whatsapp.IsSessionOpen(lastInbound, time.Now())
+}
@@ -5,57 +5,11 @@ import (
 	"fmt"
 	"log"
 	"regexp"
-	"strconv"
+	"time"
 )
 
-const CreateReminderFromMessagePattern = `(?i)new reminder (?P<name>.*): (?P<text>.*): (?P<time>.*)`
-const UpdateReminderFromMessagePattern = `(?i)update (?P<referenceId>.*): (?P<time>.*)`
-const ReminderHoursMinutesMessagePattern = `(?i)((?P<hours>[0-9])+H)?\s?((?P<minutes>[0-9])+M)?`
-const ReminderTimeMessagePattern = `(?i)(?P<year>[0-9]{4})(?P<month>[0-9]{2})(?P<day>[0-9]{2}) (?P<hour>[0-9]{1,2}):(?P<minute>[0-9]{2}) (?P<tz>[a-z]*\/[a-z]*)`
-
-func ParseCreateReminderMessage(message string) (string, string, int, error) {
-	// Messages requesting the creation of a reminder are formatted as follows:
-	// "New Reminder <Reminder Name>: <Reminder Text>: <#H #M"
-	log.Printf("parseCreateReminderMessage %s", message)
-	var name, text string
-	var nMinutes int
-
-	match, err := regexp.Compile(CreateReminderFromMessagePattern)
-	if err != nil {
-		return name, text, nMinutes, err
-	}
-	result, err := getNamedCaptureGroups(match, message)
-	if err != nil {
-		return name, text, nMinutes, err
-	}
-	name = result["name"]
-	text = result["text"]
-	messageTime := result["time"]
-	nMinutes, err = getReminderNMinutesFromMessage(messageTime)
-	return name, text, nMinutes, err
-}
-
-func ParseUpdateReminderMessage(message string) (string, int, error) {
-	// Messages requesting the update of a reminder are formatted as follows:
-	// "Update <Reference ID>: <#H #M>"
-	log.Printf("parseUpdateReminderMessage %s", message)
-	var referenceId string
-	var nMinutes int
-
-	match, err := regexp.Compile(UpdateReminderFromMessagePattern)
-	if err != nil {
-		return referenceId, nMinutes, err
-	}
-	result, err := getNamedCaptureGroups(match, message)
-	if err != nil {
-		return referenceId, nMinutes, err
-	}
-	referenceId = result["referenceId"]
-	messageTime := result["time"]
-	nMinutes, err = getReminderNMinutesFromMessage(messageTime)
-	fmt.Printf("messageTime=%s nMinutes=%d", messageTime, nMinutes)
-	return referenceId, nMinutes, err
-}
+const ReminderTimeMessagePattern = `(?i)(?P<year>[0-9]{4})(?P<month>[0-9]{2})(?P<day>[0-9]{2}) (?P<hour>[0-9]{1,2}):(?P<minute>[0-9]{2}) (?P<tz>[a-z_]+(?:\/[a-z_+-]+)+|UTC)`
+const ReminderTimeLayout = "20060102 15:04"
 
 func getNamedCaptureGroups(r *regexp.Regexp, str string) (map[string]string, error) {
 	match := r.FindStringSubmatch(str)
@@ -75,24 +29,62 @@ func ReminderParseError(messageTime string) error {
 	return errors.New(fmt.Sprintf("Unable to calculate requested reminder time from %s", messageTime))
 }
 
-func getReminderNMinutesFromMessage(messageTime string) (int, error) {
-	var nMinutes int
-	hmMatch, _ := regexp.Compile(ReminderHoursMinutesMessagePattern)
+func ReminderInPastError(reminderTime time.Time) error {
+	return errors.New(fmt.Sprintf("Requested reminder time %s is in the past", reminderTime.Format(TIME_FORMAT)))
+}
 
-	if hmMatch != nil {
-		result, err := getNamedCaptureGroups(hmMatch, messageTime)
-		if err != nil {
-			return nMinutes, ReminderParseError(messageTime)
-		}
-		hours, err := strconv.Atoi(result["hours"])
+// getReminderTimeFromMessage resolves an absolute "YYYYMMDD HH:MM Area/City"
+// time, or a natural-language time such as "tomorrow at 9am" or "1H 30M" in
+// location unless it names a time zone.
+func getReminderTimeFromMessage(messageTime string, fromTime time.Time, location *time.Location) (time.Time, error) {
+	timeMatch, err := regexp.Compile(ReminderTimeMessagePattern)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if timeMatch.MatchString(messageTime) {
+		reminderTime, err := getAbsoluteReminderTimeFromMessage(timeMatch, messageTime)
 		if err != nil {
-			return nMinutes, ReminderParseError(messageTime)
+			return reminderTime, err
 		}
-		minutes, err := strconv.Atoi(result["minutes"])
-		if err != nil {
-			return nMinutes, ReminderParseError(messageTime)
+		if reminderTime.Before(fromTime) {
+			return reminderTime, ReminderInPastError(reminderTime)
 		}
-		return hours*60 + minutes, nil
+		return reminderTime, nil
+	}
+	reminderTime, err := ParseTimeExpression(messageTime, fromTime, location)
+	if err != nil {
+		return reminderTime, err
+	}
+	if reminderTime.Before(fromTime) {
+		return reminderTime, ReminderInPastError(reminderTime)
+	}
+	return reminderTime, nil
+}
+
+// GetDefaultLocation returns the time zone of senders who have never named
+// one.
+func GetDefaultLocation() *time.Location {
+	location, err := time.LoadLocation(DefaultTimeZone)
+	if err != nil {
+		log.Println("Unrecognized DEFAULT_TIME_ZONE", DefaultTimeZone, err)
+		return time.UTC
+	}
+	return location
+}
+
+func getAbsoluteReminderTimeFromMessage(timeMatch *regexp.Regexp, messageTime string) (time.Time, error) {
+	result, err := getNamedCaptureGroups(timeMatch, messageTime)
+	if err != nil {
+		return time.Time{}, ReminderParseError(messageTime)
+	}
+	location, err := time.LoadLocation(result["tz"])
+	if err != nil {
+		return time.Time{}, errors.New(fmt.Sprintf("Unrecognized time zone %s", result["tz"]))
+	}
+	value := fmt.Sprintf("%s%s%s %s:%s", result["year"], result["month"], result["day"], result["hour"], result["minute"])
+	reminderTime, err := time.ParseInLocation(ReminderTimeLayout, value, location)
+	if err != nil {
+		return time.Time{}, ReminderParseError(messageTime)
 	}
-	return nMinutes, ReminderParseError(messageTime)
+	return reminderTime, nil
 }
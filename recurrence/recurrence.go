@@ -0,0 +1,440 @@
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supports the subset of RFC 5545 RRULEs that reminders need:
+// FREQ (DAILY, WEEKLY, MONTHLY, YEARLY), INTERVAL, BYDAY (with optional
+// ordinals for MONTHLY, e.g. 1MO or -1FR), BYMONTHDAY, BYMONTH, COUNT and UNTIL.
+
+const (
+	Daily   = "DAILY"
+	Weekly  = "WEEKLY"
+	Monthly = "MONTHLY"
+	Yearly  = "YEARLY"
+)
+
+// Upper bound on the number of periods searched past `after` for the next
+// occurrence, so that rules which can never match (e.g.
+// BYMONTH=2;BYMONTHDAY=30) terminate.
+const maxPeriods = 10000
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+type WeekdayNum struct {
+	Weekday time.Weekday
+	N       int // 0 means every matching weekday in the period
+}
+
+type Rule struct {
+	Freq       string
+	Interval   int
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []time.Month
+	Count      int
+	Until      time.Time
+}
+
+func RecurrenceParseError(rrule string, reason string) error {
+	return errors.New(fmt.Sprintf("Unable to parse recurrence %s: %s", rrule, reason))
+}
+
+// Parse reads an RRULE such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO". A leading
+// "RRULE:" prefix is accepted.
+func Parse(rrule string) (Rule, error) {
+	rule := Rule{Interval: 1}
+	value := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(rrule)), "RRULE:")
+	if value == "" {
+		return rule, RecurrenceParseError(rrule, "empty rule")
+	}
+	for _, part := range strings.Split(value, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return rule, RecurrenceParseError(rrule, fmt.Sprintf("invalid part %s", part))
+		}
+		var err error
+		switch kv[0] {
+		case "FREQ":
+			switch kv[1] {
+			case Daily, Weekly, Monthly, Yearly:
+				rule.Freq = kv[1]
+			default:
+				return rule, RecurrenceParseError(rrule, fmt.Sprintf("unsupported FREQ %s", kv[1]))
+			}
+		case "INTERVAL":
+			rule.Interval, err = strconv.Atoi(kv[1])
+			if err != nil || rule.Interval < 1 {
+				return rule, RecurrenceParseError(rrule, "INTERVAL must be a positive integer")
+			}
+		case "COUNT":
+			rule.Count, err = strconv.Atoi(kv[1])
+			if err != nil || rule.Count < 1 {
+				return rule, RecurrenceParseError(rrule, "COUNT must be a positive integer")
+			}
+		case "UNTIL":
+			rule.Until, err = parseUntil(kv[1])
+			if err != nil {
+				return rule, RecurrenceParseError(rrule, fmt.Sprintf("invalid UNTIL %s", kv[1]))
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(kv[1], ",") {
+				weekdayNum, err := parseWeekdayNum(day)
+				if err != nil {
+					return rule, RecurrenceParseError(rrule, err.Error())
+				}
+				rule.ByDay = append(rule.ByDay, weekdayNum)
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(kv[1], ",") {
+				n, err := strconv.Atoi(day)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return rule, RecurrenceParseError(rrule, fmt.Sprintf("invalid BYMONTHDAY %s", day))
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, month := range strings.Split(kv[1], ",") {
+				n, err := strconv.Atoi(month)
+				if err != nil || n < 1 || n > 12 {
+					return rule, RecurrenceParseError(rrule, fmt.Sprintf("invalid BYMONTH %s", month))
+				}
+				rule.ByMonth = append(rule.ByMonth, time.Month(n))
+			}
+		default:
+			return rule, RecurrenceParseError(rrule, fmt.Sprintf("unsupported part %s", kv[0]))
+		}
+	}
+	if rule.Freq == "" {
+		return rule, RecurrenceParseError(rrule, "missing FREQ")
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return rule, RecurrenceParseError(rrule, "COUNT and UNTIL are mutually exclusive")
+	}
+	for _, day := range rule.ByDay {
+		if day.N != 0 && rule.Freq != Monthly && rule.Freq != Yearly {
+			return rule, RecurrenceParseError(rrule, "BYDAY ordinals are only supported for MONTHLY and YEARLY rules")
+		}
+	}
+	return rule, nil
+}
+
+func parseUntil(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			if layout == "20060102" {
+				// A date-only UNTIL includes the whole day.
+				t = t.Add(24*time.Hour - time.Second)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("unrecognized UNTIL format")
+}
+
+func parseWeekdayNum(value string) (WeekdayNum, error) {
+	if len(value) < 2 {
+		return WeekdayNum{}, errors.New(fmt.Sprintf("invalid BYDAY %s", value))
+	}
+	weekday, ok := weekdays[value[len(value)-2:]]
+	if !ok {
+		return WeekdayNum{}, errors.New(fmt.Sprintf("invalid BYDAY %s", value))
+	}
+	weekdayNum := WeekdayNum{Weekday: weekday}
+	if prefix := value[:len(value)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return WeekdayNum{}, errors.New(fmt.Sprintf("invalid BYDAY %s", value))
+		}
+		weekdayNum.N = n
+	}
+	return weekdayNum, nil
+}
+
+// Next returns the first occurrence of the rule strictly after `after`, for a
+// series starting at dtstart. The time of day of every occurrence is taken
+// from dtstart, in dtstart's location. The second return value is false once
+// the series is exhausted by COUNT or UNTIL.
+func (r Rule) Next(dtstart time.Time, after time.Time) (time.Time, bool) {
+	// Periods before the one containing `after` can't hold the next
+	// occurrence, so are skipped unless COUNT needs them counted
+	afterPeriod := r.periodOf(dtstart, after)
+	first := 0
+	if r.Count == 0 && afterPeriod > 0 {
+		first = afterPeriod - 1
+	}
+	n := 0
+	for period := first; period < afterPeriod+maxPeriods; period++ {
+		for _, occurrence := range r.periodOccurrences(dtstart, period) {
+			if occurrence.Before(dtstart) {
+				continue
+			}
+			if !r.Until.IsZero() && occurrence.After(r.Until) {
+				return time.Time{}, false
+			}
+			n++
+			if r.Count > 0 && n > r.Count {
+				return time.Time{}, false
+			}
+			if occurrence.After(after) {
+				return occurrence, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// periodOf returns the index of the period of the series that t falls in, or
+// a negative one if t is before dtstart's.
+func (r Rule) periodOf(dtstart time.Time, t time.Time) int {
+	t = t.In(dtstart.Location())
+	var units int
+	switch r.Freq {
+	case Daily, Weekly:
+		startDate := time.Date(dtstart.Year(), dtstart.Month(), dtstart.Day(), 0, 0, 0, 0, time.UTC)
+		date := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		units = int(date.Sub(startDate).Hours() / 24)
+		if r.Freq == Weekly {
+			units = floorDiv(units+(int(dtstart.Weekday())+6)%7, 7)
+		}
+	case Monthly:
+		units = (t.Year()-dtstart.Year())*12 + int(t.Month()) - int(dtstart.Month())
+	case Yearly:
+		units = t.Year() - dtstart.Year()
+	}
+	return floorDiv(units, r.Interval)
+}
+
+func floorDiv(a int, b int) int {
+	if a < 0 {
+		return -((-a + b - 1) / b)
+	}
+	return a / b
+}
+
+// periodOccurrences lists the sorted candidate occurrences within the
+// period'th period (day, week, month or year) of the series.
+func (r Rule) periodOccurrences(dtstart time.Time, period int) []time.Time {
+	step := period * r.Interval
+	var days []time.Time
+	switch r.Freq {
+	case Daily:
+		day := atTimeOf(dtstart, dtstart.Year(), dtstart.Month(), dtstart.Day()+step)
+		if r.matchesMonth(day) && r.matchesWeekday(day) && r.matchesMonthDay(day) {
+			days = append(days, day)
+		}
+	case Weekly:
+		// Weeks start on Monday, per the RFC 5545 default WKST.
+		offset := (int(dtstart.Weekday()) + 6) % 7
+		weekStart := dtstart.Day() - offset + step*7
+		for i := 0; i < 7; i++ {
+			day := atTimeOf(dtstart, dtstart.Year(), dtstart.Month(), weekStart+i)
+			if len(r.ByDay) == 0 && day.Weekday() != dtstart.Weekday() {
+				continue
+			}
+			if r.matchesMonth(day) && r.matchesWeekday(day) {
+				days = append(days, day)
+			}
+		}
+	case Monthly:
+		monthStart := atTimeOf(dtstart, dtstart.Year(), dtstart.Month()+time.Month(step), 1)
+		if r.matchesMonth(monthStart) {
+			days = r.monthOccurrences(dtstart, monthStart)
+		}
+	case Yearly:
+		year := dtstart.Year() + step
+		months := r.ByMonth
+		if len(months) == 0 {
+			months = []time.Month{dtstart.Month()}
+		}
+		for _, month := range months {
+			days = append(days, r.monthOccurrences(dtstart, atTimeOf(dtstart, year, month, 1))...)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days
+}
+
+// monthOccurrences lists the days of the month starting at monthStart that
+// match BYMONTHDAY and BYDAY, defaulting to dtstart's day of the month.
+func (r Rule) monthOccurrences(dtstart time.Time, monthStart time.Time) []time.Time {
+	var days []time.Time
+	daysInMonth := atTimeOf(dtstart, monthStart.Year(), monthStart.Month()+1, 0).Day()
+	for d := 1; d <= daysInMonth; d++ {
+		day := atTimeOf(dtstart, monthStart.Year(), monthStart.Month(), d)
+		if len(r.ByDay) == 0 && len(r.ByMonthDay) == 0 && d != dtstart.Day() {
+			continue
+		}
+		if r.matchesMonthDay(day) && r.matchesWeekdayInMonth(day, daysInMonth) {
+			days = append(days, day)
+		}
+	}
+	return days
+}
+
+func (r Rule) matchesMonth(day time.Time) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, month := range r.ByMonth {
+		if day.Month() == month {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchesMonthDay(day time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	daysInMonth := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, n := range r.ByMonthDay {
+		if n == day.Day() || n < 0 && daysInMonth+n+1 == day.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchesWeekday(day time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, weekdayNum := range r.ByDay {
+		if day.Weekday() == weekdayNum.Weekday {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchesWeekdayInMonth(day time.Time, daysInMonth int) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, weekdayNum := range r.ByDay {
+		if day.Weekday() != weekdayNum.Weekday {
+			continue
+		}
+		if weekdayNum.N == 0 ||
+			weekdayNum.N > 0 && (day.Day()-1)/7+1 == weekdayNum.N ||
+			weekdayNum.N < 0 && (daysInMonth-day.Day())/7+1 == -weekdayNum.N {
+			return true
+		}
+	}
+	return false
+}
+
+func atTimeOf(dtstart time.Time, year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, dtstart.Hour(), dtstart.Minute(), dtstart.Second(), 0, dtstart.Location())
+}
+
+var dayNames = map[string]string{
+	"monday":    "MO",
+	"tuesday":   "TU",
+	"wednesday": "WE",
+	"thursday":  "TH",
+	"friday":    "FR",
+	"saturday":  "SA",
+	"sunday":    "SU",
+}
+
+var frequencyNames = map[string]string{
+	"day":   Daily,
+	"week":  Weekly,
+	"month": Monthly,
+	"year":  Yearly,
+}
+
+var everyPattern = regexp.MustCompile(`^every (?:(?P<interval>[0-9]+) )?(?P<unit>day|week|month|year)s?(?: on (?P<days>.+))?$`)
+var everyWeekdayNamePattern = regexp.MustCompile(`^every (?P<days>(?:monday|tuesday|wednesday|thursday|friday|saturday|sunday)(?:(?:,|,? and) (?:monday|tuesday|wednesday|thursday|friday|saturday|sunday))*)$`)
+var dayListSeparatorPattern = regexp.MustCompile(`\s*(?:,|\band\b)\s*`)
+
+// ParseSchedule accepts either an RRULE or a short English schedule such as
+// "daily", "every weekday", "every 2 weeks on Monday" or "first of the month",
+// and returns the equivalent RRULE.
+func ParseSchedule(schedule string) (string, error) {
+	value := strings.ToLower(strings.Join(strings.Fields(schedule), " "))
+	if strings.Contains(value, "freq=") {
+		if _, err := Parse(schedule); err != nil {
+			return "", err
+		}
+		return strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(schedule)), "RRULE:"), nil
+	}
+	switch value {
+	case "daily", "every day":
+		return "FREQ=DAILY", nil
+	case "every weekday":
+		return "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR", nil
+	case "weekly", "every week":
+		return "FREQ=WEEKLY", nil
+	case "monthly", "every month":
+		return "FREQ=MONTHLY", nil
+	case "yearly", "annually", "every year":
+		return "FREQ=YEARLY", nil
+	case "first of the month", "first day of the month", "every first of the month":
+		return "FREQ=MONTHLY;BYMONTHDAY=1", nil
+	case "last of the month", "last day of the month", "every last day of the month":
+		return "FREQ=MONTHLY;BYMONTHDAY=-1", nil
+	}
+	if match := everyWeekdayNamePattern.FindStringSubmatch(value); match != nil {
+		byDay, err := parseDayNames(match[1])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("FREQ=WEEKLY;BYDAY=%s", byDay), nil
+	}
+	if match := everyPattern.FindStringSubmatch(value); match != nil {
+		rrule := fmt.Sprintf("FREQ=%s", frequencyNames[match[2]])
+		if match[1] != "" && match[1] != "1" {
+			rrule = fmt.Sprintf("%s;INTERVAL=%s", rrule, match[1])
+		}
+		if match[3] != "" {
+			byDay, err := parseDayNames(match[3])
+			if err != nil {
+				return "", err
+			}
+			rrule = fmt.Sprintf("%s;BYDAY=%s", rrule, byDay)
+		}
+		if _, err := Parse(rrule); err != nil {
+			return "", err
+		}
+		return rrule, nil
+	}
+	return "", RecurrenceParseError(schedule, "unrecognized schedule")
+}
+
+func parseDayNames(value string) (string, error) {
+	var byDay []string
+	for _, name := range dayListSeparatorPattern.Split(value, -1) {
+		if name == "" {
+			continue
+		}
+		if name == "weekday" || name == "weekdays" {
+			byDay = append(byDay, "MO", "TU", "WE", "TH", "FR")
+			continue
+		}
+		day, ok := dayNames[strings.TrimSuffix(name, "s")]
+		if !ok {
+			return "", RecurrenceParseError(value, fmt.Sprintf("unrecognized day %s", name))
+		}
+		byDay = append(byDay, day)
+	}
+	return strings.Join(byDay, ","), nil
+}
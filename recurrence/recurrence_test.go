@@ -0,0 +1,146 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Mon Jul 11 2022 09:00 UTC
+var testStart = time.Date(2022, time.July, 11, 9, 0, 0, 0, time.UTC)
+
+func day(month time.Month, d int) time.Time {
+	return time.Date(2022, month, d, 9, 0, 0, 0, time.UTC)
+}
+
+func Test_Next(t *testing.T) {
+	tests := []struct {
+		rrule   string
+		dtstart time.Time
+		want    []time.Time
+	}{
+		{"FREQ=DAILY;COUNT=3", testStart, []time.Time{day(7, 11), day(7, 12), day(7, 13)}},
+		{"FREQ=DAILY;INTERVAL=10;COUNT=2", testStart, []time.Time{day(7, 11), day(7, 21)}},
+		{"FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR;COUNT=6", day(7, 14), []time.Time{day(7, 14), day(7, 15), day(7, 18), day(7, 19), day(7, 20), day(7, 21)}},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO;COUNT=3", testStart, []time.Time{day(7, 11), day(7, 25), day(8, 8)}},
+		{"FREQ=WEEKLY;UNTIL=20220725", testStart, []time.Time{day(7, 11), day(7, 18), day(7, 25)}},
+		{"FREQ=MONTHLY;BYMONTHDAY=1;COUNT=3", testStart, []time.Time{day(8, 1), day(9, 1), day(10, 1)}},
+		{"FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=3", testStart, []time.Time{day(7, 31), day(8, 31), day(9, 30)}},
+		{"FREQ=MONTHLY;BYDAY=1MO;COUNT=2", testStart, []time.Time{day(8, 1), day(9, 5)}},
+		{"FREQ=MONTHLY;BYDAY=-1FR;COUNT=2", testStart, []time.Time{day(7, 29), day(8, 26)}},
+		{"FREQ=MONTHLY;COUNT=3", day(1, 31), []time.Time{day(1, 31), day(3, 31), day(5, 31)}},
+		{"FREQ=YEARLY;COUNT=2", testStart, []time.Time{day(7, 11), time.Date(2023, time.July, 11, 9, 0, 0, 0, time.UTC)}},
+	}
+	for _, test := range tests {
+		rule, err := Parse(test.rrule)
+		require.NoError(t, err, test.rrule)
+		var got []time.Time
+		after := test.dtstart.Add(-time.Second)
+		for {
+			next, ok := rule.Next(test.dtstart, after)
+			if !ok {
+				break
+			}
+			got = append(got, next)
+			after = next
+		}
+		require.Equal(t, test.want, got, test.rrule)
+	}
+}
+
+func Test_NextKeepsWallClockAcrossDST(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	dtstart := time.Date(2022, time.November, 4, 9, 0, 0, 0, location)
+	rule, err := Parse("FREQ=DAILY")
+	require.NoError(t, err)
+	next, ok := rule.Next(dtstart, time.Date(2022, time.November, 6, 0, 0, 0, 0, location))
+	require.True(t, ok)
+	require.Equal(t, 9, next.Hour())
+	require.Equal(t, 6, next.Day())
+}
+
+func Test_NextFarFromStart(t *testing.T) {
+	tests := []struct {
+		rrule string
+		after time.Time
+		want  time.Time
+	}{
+		// Well past the 10000 daily periods that used to be searched
+		{"FREQ=DAILY", time.Date(2072, time.July, 11, 10, 0, 0, 0, time.UTC), time.Date(2072, time.July, 12, 9, 0, 0, 0, time.UTC)},
+		{"FREQ=DAILY;INTERVAL=3", time.Date(2072, time.July, 11, 10, 0, 0, 0, time.UTC), time.Date(2072, time.July, 12, 9, 0, 0, 0, time.UTC)},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR", time.Date(2122, time.July, 11, 0, 0, 0, 0, time.UTC), time.Date(2122, time.July, 13, 9, 0, 0, 0, time.UTC)},
+		{"FREQ=MONTHLY;BYMONTHDAY=-1", time.Date(2922, time.February, 1, 0, 0, 0, 0, time.UTC), time.Date(2922, time.February, 28, 9, 0, 0, 0, time.UTC)},
+		{"FREQ=YEARLY", time.Date(3022, time.July, 11, 9, 0, 0, 0, time.UTC), time.Date(3023, time.July, 11, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		rule, err := Parse(test.rrule)
+		require.NoError(t, err, test.rrule)
+		next, ok := rule.Next(testStart, test.after)
+		require.True(t, ok, test.rrule)
+		require.Equal(t, test.want, next, test.rrule)
+	}
+}
+
+// Skipping ahead to `after` finds the same occurrences as counting from the
+// start of the series.
+func Test_NextSkipsAheadConsistently(t *testing.T) {
+	for _, rrule := range []string{
+		"FREQ=DAILY;INTERVAL=3",
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR",
+		"FREQ=WEEKLY;BYDAY=SU",
+		"FREQ=MONTHLY;BYDAY=-1FR",
+		"FREQ=MONTHLY;INTERVAL=5;BYMONTHDAY=31",
+		"FREQ=YEARLY;BYMONTH=2,8",
+	} {
+		rule, err := Parse(rrule)
+		require.NoError(t, err, rrule)
+		counted := rule
+		counted.Count = 1 << 30
+		for after := testStart.Add(-time.Hour); after.Before(testStart.AddDate(3, 0, 0)); after = after.Add(37 * time.Hour) {
+			want, wantOk := counted.Next(testStart, after)
+			got, ok := rule.Next(testStart, after)
+			require.Equal(t, wantOk, ok, "%s after %s", rrule, after)
+			require.Equal(t, want, got, "%s after %s", rrule, after)
+		}
+	}
+}
+
+func Test_ParseInvalid(t *testing.T) {
+	for _, rrule := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=2;UNTIL=20220801",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=DAILY;BYSETPOS=1",
+	} {
+		_, err := Parse(rrule)
+		require.Error(t, err, rrule)
+	}
+}
+
+func Test_ParseSchedule(t *testing.T) {
+	tests := map[string]string{
+		"FREQ=WEEKLY;BYDAY=MO":               "FREQ=WEEKLY;BYDAY=MO",
+		"RRULE:freq=daily;count=5":           "FREQ=DAILY;COUNT=5",
+		"daily":                              "FREQ=DAILY",
+		"Every weekday":                      "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR",
+		"every 2 weeks on Monday":            "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO",
+		"every week on Tuesday and Thursday": "FREQ=WEEKLY;BYDAY=TU,TH",
+		"every Monday, Wednesday and Friday": "FREQ=WEEKLY;BYDAY=MO,WE,FR",
+		"every 3 months":                     "FREQ=MONTHLY;INTERVAL=3",
+		"first of the month":                 "FREQ=MONTHLY;BYMONTHDAY=1",
+	}
+	for schedule, want := range tests {
+		got, err := ParseSchedule(schedule)
+		require.NoError(t, err, schedule)
+		require.Equal(t, want, got, schedule)
+	}
+	_, err := ParseSchedule("every blue moon")
+	require.Error(t, err)
+}
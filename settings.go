@@ -2,6 +2,8 @@ package app
 
 import (
 	"os"
+	"strconv"
+	"time"
 )
 
 var ENV = os.Getenv("ENV")
@@ -11,6 +13,103 @@ var FB_VERIFY_TOKEN = os.Getenv("FB_VERIFY_TOKEN")
 var WhatsappAccountId = os.Getenv("WHATSAPP_ACCOUNT_ID")
 var WhatsappToken = os.Getenv("WHATSAPP_TOKEN")
 
+// File holding the WhatsApp token, re-read whenever the token is refreshed;
+// takes precedence over WHATSAPP_TOKEN.
+var WhatsappTokenFile = os.Getenv("WHATSAPP_TOKEN_FILE")
+
+// With the app secret, used to exchange the token for a long-lived one.
+var WhatsappAppId = os.Getenv("WHATSAPP_APP_ID")
+
+// Approved message templates reminders are sent with once the recipient's
+// 24-hour session window has closed. The interactive template, used for
+// reminders that can be snoozed, has Snooze 15m, Snooze 1h and Done
+// quick-reply buttons; both take the reminder's name and text as {{1}} and
+// {{2}}. Without a template, reminders are always sent as free-form text.
+var WhatsappReminderTemplate = os.Getenv("WHATSAPP_REMINDER_TEMPLATE")
+var WhatsappInteractiveReminderTemplate = os.Getenv("WHATSAPP_INTERACTIVE_REMINDER_TEMPLATE")
+var WhatsappTemplateLanguage = getEnv("WHATSAPP_TEMPLATE_LANGUAGE", "en_US")
+
+// Approved template escalation steps notify other phones with once their
+// session window has closed. It takes the reminder's phone, name and text as
+// {{1}}, {{2}} and {{3}}.
+var WhatsappEscalationTemplate = os.Getenv("WHATSAPP_ESCALATION_TEMPLATE")
+
+// IANA time zone of WhatsApp users who don't name one in times like
+// "tomorrow at 9am".
+var DefaultTimeZone = getEnv("DEFAULT_TIME_ZONE", "UTC")
+
+// Messages per second each WhatsApp business phone number may send.
+var WhatsappMessagesPerSecond = getEnvInt("WHATSAPP_MESSAGES_PER_SECOND", 20)
+
+// Keys reference IDs are signed with, as "id:secret,id:secret". The first
+// signs new references; the rest still verify older ones while rotating.
+var ReferenceIdKeys = os.Getenv("REFERENCE_ID_KEYS")
+
+// Keys Temporal payloads are encrypted with, as "id:secret,id:secret". The
+// first encrypts; the rest still decrypt older history while rotating.
+var PayloadEncryptionKeys = os.Getenv("PAYLOAD_ENCRYPTION_KEYS")
+
+// Bearer token operators' Temporal Web UI sends to the API's codec endpoint,
+// and the UI's origin, which is allowed to call it from the browser.
+var CodecServerToken = os.Getenv("CODEC_SERVER_TOKEN")
+var CodecServerCorsOrigin = os.Getenv("CODEC_SERVER_CORS_ORIGIN")
+
+// Bearer token operators send to manage webhook subscriptions and read
+// /debug/vars.
+var OperatorToken = os.Getenv("OPERATOR_TOKEN")
+
+// Meta app secret inbound webhooks are signed with.
+var WhatsappAppSecret = os.Getenv("WHATSAPP_APP_SECRET")
+
+// Outgoing mail server for email notification channels.
+var SMTPHost = os.Getenv("SMTP_HOST")
+var SMTPPort = getEnv("SMTP_PORT", "587")
+var SMTPUsername = os.Getenv("SMTP_USERNAME")
+var SMTPPassword = os.Getenv("SMTP_PASSWORD")
+var SMTPFrom = os.Getenv("SMTP_FROM")
+
+// Twilio, or an API compatible with its Messages resource, for SMS channels.
+var SMSApiUrl = getEnv("SMS_API_URL", "https://api.twilio.com")
+var SMSAccountSid = os.Getenv("SMS_ACCOUNT_SID")
+var SMSAuthToken = os.Getenv("SMS_AUTH_TOKEN")
+var SMSFrom = os.Getenv("SMS_FROM")
+
+// SQLite database reminders are recorded in; unset keeps them only in Temporal.
+var ReminderDatabasePath = os.Getenv("REMINDER_DB_PATH")
+
+// How long a fired reminder stays open for "snooze"/"done" replies.
+var ReminderAckWindow = getEnvMinutes("REMINDER_ACK_WINDOW_MINUTES", 30)
+
 const ReminderTaskQueueName = "REMINDER_TASK_QUEUE"
 const UpdateReminderSignalChannelName = "update-reminder-signal"
+const ReminderActionSignalChannelName = "reminder-action-signal"
+const DeliveryStatusSignalChannelName = "delivery-status-signal"
+
+// Custom search attributes used to list reminders; they must be registered
+// with the Temporal cluster (see README).
+const ReminderPhoneSearchAttribute = "ReminderPhone"
+const ReminderStatusSearchAttribute = "ReminderStatus"
+const ReminderTimeSearchAttribute = "ReminderTime"
+const ReminderMessageIdSearchAttribute = "ReminderMessageId"
+const ReminderShortCodeSearchAttribute = "ReminderShortCode"
+
 const TIME_FORMAT = "Mon Jan 2 2006 15:04:05 MST"
+
+func getEnv(key string, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvMinutes(key string, defaultMinutes int) time.Duration {
+	return time.Duration(getEnvInt(key, defaultMinutes)) * time.Minute
+}
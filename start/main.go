@@ -5,9 +5,11 @@ import (
 	"log"
 	"time"
 
+	enums "go.temporal.io/api/enums/v1"
 	"go.temporal.io/sdk/client"
 
 	"reminders/app"
+	"reminders/app/codec"
 	"reminders/app/utils"
 	"reminders/app/workflows"
 )
@@ -15,18 +17,25 @@ import (
 // @@@SNIPSTART reminders-start-workflow
 func main() {
 	// Create the client object just once per process
-	c, err := client.NewClient(client.Options{})
+	clientOptions, err := codec.ClientOptions()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	c, err := client.NewClient(clientOptions)
 	if err != nil {
 		log.Fatalln("unable to create Temporal client", err)
 	}
 	defer c.Close()
 	options := client.StartWorkflowOptions{
-		ID:        "reminder-workflow",
-		TaskQueue: app.ReminderTaskQueueName,
+		ID:                    utils.MakeWorkflowId("", ""),
+		TaskQueue:             app.ReminderTaskQueueName,
+		WorkflowIDReusePolicy: enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE,
 	}
+	fromTime := time.Now()
 	reminderDetails := utils.ReminderDetails{
-		FromTime:     time.Now(),
+		FromTime:     fromTime,
 		NMinutes:     time.Second * 60,
+		ReminderTime: fromTime.Add(time.Second * 60),
 		ReminderText: "Book return flights from Jakarta",
 		ReminderName: "Flights",
 	}
@@ -44,7 +53,7 @@ func printResults(reminderDetails utils.ReminderDetails, workflowId, runId strin
 		"\nCreating reminder for %s (%s) at %s. workflowId=%s runId=%s\n",
 		reminderDetails.ReminderName,
 		reminderDetails.ReminderText,
-		utils.GetReminderTime(reminderDetails.FromTime, reminderDetails.NMinutes),
+		reminderDetails.GetReminderTime(),
 		workflowId,
 		runId,
 	)
@@ -0,0 +1,431 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"reminders/app/utils"
+
+	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/exp/slices"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS reminders (
+	workflow_id   TEXT PRIMARY KEY,
+	reference_id  TEXT NOT NULL,
+	phone         TEXT NOT NULL,
+	status        TEXT NOT NULL,
+	reminder_time TEXT NOT NULL,
+	created_at    TEXT NOT NULL,
+	updated_at    TEXT NOT NULL,
+	details       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS reminders_phone_time ON reminders (phone, reminder_time);
+CREATE TABLE IF NOT EXISTS reminder_events (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	workflow_id   TEXT NOT NULL,
+	event         TEXT NOT NULL,
+	status        TEXT NOT NULL,
+	reminder_time TEXT NOT NULL,
+	recorded_at   TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS reminder_events_workflow ON reminder_events (workflow_id, id);
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+	id         TEXT PRIMARY KEY,
+	url        TEXT NOT NULL,
+	secret     TEXT NOT NULL,
+	events     TEXT NOT NULL,
+	created_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	subscription_id TEXT NOT NULL,
+	event_id        TEXT NOT NULL,
+	event           TEXT NOT NULL,
+	workflow_id     TEXT NOT NULL,
+	attempt         INTEGER NOT NULL,
+	status_code     INTEGER NOT NULL,
+	error           TEXT NOT NULL,
+	succeeded       INTEGER NOT NULL,
+	attempted_at    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription ON webhook_deliveries (subscription_id, id);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_event ON webhook_deliveries (event_id);
+CREATE TABLE IF NOT EXISTS whatsapp_sessions (
+	phone           TEXT PRIMARY KEY,
+	last_inbound_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS phone_time_zones (
+	phone     TEXT PRIMARY KEY,
+	time_zone TEXT NOT NULL
+);
+`
+
+// Times are stored as fixed-width UTC strings, so that they sort correctly.
+const sqliteTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+var sqliteSortOrders = map[string]string{
+	"reminderTime":  "reminder_time ASC",
+	"-reminderTime": "reminder_time DESC",
+	"startTime":     "created_at ASC",
+	"-startTime":    "created_at DESC",
+}
+
+var reminderStatuses = []string{
+	utils.ReminderStatusPending,
+	utils.ReminderStatusFired,
+	utils.ReminderStatusAcknowledged,
+	utils.ReminderStatusCancelled,
+	utils.ReminderStatusFailed,
+}
+
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+// OpenSQLiteRepository opens, and if need be creates, the database at path.
+// Both the API and the worker may have it open at once.
+func OpenSQLiteRepository(path string) (*SQLiteRepository, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000", path))
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; sharing one connection avoids SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteRepository{db}, nil
+}
+
+func (r *SQLiteRepository) RecordEvent(ctx context.Context, event string, reminderDetails utils.ReminderDetails) error {
+	status, ok := eventStatuses[event]
+	if !ok {
+		return UnknownEventError(event)
+	}
+	if status == "" {
+		status = reminderDetails.Status
+	}
+	reminderDetails.Status = status
+	// Signals carried over by continue-as-new aren't part of the reminder
+	reminderDetails.PendingUpdates = nil
+	reminderDetails.PendingActions = nil
+	details, err := json.Marshal(reminderDetails)
+	if err != nil {
+		return err
+	}
+	now := formatSQLiteTime(time.Now())
+	reminderTime := formatSQLiteTime(reminderDetails.GetReminderTime())
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO reminders (workflow_id, reference_id, phone, status, reminder_time, created_at, updated_at, details)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (workflow_id) DO UPDATE SET
+			reference_id = excluded.reference_id,
+			phone = excluded.phone,
+			status = excluded.status,
+			reminder_time = excluded.reminder_time,
+			updated_at = excluded.updated_at,
+			details = excluded.details`,
+		reminderDetails.WorkflowId, reminderDetails.ReferenceId, reminderDetails.Phone, status, reminderTime, now, now, string(details),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO reminder_events (workflow_id, event, status, reminder_time, recorded_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		reminderDetails.WorkflowId, event, status, reminderTime, now,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLiteRepository) GetReminder(ctx context.Context, workflowId string) (utils.ReminderDetails, error) {
+	var reminderDetails utils.ReminderDetails
+	var details string
+	err := r.db.QueryRowContext(ctx, "SELECT details FROM reminders WHERE workflow_id = ?", workflowId).Scan(&details)
+	if err == sql.ErrNoRows {
+		return reminderDetails, ErrReminderNotFound
+	}
+	if err != nil {
+		return reminderDetails, err
+	}
+	err = json.Unmarshal([]byte(details), &reminderDetails)
+	return reminderDetails, err
+}
+
+// ListReminders pages through reminders matching the filter. Page tokens are
+// row offsets.
+func (r *SQLiteRepository) ListReminders(ctx context.Context, filter utils.ReminderListFilter) ([]utils.ReminderDetails, []byte, error) {
+	conditions := []string{"1 = 1"}
+	args := []interface{}{}
+	if filter.Phone != "" {
+		conditions = append(conditions, "phone = ?")
+		args = append(args, filter.Phone)
+	}
+	if filter.Status != "" {
+		if !slices.Contains(reminderStatuses, filter.Status) {
+			return nil, nil, ListFilterError(fmt.Sprintf("unrecognized status %s", filter.Status))
+		}
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "reminder_time >= ?")
+		args = append(args, formatSQLiteTime(filter.From))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "reminder_time <= ?")
+		args = append(args, formatSQLiteTime(filter.To))
+	}
+	order := sqliteSortOrders["startTime"]
+	if filter.Sort != "" {
+		var ok bool
+		if order, ok = sqliteSortOrders[filter.Sort]; !ok {
+			return nil, nil, ListFilterError(fmt.Sprintf("unrecognized sort %s", filter.Sort))
+		}
+	}
+	pageSize := filter.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultListPageSize
+	}
+	if pageSize > MaxListPageSize {
+		pageSize = MaxListPageSize
+	}
+	offset := 0
+	if len(filter.NextPageToken) > 0 {
+		var err error
+		if offset, err = strconv.Atoi(string(filter.NextPageToken)); err != nil || offset < 0 {
+			return nil, nil, ListFilterError("invalid page token")
+		}
+	}
+
+	// Fetch one extra row to tell whether there is another page
+	query := fmt.Sprintf(
+		"SELECT details FROM reminders WHERE %s ORDER BY %s, workflow_id LIMIT ? OFFSET ?",
+		strings.Join(conditions, " AND "), order,
+	)
+	rows, err := r.db.QueryContext(ctx, query, append(args, pageSize+1, offset)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	reminders := []utils.ReminderDetails{}
+	for rows.Next() {
+		var details string
+		if err := rows.Scan(&details); err != nil {
+			return nil, nil, err
+		}
+		var reminderDetails utils.ReminderDetails
+		if err := json.Unmarshal([]byte(details), &reminderDetails); err != nil {
+			return nil, nil, err
+		}
+		reminders = append(reminders, reminderDetails)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	var nextPageToken []byte
+	if len(reminders) > pageSize {
+		reminders = reminders[:pageSize]
+		nextPageToken = []byte(strconv.Itoa(offset + pageSize))
+	}
+	return reminders, nextPageToken, nil
+}
+
+func (r *SQLiteRepository) ListEvents(ctx context.Context, workflowId string) ([]ReminderEvent, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT workflow_id, event, status, reminder_time, recorded_at
+		FROM reminder_events WHERE workflow_id = ? ORDER BY id`,
+		workflowId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []ReminderEvent{}
+	for rows.Next() {
+		var event ReminderEvent
+		var reminderTime, recordedAt string
+		if err := rows.Scan(&event.WorkflowId, &event.Event, &event.Status, &reminderTime, &recordedAt); err != nil {
+			return nil, err
+		}
+		event.ReminderTime, _ = time.Parse(sqliteTimeLayout, reminderTime)
+		event.RecordedAt, _ = time.Parse(sqliteTimeLayout, recordedAt)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (r *SQLiteRepository) CreateSubscription(ctx context.Context, subscription WebhookSubscription) error {
+	events, err := json.Marshal(subscription.Events)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `
+		INSERT INTO webhook_subscriptions (id, url, secret, events, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		subscription.Id, subscription.Url, subscription.Secret, string(events), formatSQLiteTime(subscription.CreatedAt),
+	)
+	return err
+}
+
+func (r *SQLiteRepository) GetSubscription(ctx context.Context, id string) (WebhookSubscription, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT id, url, secret, events, created_at FROM webhook_subscriptions WHERE id = ?", id)
+	subscription, err := scanSubscription(row)
+	if err == sql.ErrNoRows {
+		return subscription, ErrSubscriptionNotFound
+	}
+	return subscription, err
+}
+
+func (r *SQLiteRepository) ListSubscriptions(ctx context.Context) ([]WebhookSubscription, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, url, secret, events, created_at FROM webhook_subscriptions ORDER BY created_at, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	subscriptions := []WebhookSubscription{}
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+func (r *SQLiteRepository) DeleteSubscription(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM webhook_subscriptions WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return ErrSubscriptionNotFound
+	}
+	_, err = r.db.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE subscription_id = ?", id)
+	return err
+}
+
+func (r *SQLiteRepository) RecordWebhookDelivery(ctx context.Context, delivery WebhookDelivery) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event, workflow_id, attempt, status_code, error, succeeded, attempted_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		delivery.SubscriptionId, delivery.EventId, delivery.Event, delivery.WorkflowId, delivery.Attempt,
+		delivery.StatusCode, delivery.Error, delivery.Succeeded, formatSQLiteTime(delivery.AttemptedAt),
+	)
+	return err
+}
+
+func (r *SQLiteRepository) ListWebhookDeliveries(ctx context.Context, subscriptionId string, limit int) ([]WebhookDelivery, error) {
+	return r.queryWebhookDeliveries(ctx, "subscription_id = ? ORDER BY id DESC LIMIT ?", subscriptionId, limit)
+}
+
+func (r *SQLiteRepository) ListEventDeliveries(ctx context.Context, eventId string) ([]WebhookDelivery, error) {
+	return r.queryWebhookDeliveries(ctx, "event_id = ? ORDER BY id", eventId)
+}
+
+func (r *SQLiteRepository) queryWebhookDeliveries(ctx context.Context, where string, args ...interface{}) ([]WebhookDelivery, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT subscription_id, event_id, event, workflow_id, attempt, status_code, error, succeeded, attempted_at
+		FROM webhook_deliveries WHERE `+where,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var attemptedAt string
+		err := rows.Scan(
+			&delivery.SubscriptionId, &delivery.EventId, &delivery.Event, &delivery.WorkflowId, &delivery.Attempt,
+			&delivery.StatusCode, &delivery.Error, &delivery.Succeeded, &attemptedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		delivery.AttemptedAt, _ = time.Parse(sqliteTimeLayout, attemptedAt)
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// RecordInboundMessage keeps the latest time, since webhooks can arrive out
+// of order.
+func (r *SQLiteRepository) RecordInboundMessage(ctx context.Context, phone string, receivedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO whatsapp_sessions (phone, last_inbound_at) VALUES (?, ?)
+		ON CONFLICT (phone) DO UPDATE SET last_inbound_at = MAX(last_inbound_at, excluded.last_inbound_at)`,
+		phone, formatSQLiteTime(receivedAt),
+	)
+	return err
+}
+
+func (r *SQLiteRepository) GetLastInboundMessage(ctx context.Context, phone string) (time.Time, error) {
+	var lastInboundAt string
+	err := r.db.QueryRowContext(ctx, "SELECT last_inbound_at FROM whatsapp_sessions WHERE phone = ?", phone).Scan(&lastInboundAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(sqliteTimeLayout, lastInboundAt)
+}
+
+func (r *SQLiteRepository) SetTimeZone(ctx context.Context, phone string, timeZone string) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO phone_time_zones (phone, time_zone) VALUES (?, ?)
+		ON CONFLICT (phone) DO UPDATE SET time_zone = excluded.time_zone`,
+		phone, timeZone,
+	)
+	return err
+}
+
+func (r *SQLiteRepository) GetTimeZone(ctx context.Context, phone string) (string, error) {
+	var timeZone string
+	err := r.db.QueryRowContext(ctx, "SELECT time_zone FROM phone_time_zones WHERE phone = ?", phone).Scan(&timeZone)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return timeZone, err
+}
+
+// scanSubscription reads a webhook_subscriptions row from either *sql.Row
+// or *sql.Rows.
+func scanSubscription(row interface{ Scan(...interface{}) error }) (WebhookSubscription, error) {
+	var subscription WebhookSubscription
+	var events, createdAt string
+	if err := row.Scan(&subscription.Id, &subscription.Url, &subscription.Secret, &events, &createdAt); err != nil {
+		return subscription, err
+	}
+	subscription.CreatedAt, _ = time.Parse(sqliteTimeLayout, createdAt)
+	err := json.Unmarshal([]byte(events), &subscription.Events)
+	return subscription, err
+}
+
+func (r *SQLiteRepository) Close() error {
+	return r.db.Close()
+}
+
+func formatSQLiteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeLayout)
+}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"reminders/app/utils"
+
+	"github.com/stretchr/testify/require"
+)
+
+func openTestRepository(t *testing.T) *SQLiteRepository {
+	repository, err := OpenSQLiteRepository(filepath.Join(t.TempDir(), "reminders.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { repository.Close() })
+	return repository
+}
+
+func Test_SQLiteRepositoryLifecycle(t *testing.T) {
+	ctx := context.Background()
+	repository := openTestRepository(t)
+	reminderTime := time.Date(2022, time.July, 11, 9, 0, 0, 0, time.UTC)
+	reminderDetails := utils.ReminderDetails{
+		ReminderTime: reminderTime,
+		ReminderName: "Flights",
+		ReminderText: "Book return flights from Jakarta",
+		Phone:        "16505551111",
+		WorkflowId:   "reminder-16505551111-1",
+		ReferenceId:  "cmVtaW5kZXItMTY1MDU1NTExMTEtMQ==",
+	}
+
+	_, err := repository.GetReminder(ctx, reminderDetails.WorkflowId)
+	require.ErrorIs(t, err, ErrReminderNotFound)
+
+	require.NoError(t, repository.RecordEvent(ctx, EventCreated, reminderDetails))
+	reminderDetails.ReminderTime = reminderTime.Add(15 * time.Minute)
+	require.NoError(t, repository.RecordEvent(ctx, EventSnoozed, reminderDetails))
+	require.NoError(t, repository.RecordEvent(ctx, EventFired, reminderDetails))
+	require.NoError(t, repository.RecordEvent(ctx, EventAcknowledged, reminderDetails))
+	// Delivery receipts leave the reminder's status as it was
+	reminderDetails.Status = utils.ReminderStatusAcknowledged
+	reminderDetails.DeliveryStatus = utils.DeliveryStatusRead
+	require.NoError(t, repository.RecordEvent(ctx, EventRead, reminderDetails))
+	require.Error(t, repository.RecordEvent(ctx, "exploded", reminderDetails))
+
+	stored, err := repository.GetReminder(ctx, reminderDetails.WorkflowId)
+	require.NoError(t, err)
+	require.Equal(t, utils.ReminderStatusAcknowledged, stored.Status)
+	require.Equal(t, utils.DeliveryStatusRead, stored.DeliveryStatus)
+	require.Equal(t, "Flights", stored.ReminderName)
+	require.True(t, reminderDetails.ReminderTime.Equal(stored.ReminderTime))
+
+	events, err := repository.ListEvents(ctx, reminderDetails.WorkflowId)
+	require.NoError(t, err)
+	require.Len(t, events, 5)
+	require.Equal(t, EventCreated, events[0].Event)
+	require.Equal(t, EventSnoozed, events[1].Event)
+	require.Equal(t, utils.ReminderStatusPending, events[1].Status)
+	require.True(t, reminderDetails.ReminderTime.Equal(events[1].ReminderTime))
+	require.Equal(t, EventFired, events[2].Event)
+	require.Equal(t, EventAcknowledged, events[3].Event)
+	require.Equal(t, utils.ReminderStatusAcknowledged, events[3].Status)
+	require.Equal(t, EventRead, events[4].Event)
+}
+
+func Test_SQLiteRepositoryList(t *testing.T) {
+	ctx := context.Background()
+	repository := openTestRepository(t)
+	start := time.Date(2022, time.July, 11, 9, 0, 0, 0, time.UTC)
+	for i, phone := range []string{"16505551111", "16505552222", "16505551111", "16505551111"} {
+		reminderDetails := utils.ReminderDetails{
+			ReminderTime: start.Add(time.Duration(i) * time.Hour),
+			ReminderName: "Reminder",
+			Phone:        phone,
+			WorkflowId:   utils.MakeWorkflowId(phone, string(rune('a'+i))),
+		}
+		require.NoError(t, repository.RecordEvent(ctx, EventCreated, reminderDetails))
+		if i == 3 {
+			require.NoError(t, repository.RecordEvent(ctx, EventCancelled, reminderDetails))
+		}
+	}
+
+	reminders, nextPageToken, err := repository.ListReminders(ctx, utils.ReminderListFilter{
+		Phone:    "16505551111",
+		Sort:     "-reminderTime",
+		PageSize: 2,
+	})
+	require.NoError(t, err)
+	require.Len(t, reminders, 2)
+	require.True(t, start.Add(3*time.Hour).Equal(reminders[0].ReminderTime))
+	require.True(t, start.Add(2*time.Hour).Equal(reminders[1].ReminderTime))
+	require.NotNil(t, nextPageToken)
+
+	reminders, nextPageToken, err = repository.ListReminders(ctx, utils.ReminderListFilter{
+		Phone:         "16505551111",
+		Sort:          "-reminderTime",
+		PageSize:      2,
+		NextPageToken: nextPageToken,
+	})
+	require.NoError(t, err)
+	require.Len(t, reminders, 1)
+	require.True(t, start.Equal(reminders[0].ReminderTime))
+	require.Nil(t, nextPageToken)
+
+	reminders, _, err = repository.ListReminders(ctx, utils.ReminderListFilter{
+		Status: utils.ReminderStatusPending,
+		From:   start.Add(time.Hour),
+	})
+	require.NoError(t, err)
+	require.Len(t, reminders, 2)
+
+	_, _, err = repository.ListReminders(ctx, utils.ReminderListFilter{Status: "snoozing"})
+	require.Error(t, err)
+	_, _, err = repository.ListReminders(ctx, utils.ReminderListFilter{Sort: "name"})
+	require.Error(t, err)
+}
+
+func Test_SQLiteRepositorySubscriptions(t *testing.T) {
+	ctx := context.Background()
+	repository := openTestRepository(t)
+	createdAt := time.Date(2022, time.July, 11, 9, 0, 0, 0, time.UTC)
+	subscription := WebhookSubscription{
+		Id:        "01G7P6ZJ5V0000000000000000",
+		Url:       "https://tools.example.com/hooks/reminders",
+		Secret:    "secret",
+		Events:    []string{EventFired, EventCancelled},
+		CreatedAt: createdAt,
+	}
+	require.NoError(t, subscription.Validate())
+	require.Error(t, WebhookSubscription{Url: "ftp://example.com"}.Validate())
+	require.Error(t, WebhookSubscription{Url: subscription.Url, Events: []string{"read"}}.Validate())
+	require.True(t, subscription.Matches(EventFired))
+	require.False(t, subscription.Matches(EventCreated))
+	require.True(t, WebhookSubscription{}.Matches(EventCreated))
+
+	require.NoError(t, repository.CreateSubscription(ctx, subscription))
+	stored, err := repository.GetSubscription(ctx, subscription.Id)
+	require.NoError(t, err)
+	require.Equal(t, subscription, stored)
+	subscriptions, err := repository.ListSubscriptions(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []WebhookSubscription{subscription}, subscriptions)
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		require.NoError(t, repository.RecordWebhookDelivery(ctx, WebhookDelivery{
+			SubscriptionId: subscription.Id,
+			EventId:        "run-1-5",
+			Event:          EventFired,
+			WorkflowId:     "reminder-16505551111-1",
+			Attempt:        attempt,
+			StatusCode:     500 - 300*(attempt-1),
+			Succeeded:      attempt == 2,
+			AttemptedAt:    createdAt.Add(time.Duration(attempt) * time.Second),
+		}))
+	}
+	deliveries, err := repository.ListWebhookDeliveries(ctx, subscription.Id, 1)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	require.Equal(t, 2, deliveries[0].Attempt)
+	require.True(t, deliveries[0].Succeeded)
+	deliveries, err = repository.ListEventDeliveries(ctx, "run-1-5")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	require.Equal(t, 500, deliveries[0].StatusCode)
+	require.False(t, deliveries[0].Succeeded)
+
+	require.NoError(t, repository.DeleteSubscription(ctx, subscription.Id))
+	require.ErrorIs(t, repository.DeleteSubscription(ctx, subscription.Id), ErrSubscriptionNotFound)
+	_, err = repository.GetSubscription(ctx, subscription.Id)
+	require.ErrorIs(t, err, ErrSubscriptionNotFound)
+	deliveries, err = repository.ListWebhookDeliveries(ctx, subscription.Id, 10)
+	require.NoError(t, err)
+	require.Empty(t, deliveries)
+}
+
+func Test_SQLiteRepositoryInboundMessages(t *testing.T) {
+	ctx := context.Background()
+	repository := openTestRepository(t)
+	receivedAt := time.Date(2022, time.July, 11, 9, 0, 0, 0, time.UTC)
+
+	lastInbound, err := repository.GetLastInboundMessage(ctx, "16505551111")
+	require.NoError(t, err)
+	require.True(t, lastInbound.IsZero())
+
+	require.NoError(t, repository.RecordInboundMessage(ctx, "16505551111", receivedAt))
+	// A webhook delivered late doesn't move the session back
+	require.NoError(t, repository.RecordInboundMessage(ctx, "16505551111", receivedAt.Add(-time.Hour)))
+	lastInbound, err = repository.GetLastInboundMessage(ctx, "16505551111")
+	require.NoError(t, err)
+	require.True(t, receivedAt.Equal(lastInbound))
+}
+
+func Test_SQLiteRepositoryTimeZones(t *testing.T) {
+	ctx := context.Background()
+	repository := openTestRepository(t)
+
+	timeZone, err := repository.GetTimeZone(ctx, "16505551111")
+	require.NoError(t, err)
+	require.Equal(t, "", timeZone)
+
+	require.NoError(t, repository.SetTimeZone(ctx, "16505551111", "America/New_York"))
+	require.NoError(t, repository.SetTimeZone(ctx, "16505551111", "Europe/London"))
+	require.NoError(t, repository.SetTimeZone(ctx, "16505552222", "Asia/Jakarta"))
+	timeZone, err = repository.GetTimeZone(ctx, "16505551111")
+	require.NoError(t, err)
+	require.Equal(t, "Europe/London", timeZone)
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"reminders/app"
+	"reminders/app/utils"
+
+	"golang.org/x/exp/slices"
+)
+
+// Lifecycle events recorded for each reminder.
+const (
+	EventCreated   = "created"
+	EventUpdated   = "updated"
+	EventFired     = "fired"
+	EventSnoozed   = "snoozed"
+	EventCancelled = "cancelled"
+	EventEscalated = "escalated"
+	// The recipient marked a fired reminder done
+	EventAcknowledged = "acknowledged"
+	// Delivery of a fired reminder's message
+	EventSent           = "sent"
+	EventDelivered      = "delivered"
+	EventRead           = "read"
+	EventDeliveryFailed = "delivery_failed"
+)
+
+// The event recorded for each WhatsApp delivery status.
+var DeliveryEvents = map[string]string{
+	utils.DeliveryStatusSent:      EventSent,
+	utils.DeliveryStatusDelivered: EventDelivered,
+	utils.DeliveryStatusRead:      EventRead,
+	utils.DeliveryStatusFailed:    EventDeliveryFailed,
+}
+
+// The status a reminder is left in by each event; "" leaves it unchanged.
+var eventStatuses = map[string]string{
+	EventCreated:        utils.ReminderStatusPending,
+	EventUpdated:        utils.ReminderStatusPending,
+	EventFired:          utils.ReminderStatusFired,
+	EventSnoozed:        utils.ReminderStatusPending,
+	EventCancelled:      utils.ReminderStatusCancelled,
+	EventAcknowledged:   utils.ReminderStatusAcknowledged,
+	EventEscalated:      "",
+	EventSent:           "",
+	EventDelivered:      "",
+	EventRead:           "",
+	EventDeliveryFailed: "",
+}
+
+const DefaultListPageSize = 20
+const MaxListPageSize = 100
+
+// Events that webhook subscriptions can be notified of.
+var WebhookEvents = []string{EventCreated, EventUpdated, EventSnoozed, EventFired, EventAcknowledged, EventCancelled}
+
+// ReminderEvent is one entry in a reminder's audit trail.
+type ReminderEvent struct {
+	WorkflowId   string
+	Event        string
+	Status       string
+	ReminderTime time.Time
+	RecordedAt   time.Time
+}
+
+// Repository keeps a copy of every reminder outside of Temporal, so that
+// reminders can be listed and audited without querying workflows. Temporal
+// remains the source of truth; the repository is written by the activities.
+type Repository interface {
+	// RecordEvent saves the reminder's current state and appends the event
+	// to its history.
+	RecordEvent(ctx context.Context, event string, reminderDetails utils.ReminderDetails) error
+	// GetReminder returns ErrReminderNotFound for unknown workflow IDs.
+	GetReminder(ctx context.Context, workflowId string) (utils.ReminderDetails, error)
+	ListReminders(ctx context.Context, filter utils.ReminderListFilter) ([]utils.ReminderDetails, []byte, error)
+	ListEvents(ctx context.Context, workflowId string) ([]ReminderEvent, error)
+	CreateSubscription(ctx context.Context, subscription WebhookSubscription) error
+	// GetSubscription returns ErrSubscriptionNotFound for unknown IDs.
+	GetSubscription(ctx context.Context, id string) (WebhookSubscription, error)
+	ListSubscriptions(ctx context.Context) ([]WebhookSubscription, error)
+	DeleteSubscription(ctx context.Context, id string) error
+	RecordWebhookDelivery(ctx context.Context, delivery WebhookDelivery) error
+	// ListWebhookDeliveries returns a subscription's latest deliveries first.
+	ListWebhookDeliveries(ctx context.Context, subscriptionId string, limit int) ([]WebhookDelivery, error)
+	// ListEventDeliveries returns every attempt to deliver an event.
+	ListEventDeliveries(ctx context.Context, eventId string) ([]WebhookDelivery, error)
+	// RecordInboundMessage notes that a WhatsApp message was received from
+	// phone at the given time, which opens its session window.
+	RecordInboundMessage(ctx context.Context, phone string, receivedAt time.Time) error
+	// GetLastInboundMessage returns the zero time if phone has never sent one.
+	GetLastInboundMessage(ctx context.Context, phone string) (time.Time, error)
+	// SetTimeZone remembers the IANA time zone phone last named, which its
+	// later messages' times are read in.
+	SetTimeZone(ctx context.Context, phone string, timeZone string) error
+	// GetTimeZone returns "" if phone has never named one.
+	GetTimeZone(ctx context.Context, phone string) (string, error)
+	Close() error
+}
+
+// WebhookSubscription is an endpoint outside the app that is sent reminder
+// lifecycle events.
+type WebhookSubscription struct {
+	Id        string
+	Url       string
+	Secret    string   // HMAC key deliveries are signed with
+	Events    []string // empty subscribes to every event
+	CreatedAt time.Time
+}
+
+// Matches reports whether the subscription wants to be sent the event.
+func (s WebhookSubscription) Matches(event string) bool {
+	return len(s.Events) == 0 || slices.Contains(s.Events, event)
+}
+
+// WebhookDelivery is one attempt to send an event to a subscription.
+type WebhookDelivery struct {
+	SubscriptionId string
+	EventId        string // the same for every attempt at the event
+	Event          string
+	WorkflowId     string
+	Attempt        int
+	StatusCode     int // 0 when no response was received
+	Error          string
+	Succeeded      bool
+	AttemptedAt    time.Time
+}
+
+var ErrReminderNotFound = errors.New("Reminder not found.")
+
+// ErrRepositoryNotConfigured is returned by GetRepository when
+// REMINDER_DB_PATH is unset, in which case reminders live only in Temporal.
+var ErrRepositoryNotConfigured = errors.New("Reminder repository not configured; set REMINDER_DB_PATH.")
+
+var ErrSubscriptionNotFound = errors.New("Webhook subscription not found.")
+
+func UnknownEventError(event string) error {
+	return errors.New(fmt.Sprintf("Unrecognized reminder event %s", event))
+}
+
+func SubscriptionError(reason string) error {
+	return errors.New(fmt.Sprintf("Invalid webhook subscription: %s", reason))
+}
+
+// Validate checks the subscription's URL and events.
+func (s WebhookSubscription) Validate() error {
+	if err := utils.ResolveOutboundUrl(s.Url); err != nil {
+		return SubscriptionError(err.Error())
+	}
+	for _, event := range s.Events {
+		if !slices.Contains(WebhookEvents, event) {
+			return SubscriptionError(fmt.Sprintf("unrecognized event %s", event))
+		}
+	}
+	return nil
+}
+
+func ListFilterError(reason string) error {
+	return errors.New(fmt.Sprintf("Invalid reminder list request: %s", reason))
+}
+
+var repository Repository
+var repositoryErr error
+var repositoryOnce sync.Once
+
+// GetRepository returns the process-wide repository, opening it on first use.
+func GetRepository() (Repository, error) {
+	repositoryOnce.Do(func() {
+		if app.ReminderDatabasePath == "" {
+			repositoryErr = ErrRepositoryNotConfigured
+			return
+		}
+		repository, repositoryErr = OpenSQLiteRepository(app.ReminderDatabasePath)
+		if repositoryErr != nil {
+			log.Println("Unable to open reminder repository", app.ReminderDatabasePath, repositoryErr)
+		}
+	})
+	return repository, repositoryErr
+}
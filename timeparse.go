@@ -0,0 +1,409 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Natural-language reminder times, such as "tomorrow at 9am", "next Friday
+// 14:30", "in 2 days" or "tonight", are resolved against the time the message
+// was sent, in the sender's time zone. An IANA zone may follow the expression
+// ("tomorrow 9am Europe/London"); otherwise DEFAULT_TIME_ZONE is used.
+//
+// A day without a time means 9am, or the usual time for a part of the day
+// ("tomorrow evening"). A time without a day means its next occurrence. A
+// weekday means its next occurrence, today included while the time is still
+// ahead; "next <weekday>" never means today. "midnight" is the end of the day.
+
+var timeZoneSuffix = regexp.MustCompile(`\s+([A-Za-z_]+(?:/[A-Za-z0-9_+-]+)+|UTC|utc)\s*$`)
+var amPmWithDots = regexp.MustCompile(`\b([ap])\.m\.?`)
+var digitLetterBoundary = regexp.MustCompile(`(\d)([a-z])`)
+var letterDigitBoundary = regexp.MustCompile(`([a-z])(\d)`)
+var clockTimePattern = regexp.MustCompile(`^(\d{1,2})(?:[:.](\d{2}))?$`)
+
+var numberWords = map[string]int{
+	"one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6,
+	"seven": 7, "eight": 8, "nine": 9, "ten": 10, "eleven": 11, "twelve": 12,
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+var durationUnits = map[string]time.Duration{
+	"minute": time.Minute, "minutes": time.Minute, "min": time.Minute, "mins": time.Minute, "m": time.Minute,
+	"hour": time.Hour, "hours": time.Hour, "hr": time.Hour, "hrs": time.Hour, "h": time.Hour,
+}
+
+// Units of whole days, which keep the wall-clock time across DST changes.
+var dayUnits = map[string]int{
+	"day": 1, "days": 1, "d": 1,
+	"week": 7, "weeks": 7, "wk": 7, "wks": 7, "w": 7,
+}
+
+// The time each part of the day stands for, and whether a bare hour in it is
+// in the afternoon ("this evening at 7").
+var partsOfDay = map[string]struct {
+	hour int
+	pm   bool
+}{
+	"morning":   {9, false},
+	"afternoon": {15, true},
+	"evening":   {18, true},
+	"night":     {20, true},
+}
+
+// Words that only join the others, as in "on the morning of Friday at 9".
+var fillerWords = map[string]bool{"at": true, "on": true, "in": true, "the": true, "this": true, "of": true, "and": true, "for": true}
+
+func TimeExpressionError(expression string, reason string) error {
+	return errors.New(fmt.Sprintf("Unable to calculate requested reminder time from %s: %s", expression, reason))
+}
+
+// timeExpression is what a natural-language time says, before it is resolved.
+type timeExpression struct {
+	hasDuration  bool
+	durationDays int
+	duration     time.Duration
+	hasDay       bool
+	dayOffset    int
+	hasWeekday   bool
+	weekday      time.Weekday
+	nextWeekday  bool
+	partOfDay    string
+	hasClock     bool
+	hour         int
+	minute       int
+	hasAmPm      bool
+}
+
+// ParseTimeExpression returns the time a natural-language expression refers
+// to, in its trailing time zone or else defaultLocation.
+func ParseTimeExpression(expression string, fromTime time.Time, defaultLocation *time.Location) (time.Time, error) {
+	location := defaultLocation
+	value := strings.TrimSpace(expression)
+	if match := timeZoneSuffix.FindStringSubmatch(value); match != nil {
+		zone := match[1]
+		if strings.EqualFold(zone, "utc") {
+			zone = "UTC"
+		}
+		loaded, err := time.LoadLocation(zone)
+		if err != nil {
+			return time.Time{}, errors.New(fmt.Sprintf("Unrecognized time zone %s", match[1]))
+		}
+		location = loaded
+		value = value[:len(value)-len(match[0])]
+	}
+	parsed, err := parseTimeExpression(value)
+	if err != nil {
+		return time.Time{}, TimeExpressionError(expression, err.Error())
+	}
+	reminderTime, err := parsed.resolve(fromTime.In(location))
+	if err != nil {
+		return time.Time{}, TimeExpressionError(expression, err.Error())
+	}
+	return reminderTime, nil
+}
+
+// ParseDuration reads a length of time such as "1h 30m", "90 minutes" or
+// "2 days".
+func ParseDuration(expression string) (time.Duration, error) {
+	parsed, err := parseTimeExpression(expression)
+	if err != nil {
+		return 0, TimeExpressionError(expression, err.Error())
+	}
+	if !parsed.hasDuration || parsed.hasDay || parsed.hasClock || parsed.partOfDay != "" {
+		return 0, TimeExpressionError(expression, "not a length of time")
+	}
+	duration := time.Duration(parsed.durationDays)*24*time.Hour + parsed.duration
+	if duration <= 0 {
+		return 0, TimeExpressionError(expression, "the length of time is zero")
+	}
+	return duration, nil
+}
+
+func tokenizeTimeExpression(value string) []string {
+	value = strings.ToLower(value)
+	value = amPmWithDots.ReplaceAllString(value, "${1}m")
+	value = strings.NewReplacer(",", " ", "!", " ", "o'clock", " oclock", "o’clock", " oclock").Replace(value)
+	value = digitLetterBoundary.ReplaceAllString(value, "$1 $2")
+	value = letterDigitBoundary.ReplaceAllString(value, "$1 $2")
+	return strings.Fields(strings.TrimRight(strings.TrimSpace(value), "."))
+}
+
+type timeExpressionParser struct {
+	tokens []string
+	i      int
+}
+
+func (p *timeExpressionParser) peek(offset int) string {
+	if p.i+offset < len(p.tokens) {
+		return p.tokens[p.i+offset]
+	}
+	return ""
+}
+
+func (p *timeExpressionParser) accept(words ...string) bool {
+	for _, word := range words {
+		if p.peek(0) == word {
+			p.i++
+			return true
+		}
+	}
+	return false
+}
+
+func parseNumber(token string) (int, bool) {
+	if n, ok := numberWords[token]; ok {
+		return n, true
+	}
+	n, err := strconv.Atoi(token)
+	return n, err == nil
+}
+
+func parseTimeExpression(value string) (timeExpression, error) {
+	var e timeExpression
+	p := &timeExpressionParser{tokens: tokenizeTimeExpression(value)}
+	if len(p.tokens) == 0 {
+		return e, errors.New("no time given")
+	}
+	for p.i < len(p.tokens) {
+		token := p.peek(0)
+		switch {
+		case fillerWords[token]:
+			p.i++
+		case token == "a" || token == "an" || token == "half" || isDurationStart(p):
+			if err := p.parseDuration(&e); err != nil {
+				return e, err
+			}
+		case token == "today" || token == "tonight" || token == "tonite" || token == "tomorrow" || token == "tmrw" || token == "tomorow" || token == "day" || token == "next" || isWeekday(token):
+			if e.hasDay {
+				return e, errors.New("more than one day given")
+			}
+			if err := p.parseDay(&e); err != nil {
+				return e, err
+			}
+		case isPartOfDay(token):
+			if e.partOfDay != "" {
+				return e, errors.New("more than one part of the day given")
+			}
+			e.partOfDay = strings.TrimSuffix(token, "s")
+			p.i++
+		default:
+			if e.hasClock {
+				return e, errors.New("more than one time given")
+			}
+			if err := p.parseClock(&e); err != nil {
+				return e, err
+			}
+		}
+	}
+	if !e.hasDuration && !e.hasDay && !e.hasClock && e.partOfDay == "" {
+		return e, errors.New("no time given")
+	}
+	return e, nil
+}
+
+func isWeekday(token string) bool {
+	_, ok := weekdayNames[token]
+	return ok
+}
+
+func isPartOfDay(token string) bool {
+	_, ok := partsOfDay[strings.TrimSuffix(token, "s")]
+	return ok
+}
+
+// isDurationStart reports whether the parser is at a number followed by a
+// unit, e.g. "2 days", rather than a time of day.
+func isDurationStart(p *timeExpressionParser) bool {
+	if _, ok := parseNumber(p.peek(0)); !ok {
+		return false
+	}
+	_, isDuration := durationUnits[p.peek(1)]
+	_, isDays := dayUnits[p.peek(1)]
+	return isDuration || isDays
+}
+
+func (p *timeExpressionParser) parseDuration(e *timeExpression) error {
+	var n int
+	var half bool
+	switch {
+	case p.accept("half"):
+		// "half an hour", "half hour"
+		p.accept("a", "an")
+		half = true
+	case p.accept("a", "an"):
+		n = 1
+	default:
+		n, _ = parseNumber(p.peek(0))
+		p.i++
+	}
+	unit := p.peek(0)
+	p.i++
+	if scale, ok := durationUnits[unit]; ok {
+		if half {
+			if scale != time.Hour {
+				return errors.New("half of an unsupported unit")
+			}
+			e.duration += 30 * time.Minute
+		} else {
+			e.duration += time.Duration(n) * scale
+		}
+	} else if days, ok := dayUnits[unit]; ok && !half {
+		e.durationDays += n * days
+	} else {
+		return errors.New(fmt.Sprintf("unrecognized unit %q", unit))
+	}
+	e.hasDuration = true
+	return nil
+}
+
+func (p *timeExpressionParser) parseDay(e *timeExpression) error {
+	e.hasDay = true
+	switch {
+	case p.accept("today"):
+	case p.accept("tonight", "tonite"):
+		if e.partOfDay != "" {
+			return errors.New("more than one part of the day given")
+		}
+		e.partOfDay = "night"
+	case p.accept("tomorrow", "tmrw", "tomorow"):
+		e.dayOffset = 1
+	case p.accept("day"):
+		// "the day after tomorrow"
+		if !p.accept("after") || !p.accept("tomorrow", "tmrw", "tomorow") {
+			return errors.New("unrecognized day")
+		}
+		e.dayOffset = 2
+	case p.accept("next"):
+		if p.accept("week") {
+			e.dayOffset = 7
+			return nil
+		}
+		weekday, ok := weekdayNames[p.peek(0)]
+		if !ok {
+			return errors.New(fmt.Sprintf("unrecognized day %q after next", p.peek(0)))
+		}
+		p.i++
+		e.hasWeekday, e.weekday, e.nextWeekday = true, weekday, true
+	default:
+		e.hasWeekday, e.weekday = true, weekdayNames[p.peek(0)]
+		p.i++
+	}
+	return nil
+}
+
+func (p *timeExpressionParser) parseClock(e *timeExpression) error {
+	token := p.peek(0)
+	switch token {
+	case "noon", "midday":
+		p.i++
+		e.hasClock, e.hour, e.minute, e.hasAmPm = true, 12, 0, true
+		return nil
+	case "midnight":
+		p.i++
+		e.hasClock, e.hour, e.minute, e.hasAmPm = true, 24, 0, true
+		return nil
+	}
+	var hour, minute int
+	if n, ok := numberWords[token]; ok {
+		hour = n
+	} else if match := clockTimePattern.FindStringSubmatch(token); match != nil {
+		hour, _ = strconv.Atoi(match[1])
+		if match[2] != "" {
+			minute, _ = strconv.Atoi(match[2])
+		}
+	} else {
+		return errors.New(fmt.Sprintf("unrecognized word %q", token))
+	}
+	p.i++
+	switch {
+	case p.accept("am"):
+		if hour < 1 || hour > 12 {
+			return errors.New(fmt.Sprintf("%d am is not a time", hour))
+		}
+		hour %= 12
+		e.hasAmPm = true
+	case p.accept("pm"):
+		if hour < 1 || hour > 12 {
+			return errors.New(fmt.Sprintf("%d pm is not a time", hour))
+		}
+		hour = hour%12 + 12
+		e.hasAmPm = true
+	case hour == 12 && minute == 0 && p.accept("noon"):
+		e.hasAmPm = true
+	case hour == 12 && minute == 0 && p.accept("midnight"):
+		hour = 24
+		e.hasAmPm = true
+	default:
+		p.accept("oclock")
+		if hour > 23 {
+			return errors.New(fmt.Sprintf("%d is not an hour", hour))
+		}
+	}
+	if minute > 59 {
+		return errors.New(fmt.Sprintf("%d is not a minute", minute))
+	}
+	e.hasClock, e.hour, e.minute = true, hour, minute
+	return nil
+}
+
+// resolve returns the time the expression refers to, given the current time
+// in the sender's time zone.
+func (e timeExpression) resolve(now time.Time) (time.Time, error) {
+	if e.hasDuration {
+		if e.hasDay {
+			return time.Time{}, errors.New("both a day and a duration given")
+		}
+		if !e.hasClock && e.partOfDay == "" {
+			return now.AddDate(0, 0, e.durationDays).Add(e.duration), nil
+		}
+		if e.duration != 0 {
+			return time.Time{}, errors.New("both a time and a duration in hours or minutes given")
+		}
+	}
+
+	days := e.dayOffset + e.durationDays
+	weekdayDelta := 0
+	if e.hasWeekday {
+		weekdayDelta = (int(e.weekday) - int(now.Weekday()) + 7) % 7
+		if e.nextWeekday && weekdayDelta == 0 {
+			weekdayDelta = 7
+		}
+		days += weekdayDelta
+	}
+
+	hour, minute := 9, 0
+	if e.partOfDay != "" {
+		hour = partsOfDay[e.partOfDay].hour
+	}
+	if e.hasClock {
+		hour, minute = e.hour, e.minute
+		if !e.hasAmPm && hour < 12 && e.partOfDay != "" && partsOfDay[e.partOfDay].pm {
+			hour += 12
+		}
+	}
+
+	day := now.AddDate(0, 0, days)
+	reminderTime := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, now.Location())
+	if !reminderTime.After(now) {
+		if !e.hasDay && !e.hasDuration {
+			// A time on its own is its next occurrence
+			reminderTime = time.Date(day.Year(), day.Month(), day.Day()+1, hour, minute, 0, 0, now.Location())
+		} else if e.hasWeekday && weekdayDelta == 0 {
+			reminderTime = time.Date(day.Year(), day.Month(), day.Day()+7, hour, minute, 0, 0, now.Location())
+		}
+	}
+	return reminderTime, nil
+}
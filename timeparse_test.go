@@ -0,0 +1,176 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// july returns a time in July 2022, UTC.
+func july(day int, hour int, minute int) time.Time {
+	return time.Date(2022, time.July, day, hour, minute, 0, 0, time.UTC)
+}
+
+func Test_ParseTimeExpression(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   time.Time
+	}{
+		// Relative days
+		{"tomorrow", july(14, 9, 0)},
+		{"tomorrow at 9am", july(14, 9, 0)},
+		{"Tomorrow 9:30pm", july(14, 21, 30)},
+		{"tmrw 7.15am", july(14, 7, 15)},
+		{"9am tomorrow", july(14, 9, 0)},
+		{"tomorrow at 18:45", july(14, 18, 45)},
+		{"the day after tomorrow", july(15, 9, 0)},
+		{"day after tomorrow at 8pm", july(15, 20, 0)},
+		{"today at 5pm", july(13, 17, 0)},
+		{"next week", july(20, 9, 0)},
+		// Parts of the day
+		{"tonight", july(13, 20, 0)},
+		{"tonight at 8", july(13, 20, 0)},
+		{"tonight at 10:30", july(13, 22, 30)},
+		{"this evening", july(13, 18, 0)},
+		{"this evening at 7", july(13, 19, 0)},
+		{"tomorrow morning", july(14, 9, 0)},
+		{"tomorrow afternoon at 2", july(14, 14, 0)},
+		{"tomorrow evening", july(14, 18, 0)},
+		{"in the morning", july(14, 9, 0)},
+		// Times on their own are their next occurrence
+		{"at 5pm", july(13, 17, 0)},
+		{"5 p.m.", july(13, 17, 0)},
+		{"5PM", july(13, 17, 0)},
+		{"at 16:30", july(13, 16, 30)},
+		{"at 4", july(14, 4, 0)},
+		{"at five pm", july(13, 17, 0)},
+		{"at 7 o'clock", july(14, 7, 0)},
+		{"at noon", july(14, 12, 0)},
+		{"midday", july(14, 12, 0)},
+		{"12pm", july(14, 12, 0)},
+		{"12am", july(14, 0, 0)},
+		{"midnight", july(14, 0, 0)},
+		{"12 midnight", july(14, 0, 0)},
+		{"tomorrow at midnight", july(15, 0, 0)},
+		{"12 noon tomorrow", july(14, 12, 0)},
+		// Weekdays
+		{"friday", july(15, 9, 0)},
+		{"Friday at 2pm", july(15, 14, 0)},
+		{"next Friday 14:30", july(15, 14, 30)},
+		{"on Fri at 10", july(15, 10, 0)},
+		{"this friday evening", july(15, 18, 0)},
+		{"monday morning", july(18, 9, 0)},
+		{"tues 8am", july(19, 8, 0)},
+		{"wednesday at 6pm", july(13, 18, 0)},
+		{"wednesday at 9am", july(20, 9, 0)},
+		{"next wednesday", july(20, 9, 0)},
+		{"sunday", july(17, 9, 0)},
+		// Durations
+		{"in 20 minutes", july(13, 15, 20)},
+		{"in 5 mins", july(13, 15, 5)},
+		{"in an hour", july(13, 16, 0)},
+		{"in half an hour", july(13, 15, 30)},
+		{"in 1 hour and 15 minutes", july(13, 16, 15)},
+		{"2 hours 30 mins", july(13, 17, 30)},
+		{"1h30m", july(13, 16, 30)},
+		{"3h 5m", july(13, 18, 5)},
+		{"in 2 days", july(15, 15, 0)},
+		{"in two days", july(15, 15, 0)},
+		{"in a day", july(14, 15, 0)},
+		{"in 2 days at 9am", july(15, 9, 0)},
+		{"in a week", july(20, 15, 0)},
+		{"in 1 week and 2 days", july(22, 15, 0)},
+		{"in 3 weeks", time.Date(2022, time.August, 3, 15, 0, 0, 0, time.UTC)},
+		// Punctuation and spacing
+		{"  Tomorrow,  at 9AM. ", july(14, 9, 0)},
+		{"tomorrow!", july(14, 9, 0)},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			reminderTime, err := ParseTimeExpression(test.expression, testFromTime, time.UTC)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, reminderTime)
+		})
+	}
+}
+
+func Test_ParseTimeExpressionTimeZones(t *testing.T) {
+	newYork, _ := time.LoadLocation("America/New_York")
+	london, _ := time.LoadLocation("Europe/London")
+	tests := []struct {
+		expression string
+		location   *time.Location
+		expected   time.Time
+	}{
+		// 15:00 UTC is 11:00 in New York
+		{"tonight", newYork, time.Date(2022, time.July, 13, 20, 0, 0, 0, newYork)},
+		{"at 10am", newYork, time.Date(2022, time.July, 14, 10, 0, 0, 0, newYork)},
+		{"at noon", newYork, time.Date(2022, time.July, 13, 12, 0, 0, 0, newYork)},
+		{"tomorrow 9am", newYork, time.Date(2022, time.July, 14, 9, 0, 0, 0, newYork)},
+		// A trailing zone overrides the default
+		{"tomorrow at 9am Europe/London", newYork, time.Date(2022, time.July, 14, 9, 0, 0, 0, london)},
+		{"tomorrow 9am America/New_York", time.UTC, time.Date(2022, time.July, 14, 9, 0, 0, 0, newYork)},
+		{"tonight UTC", newYork, july(13, 20, 0)},
+		{"tonight utc", newYork, july(13, 20, 0)},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			reminderTime, err := ParseTimeExpression(test.expression, testFromTime, test.location)
+			require.NoError(t, err)
+			require.True(t, test.expected.Equal(reminderTime), "expected %s, got %s", test.expected, reminderTime)
+			require.Equal(t, test.expected.Location().String(), reminderTime.Location().String())
+		})
+	}
+}
+
+func Test_ParseTimeExpressionAcrossDST(t *testing.T) {
+	newYork, _ := time.LoadLocation("America/New_York")
+	// Clocks go back an hour overnight
+	fromTime := time.Date(2022, time.November, 5, 12, 0, 0, 0, newYork)
+	tests := []struct {
+		expression string
+		expected   time.Time
+	}{
+		{"in 1 day", time.Date(2022, time.November, 6, 12, 0, 0, 0, newYork)},
+		{"in 24 hours", time.Date(2022, time.November, 6, 11, 0, 0, 0, newYork)},
+		{"tomorrow at 9am", time.Date(2022, time.November, 6, 9, 0, 0, 0, newYork)},
+		{"next saturday at noon", time.Date(2022, time.November, 12, 12, 0, 0, 0, newYork)},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			reminderTime, err := ParseTimeExpression(test.expression, fromTime, newYork)
+			require.NoError(t, err)
+			require.True(t, test.expected.Equal(reminderTime), "expected %s, got %s", test.expected, reminderTime)
+		})
+	}
+}
+
+func Test_ParseTimeExpressionInvalid(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"whenever",
+		"soon",
+		"tomorrow friday",
+		"tonight tomorrow",
+		"morning evening",
+		"in 2 parsecs",
+		"in half a day",
+		"next month",
+		"next",
+		"day before yesterday",
+		"13pm",
+		"0am",
+		"at 25",
+		"at 9:75",
+		"9am 10am",
+		"tomorrow in 2 hours",
+		"in 2 hours at 9am",
+		"tomorrow 9am Mars/Olympus_Mons",
+	} {
+		t.Run(expression, func(t *testing.T) {
+			_, err := ParseTimeExpression(expression, testFromTime, time.UTC)
+			require.Error(t, err)
+		})
+	}
+}
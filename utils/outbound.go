@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// URLs reminders and webhook subscriptions are delivered to are given by API
+// callers, so requests to them must not reach the service's own network.
+
+func OutboundUrlError(rawUrl string, reason string) error {
+	return errors.New(fmt.Sprintf("URL %s is not allowed: %s", rawUrl, reason))
+}
+
+// IsPublicAddress reports whether ip is not private, loopback, link-local,
+// unspecified or multicast.
+func IsPublicAddress(ip net.IP) bool {
+	return !(ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// ValidateOutboundUrl checks that rawUrl is an https URL that doesn't name a
+// non-public address. It doesn't look the host up; see ResolveOutboundUrl.
+func ValidateOutboundUrl(rawUrl string) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Hostname() == "" {
+		return OutboundUrlError(rawUrl, "it is invalid")
+	}
+	if u.Scheme != "https" {
+		return OutboundUrlError(rawUrl, "it must be https")
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return OutboundUrlError(rawUrl, "it is local")
+	}
+	if ip := net.ParseIP(host); ip != nil && !IsPublicAddress(ip) {
+		return OutboundUrlError(rawUrl, "its address isn't public")
+	}
+	return nil
+}
+
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// ResolveOutboundUrl checks that rawUrl's host doesn't resolve to non-public
+// addresses. Hosts that can't be resolved pass, since they can't be reached
+// either. It looks the host up, so workflows mustn't call it.
+func ResolveOutboundUrl(rawUrl string) error {
+	if err := ValidateOutboundUrl(rawUrl); err != nil {
+		return err
+	}
+	u, _ := url.Parse(rawUrl)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addresses, err := lookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return nil
+	}
+	for _, address := range addresses {
+		if !IsPublicAddress(address.IP) {
+			return OutboundUrlError(rawUrl, "it resolves to a non-public address")
+		}
+	}
+	return nil
+}
+
+// NewOutboundHttpClient returns a client that refuses to connect to
+// non-public addresses, whatever a host resolves to when it is dialled.
+func NewOutboundHttpClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublicAddress(ip) {
+				return OutboundUrlError(address, "its address isn't public")
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
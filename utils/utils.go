@@ -1,55 +1,371 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"log"
+	"net/mail"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
+	"reminders/app"
 	"reminders/app/codec"
+	"reminders/app/recurrence"
+	"reminders/app/whatsapp"
 
+	"github.com/oklog/ulid/v2"
 	"go.temporal.io/sdk/workflow"
+	"golang.org/x/exp/slices"
 )
 
 type ReminderDetails struct {
-	FromTime     time.Time
-	NMinutes     time.Duration // editable
-	ReminderTime time.Time     // automatically updates
-	ReminderText string        // editable
-	ReminderName string        // editable
+	FromTime      time.Time
+	NMinutes      time.Duration // editable
+	ReminderTime  time.Time     // automatically updates
+	ReminderText  string        // editable
+	ReminderName  string        // editable
+	Phone         string
+	WorkflowId    string
+	RunId         string
+	ReferenceId   string
+	Status        string
+	SnoozeHistory []SnoozeRecord
+	AckWindow     time.Duration // how long a fired reminder waits for a snooze or dismissal
+	Escalation    []EscalationStep
+	Channels      []NotificationChannel
+	// WhatsApp delivery of the latest reminder message
+	MessageId      string
+	MessageIds     []string `json:",omitempty"` // every message sent for the current occurrence, MessageId last
+	DeliveryStatus string   // sent, delivered, read or failed
+	DeliveredAt    time.Time
+	ReadAt         time.Time
+	DeliveryError  string
+	// Recurring reminders only
+	Recurrence      string    // RRULE, e.g. "FREQ=WEEKLY;BYDAY=MO"
+	RecurrenceStart time.Time // DTSTART of the series
+	TimeZone        string    // IANA zone occurrences are computed in
+	OccurrenceTime  time.Time // scheduled time of the current occurrence, before snoozes
+	// Signals received but not yet handled when the workflow continued as new
+	PendingUpdates []UpdateReminderSignal `json:",omitempty"`
+	PendingActions []ReminderActionSignal `json:",omitempty"`
+}
+
+// EscalationStep is carried out if a fired reminder still hasn't been
+// acknowledged, by a reply or a read receipt, AfterMinutes after the previous
+// step (or after firing). Steps with a Phone notify that phone, steps with a
+// Channel deliver the reminder to it, and others re-send the reminder.
+type EscalationStep struct {
+	AfterMinutes int
 	Phone        string
-	WorkflowId   string
-	RunId        string
-	ReferenceId  string
+	Channel      *NotificationChannel `json:",omitempty"`
+}
+
+// At most this many escalation steps may be configured per reminder.
+const MaxEscalationSteps = 5
+
+// NotificationChannel is a destination a fired reminder is delivered to as
+// well as its WhatsApp phone.
+type NotificationChannel struct {
+	Type   string
+	Target string // phone, email address or webhook URL, depending on Type
+}
+
+const (
+	NotificationChannelWhatsapp = "whatsapp"
+	NotificationChannelEmail    = "email"
+	NotificationChannelSMS      = "sms"
+	NotificationChannelSlack    = "slack"
+	NotificationChannelDiscord  = "discord"
+	NotificationChannelWebhook  = "webhook"
+)
+
+// At most this many channels may be configured per reminder.
+const MaxNotificationChannels = 5
+
+type SnoozeRecord struct {
+	SnoozedAt    time.Time
+	SnoozedUntil time.Time
 }
 
 type ReminderInput struct {
 	FromTime     time.Time
 	NMinutes     int
+	ReminderTime time.Time // takes precedence over NMinutes when set
 	ReminderText string
 	ReminderName string
 	Phone        string
 	ReferenceId  string
+	Recurrence   string // RRULE or English schedule, e.g. "every weekday"
+	// Retried requests with the same key (per phone) return the original reminder
+	IdempotencyKey string
+	TimeZone       string // IANA zone for recurrences; defaults to ReminderTime's
+	Escalation     []EscalationStep
+	Channels       []NotificationChannel
 }
 
 type ReminderResponse struct {
-	ReminderTime string
-	ReminderText string
-	ReminderName string
-	ReferenceId  string
+	ReminderTime   string
+	ReminderText   string
+	ReminderName   string
+	ReferenceId    string
+	ShortCode      string `json:",omitempty"`
+	Recurrence     string
+	Status         string                `json:",omitempty"`
+	Phone          string                `json:",omitempty"`
+	SnoozeHistory  []SnoozeRecord        `json:",omitempty"`
+	DeliveryStatus string                `json:",omitempty"`
+	DeliveredAt    string                `json:",omitempty"`
+	ReadAt         string                `json:",omitempty"`
+	DeliveryError  string                `json:",omitempty"`
+	Escalation     []EscalationStep      `json:",omitempty"`
+	Channels       []NotificationChannel `json:",omitempty"`
+}
+
+type ReminderListResponse struct {
+	Reminders     []ReminderResponse
+	NextPageToken string
+}
+
+// ReminderListFilter narrows down GET /reminders. Zero values match everything.
+type ReminderListFilter struct {
+	Phone         string
+	Status        string
+	From          time.Time // reminders due at or after From
+	To            time.Time // reminders due at or before To
+	Sort          string    // "reminderTime", "-reminderTime", "startTime" or "-startTime"
+	PageSize      int
+	NextPageToken []byte
 }
 
 type UpdateReminderSignal struct {
+	RequestId    string // correlates the signal with its UpdateReminderResult
 	NMinutes     int
+	ReminderTime time.Time
 	ReminderText string
 	ReminderName string
 	Phone        string
+	// Replace the escalation policy and channels unless nil; an empty list
+	// removes them
+	Escalation []EscalationStep
+	Channels   []NotificationChannel
+}
+
+const (
+	ReminderStatusPending      = "pending"
+	ReminderStatusFired        = "fired"
+	ReminderStatusAcknowledged = "acknowledged"
+	ReminderStatusCancelled    = "cancelled"
+	ReminderStatusFailed       = "failed"
+)
+
+// Statuses and sort orders reminders can be listed by.
+var ReminderStatuses = []string{
+	ReminderStatusPending, ReminderStatusFired, ReminderStatusAcknowledged, ReminderStatusCancelled, ReminderStatusFailed,
+}
+var ReminderListSorts = []string{"reminderTime", "-reminderTime", "startTime", "-startTime"}
+
+const (
+	ReminderActionSnooze  = "snooze"
+	ReminderActionDone    = "done"
+	ReminderActionDismiss = "dismiss"
+)
+
+type ReminderActionSignal struct {
+	Action    string
+	SnoozeFor time.Duration
+}
+
+const (
+	DeliveryStatusSent      = "sent"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusRead      = "read"
+	DeliveryStatusFailed    = "failed"
+)
+
+// DeliveryStatusSignal relays a WhatsApp status webhook to the reminder whose
+// message it refers to.
+type DeliveryStatusSignal struct {
+	MessageId string
+	Status    string
+	Timestamp time.Time
+	Error     string
+}
+
+// UpdateReminderResult is the outcome of an UpdateReminderSignal, as
+// reported by the "getUpdateResult" query.
+type UpdateReminderResult struct {
+	Done            bool
+	Error           string
+	ReminderDetails ReminderDetails
 }
 
 func GetReminderTime(startTime time.Time, duration time.Duration) time.Time {
 	return startTime.Add(duration)
 }
 
+// GetReminderTime resolves the absolute time requested by the input, falling
+// back to NMinutes after FromTime when no ReminderTime was given.
+func (r *ReminderInput) GetReminderTime() time.Time {
+	if !r.ReminderTime.IsZero() {
+		return r.ReminderTime
+	}
+	return GetReminderTime(r.FromTime, time.Duration(r.NMinutes)*time.Minute)
+}
+
+// Validate rejects inputs whose requested reminder time has already passed,
+// and normalizes Recurrence to an RRULE.
+func (r *ReminderInput) Validate() error {
+	reminderTime := r.GetReminderTime()
+	if reminderTime.Before(r.FromTime) {
+		return app.ReminderInPastError(reminderTime)
+	}
+	if r.Phone != "" {
+		phone, err := whatsapp.NormalizePhone(r.Phone)
+		if err != nil {
+			return err
+		}
+		r.Phone = phone
+	}
+	if r.Recurrence != "" {
+		rrule, err := recurrence.ParseSchedule(r.Recurrence)
+		if err != nil {
+			return err
+		}
+		r.Recurrence = rrule
+	}
+	if r.TimeZone != "" {
+		if _, err := time.LoadLocation(r.TimeZone); err != nil {
+			return errors.New(fmt.Sprintf("Unrecognized time zone %s", r.TimeZone))
+		}
+	}
+	if err := ValidateEscalation(r.Escalation); err != nil {
+		return err
+	}
+	if err := ValidateChannels(r.Channels); err != nil {
+		return err
+	}
+	return resolveChannels(r.Escalation, r.Channels)
+}
+
+func EscalationPolicyError(reason string) error {
+	return errors.New(fmt.Sprintf("Invalid escalation policy: %s", reason))
+}
+
+// ValidateEscalation normalizes each step's phone and channel as
+// NormalizePhone and ValidateChannels do.
+func ValidateEscalation(steps []EscalationStep) error {
+	if len(steps) > MaxEscalationSteps {
+		return EscalationPolicyError(fmt.Sprintf("at most %d steps are allowed", MaxEscalationSteps))
+	}
+	for i, step := range steps {
+		if step.AfterMinutes <= 0 {
+			return EscalationPolicyError(fmt.Sprintf("step %d must wait at least a minute", i+1))
+		}
+		if step.Phone != "" {
+			phone, err := whatsapp.NormalizePhone(step.Phone)
+			if err != nil {
+				return EscalationPolicyError(fmt.Sprintf("step %d: %s", i+1, err.Error()))
+			}
+			steps[i].Phone = phone
+		}
+		if step.Channel != nil {
+			if step.Phone != "" {
+				return EscalationPolicyError(fmt.Sprintf("step %d can't have both a phone and a channel", i+1))
+			}
+			channel := []NotificationChannel{*step.Channel}
+			if err := ValidateChannels(channel); err != nil {
+				return EscalationPolicyError(fmt.Sprintf("step %d: %s", i+1, err.Error()))
+			}
+			steps[i].Channel = &channel[0]
+		}
+	}
+	return nil
+}
+
+func NotificationChannelError(reason string) error {
+	return errors.New(fmt.Sprintf("Invalid notification channel: %s", reason))
+}
+
+// ValidateChannels normalizes WhatsApp and SMS targets as NormalizePhone does.
+func ValidateChannels(channels []NotificationChannel) error {
+	if len(channels) > MaxNotificationChannels {
+		return NotificationChannelError(fmt.Sprintf("at most %d channels are allowed", MaxNotificationChannels))
+	}
+	for i, channel := range channels {
+		if channel.Target == "" {
+			return NotificationChannelError(fmt.Sprintf("channel %d has no target", i+1))
+		}
+		switch channel.Type {
+		case NotificationChannelWhatsapp, NotificationChannelSMS:
+			phone, err := whatsapp.NormalizePhone(channel.Target)
+			if err != nil {
+				return NotificationChannelError(fmt.Sprintf("channel %d: %s", i+1, err.Error()))
+			}
+			channels[i].Target = phone
+		case NotificationChannelEmail:
+			if address, err := mail.ParseAddress(channel.Target); err != nil || address.Address != channel.Target {
+				return NotificationChannelError(fmt.Sprintf("channel %d email address %s is invalid", i+1, channel.Target))
+			}
+		case NotificationChannelSlack, NotificationChannelDiscord, NotificationChannelWebhook:
+			if err := ValidateOutboundUrl(channel.Target); err != nil {
+				return NotificationChannelError(fmt.Sprintf("channel %d: %s", i+1, err.Error()))
+			}
+		default:
+			return NotificationChannelError(fmt.Sprintf("channel %d has unrecognized type %s", i+1, channel.Type))
+		}
+	}
+	return nil
+}
+
+// resolveChannels checks that the URL channels of a reminder and its
+// escalation steps don't resolve to non-public addresses.
+func resolveChannels(steps []EscalationStep, channels []NotificationChannel) error {
+	targets := append([]NotificationChannel{}, channels...)
+	for _, step := range steps {
+		if step.Channel != nil {
+			targets = append(targets, *step.Channel)
+		}
+	}
+	for _, channel := range targets {
+		switch channel.Type {
+		case NotificationChannelSlack, NotificationChannelDiscord, NotificationChannelWebhook:
+			if err := ResolveOutboundUrl(channel.Target); err != nil {
+				return NotificationChannelError(err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// IsSnoozed reports whether the reminder's current time comes from a snooze.
+func (r *ReminderDetails) IsSnoozed() bool {
+	snoozes := r.SnoozeHistory
+	return len(snoozes) > 0 && snoozes[len(snoozes)-1].SnoozedUntil.Equal(r.ReminderTime)
+}
+
+// GetEscalationDelay returns how long after firing the last escalation step
+// is due.
+func (r *ReminderDetails) GetEscalationDelay() time.Duration {
+	var delay time.Duration
+	for _, step := range r.Escalation {
+		delay += time.Duration(step.AfterMinutes) * time.Minute
+	}
+	return delay
+}
+
+// GetShortCode returns the code users can refer to the reminder by.
+func (r *ReminderDetails) GetShortCode() string {
+	if r.WorkflowId == "" {
+		return ""
+	}
+	return MakeShortCode(r.WorkflowId)
+}
+
 func (r *ReminderDetails) GetMinutesToReminder(ctx workflow.Context) time.Duration {
 	return r.ReminderTime.Sub(workflow.Now(ctx))
 }
@@ -58,25 +374,173 @@ func (r *ReminderDetails) GetReminderTime() time.Time {
 	return r.ReminderTime
 }
 
-func MakeReferenceId(workflowId string, runId string) (string, error) {
-	if workflowId == "" || runId == "" {
-		return "", errors.New(fmt.Sprintf("Unable to create referenceId from workflowId %s runId%s", workflowId, runId))
+// GetNextOccurrence returns the occurrence of a recurring reminder following
+// the one at `after`, or false if the reminder does not recur any more.
+func (r *ReminderDetails) GetNextOccurrence(after time.Time) (time.Time, bool) {
+	if r.Recurrence == "" {
+		return time.Time{}, false
 	}
-	rawReferenceId := fmt.Sprintf("%s_%s", workflowId, runId)
-	return codec.Encode(rawReferenceId), nil
+	rule, err := recurrence.Parse(r.Recurrence)
+	if err != nil {
+		return time.Time{}, false
+	}
+	dtstart := r.RecurrenceStart
+	if location, err := time.LoadLocation(r.TimeZone); err == nil && r.TimeZone != "" {
+		dtstart = dtstart.In(location)
+	}
+	return rule.Next(dtstart, after)
+}
+
+// MakeWorkflowId returns a reminder workflow ID namespaced by phone. Requests
+// retried with the same idempotency key map to the same ID; otherwise every
+// reminder gets a fresh ULID. IDs never contain "_", which separated the
+// workflow and run IDs in legacy reference IDs.
+func MakeWorkflowId(phone string, idempotencyKey string) string {
+	prefix := "reminder"
+	if digits := nonDigits.ReplaceAllString(phone, ""); digits != "" {
+		prefix = fmt.Sprintf("reminder-%s", digits)
+	}
+	if idempotencyKey != "" {
+		sum := sha256.Sum256([]byte(idempotencyKey))
+		return fmt.Sprintf("%s-%s", prefix, hex.EncodeToString(sum[:16]))
+	}
+	return fmt.Sprintf("%s-%s", prefix, ulid.Make())
 }
 
+var nonDigits = regexp.MustCompile(`[^0-9]`)
+
+// ErrInvalidReferenceId is returned for reference IDs that weren't issued by
+// this app, or have been altered since.
+var ErrInvalidReferenceId = errors.New("Invalid ReferenceId.")
+
+// Signs reference IDs when REFERENCE_ID_KEYS is unset in one of
+// developmentEnvironments. It is public, so must never sign live references.
+const developmentReferenceIdKeys = "dev:development-only-reference-id-key"
+
+// Environments in which WhatsApp and other notifiers are mocked.
+var developmentEnvironments = []string{"TEST", "LOCAL"}
+
+var ErrReferenceIdKeysNotConfigured = errors.New(fmt.Sprintf(
+	"Reference IDs can't be signed; set REFERENCE_ID_KEYS, or ENV to one of %s for a development key.",
+	strings.Join(developmentEnvironments, ", "),
+))
+
+var referenceIdKeyring codec.Keyring
+var referenceIdKeyringErr error
+var referenceIdKeyringOnce sync.Once
+
+func getReferenceIdKeyring() (codec.Keyring, error) {
+	referenceIdKeyringOnce.Do(func() {
+		referenceIdKeyring, referenceIdKeyringErr = loadReferenceIdKeyring(app.ReferenceIdKeys, app.ENV)
+		if referenceIdKeyringErr != nil {
+			log.Println("Unable to load REFERENCE_ID_KEYS", referenceIdKeyringErr)
+		}
+	})
+	return referenceIdKeyring, referenceIdKeyringErr
+}
+
+// loadReferenceIdKeyring only falls back to the development key in a
+// development environment, so live references can't be forged with it.
+func loadReferenceIdKeyring(keys string, env string) (codec.Keyring, error) {
+	switch {
+	case keys != "":
+		log.Println("Signing reference IDs with REFERENCE_ID_KEYS")
+	case slices.Contains(developmentEnvironments, env):
+		log.Printf("REFERENCE_ID_KEYS is unset; signing reference IDs with the development key. ENV=%s", env)
+		keys = developmentReferenceIdKeys
+	default:
+		return codec.Keyring{}, ErrReferenceIdKeysNotConfigured
+	}
+	return codec.ParseKeyring(keys)
+}
+
+// MakeReferenceId signs only the workflow ID, so a reference stays valid
+// when the reminder continues as new under a fresh run ID.
+func MakeReferenceId(workflowId string) (string, error) {
+	if workflowId == "" {
+		return "", errors.New("Unable to create referenceId from empty workflowId")
+	}
+	keyring, err := getReferenceIdKeyring()
+	if err != nil {
+		return "", err
+	}
+	return keyring.Sign(workflowId)
+}
+
+// GetInternalIdsFromReferenceId returns the workflow ID a reference points at,
+// or ErrInvalidReferenceId if its signature doesn't check out. The run ID is
+// always empty, which addresses the workflow's latest run.
 func GetInternalIdsFromReferenceId(referenceId string) (string, string, error) {
 	if referenceId == "" {
 		return "", "", errors.New("Missing ReferenceId.")
 	}
-	decoded, err := codec.Decode(referenceId)
+	keyring, err := getReferenceIdKeyring()
 	if err != nil {
 		return "", "", err
 	}
-	idComponents := strings.Split(decoded, "_")
-	if len(idComponents) != 2 {
-		return "", "", errors.New(fmt.Sprintf("Unable to decode referenceId %s", referenceId))
+	workflowId, err := keyring.Verify(referenceId)
+	if err != nil || workflowId == "" {
+		return "", "", ErrInvalidReferenceId
+	}
+	return workflowId, "", nil
+}
+
+// GetInternalIdsFromLegacyReferenceId reads the unsigned, base64-encoded
+// "workflowId" and "workflowId_runId" references issued before reference IDs
+// were signed, which may still be on reminders and buttons already sent.
+// Anyone can make one, so callers must check that the reminder belongs to
+// whoever presented it.
+//
+// Deprecated: only accepted until the reminders that carry them have run.
+func GetInternalIdsFromLegacyReferenceId(referenceId string) (string, string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(referenceId)
+	if err != nil {
+		return "", "", ErrInvalidReferenceId
+	}
+	idComponents := strings.Split(string(decoded), "_")
+	if len(idComponents) > 2 || idComponents[0] == "" {
+		return "", "", ErrInvalidReferenceId
+	}
+	if len(idComponents) == 2 {
+		return idComponents[0], idComponents[1], nil
+	}
+	return idComponents[0], "", nil
+}
+
+// Short codes are a few Crockford base32 characters that users can type in
+// place of a reference ID. They're only unique among one phone's reminders.
+const ShortCodeLength = 6
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// Letters Crockford base32 reads as the digits they look like.
+var crockfordAliases = strings.NewReplacer("I", "1", "L", "1", "O", "0", "-", "")
+
+// MakeShortCode derives a reminder's short code from its workflow ID, so the
+// code stays the same across runs and retried requests.
+func MakeShortCode(workflowId string) string {
+	sum := sha256.Sum256([]byte(workflowId))
+	bits := uint64(sum[0])<<32 | uint64(sum[1])<<24 | uint64(sum[2])<<16 | uint64(sum[3])<<8 | uint64(sum[4])
+	code := make([]byte, ShortCodeLength)
+	for i := ShortCodeLength - 1; i >= 0; i-- {
+		code[i] = crockfordAlphabet[bits&31]
+		bits >>= 5
+	}
+	return string(code)
+}
+
+// NormalizeShortCode returns code in canonical form, accepting lower case,
+// hyphens and the letters I, L and O for 1, 1 and 0, or false if it isn't a
+// short code.
+func NormalizeShortCode(code string) (string, bool) {
+	code = crockfordAliases.Replace(strings.ToUpper(strings.TrimSpace(code)))
+	if len(code) != ShortCodeLength {
+		return "", false
+	}
+	for _, c := range code {
+		if !strings.ContainsRune(crockfordAlphabet, c) {
+			return "", false
+		}
 	}
-	return idComponents[0], idComponents[1], nil
+	return code, true
 }
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MakeShortCode(t *testing.T) {
+	code := MakeShortCode("reminder-16505551111-01G7ZR6RQH8K3N5M8V1X2Y3Z4A")
+	require.Len(t, code, ShortCodeLength)
+	require.Equal(t, code, MakeShortCode("reminder-16505551111-01G7ZR6RQH8K3N5M8V1X2Y3Z4A"))
+	require.NotEqual(t, code, MakeShortCode("reminder-16505551111-01G7ZR6RQH8K3N5M8V1X2Y3Z4B"))
+	normalized, ok := NormalizeShortCode(code)
+	require.True(t, ok)
+	require.Equal(t, code, normalized)
+}
+
+func Test_NormalizeShortCode(t *testing.T) {
+	for input, expected := range map[string]string{
+		"7K2QX9":   "7K2QX9",
+		"7k2qx9":   "7K2QX9",
+		"7k2-qx9":  "7K2QX9",
+		" 7K2QX9 ": "7K2QX9",
+		"oIl234":   "011234",
+	} {
+		code, ok := NormalizeShortCode(input)
+		require.True(t, ok, input)
+		require.Equal(t, expected, code)
+	}
+	for _, input := range []string{"", "7K2QX", "7K2QX9A", "7K2QU9", "7K2QX!", "2022-07.cmVt.aGVsbG8"} {
+		_, ok := NormalizeShortCode(input)
+		require.False(t, ok, input)
+	}
+}
+
+func Test_LoadReferenceIdKeyring(t *testing.T) {
+	for _, env := range []string{"PROD", "DEV", ""} {
+		_, err := loadReferenceIdKeyring("", env)
+		require.ErrorIs(t, err, ErrReferenceIdKeysNotConfigured, env)
+	}
+	for _, env := range []string{"TEST", "LOCAL"} {
+		_, err := loadReferenceIdKeyring("", env)
+		require.NoError(t, err, env)
+	}
+
+	keyring, err := loadReferenceIdKeyring("2022-07:a-long-enough-secret-1", "PROD")
+	require.NoError(t, err)
+	referenceId, err := keyring.Sign("reminder-1")
+	require.NoError(t, err)
+	development, err := loadReferenceIdKeyring("", "TEST")
+	require.NoError(t, err)
+	_, err = development.Verify(referenceId)
+	require.Error(t, err)
+}
+
+func Test_GetInternalIdsFromLegacyReferenceId(t *testing.T) {
+	// base64 of "reminder-16505551111-1", issued before references were signed
+	workflowId, runId, err := GetInternalIdsFromLegacyReferenceId("cmVtaW5kZXItMTY1MDU1NTExMTEtMQ==")
+	require.NoError(t, err)
+	require.Equal(t, "reminder-16505551111-1", workflowId)
+	require.Equal(t, "", runId)
+	// and of "reminder-workflow_0b6a6a44", from when references named a run
+	workflowId, runId, err = GetInternalIdsFromLegacyReferenceId("cmVtaW5kZXItd29ya2Zsb3dfMGI2YTZhNDQ=")
+	require.NoError(t, err)
+	require.Equal(t, "reminder-workflow", workflowId)
+	require.Equal(t, "0b6a6a44", runId)
+	// Signed references aren't legacy ones
+	_, _, err = GetInternalIdsFromLegacyReferenceId("dev.cmVtaW5kZXI.c2ln")
+	require.ErrorIs(t, err, ErrInvalidReferenceId)
+}
+
+func Test_ValidatePhonesAreNormalized(t *testing.T) {
+	steps := []EscalationStep{{AfterMinutes: 10}, {AfterMinutes: 15, Phone: "+1 (650) 555-2222"}}
+	require.NoError(t, ValidateEscalation(steps))
+	require.Equal(t, "16505552222", steps[1].Phone)
+	require.Error(t, ValidateEscalation([]EscalationStep{{AfterMinutes: 10, Phone: "555"}}))
+	steps = []EscalationStep{{AfterMinutes: 10, Channel: &NotificationChannel{Type: NotificationChannelSMS, Target: "+1 650 555 3333"}}}
+	require.NoError(t, ValidateEscalation(steps))
+	require.Equal(t, "16505553333", steps[0].Channel.Target)
+	require.Error(t, ValidateEscalation([]EscalationStep{{AfterMinutes: 10, Channel: &NotificationChannel{Type: "pager", Target: "x"}}}))
+	require.Error(t, ValidateEscalation([]EscalationStep{{AfterMinutes: 10, Phone: "16505552222", Channel: &NotificationChannel{Type: NotificationChannelEmail, Target: "kerry@example.com"}}}))
+
+	channels := []NotificationChannel{{Type: NotificationChannelSMS, Target: "+44 20 7946 0958"}}
+	require.NoError(t, ValidateChannels(channels))
+	require.Equal(t, "442079460958", channels[0].Target)
+	require.Error(t, ValidateChannels([]NotificationChannel{{Type: NotificationChannelWhatsapp, Target: "0123"}}))
+}
+
+func Test_OutboundUrls(t *testing.T) {
+	require.NoError(t, ValidateOutboundUrl("https://hooks.slack.com/services/T0/B0/X"))
+	for _, rawUrl := range []string{
+		"http://hooks.slack.com/services/T0/B0/X",
+		"https://localhost/hook",
+		"https://127.0.0.1/hook",
+		"https://10.0.0.5/hook",
+		"https://169.254.169.254/latest/meta-data",
+		"https://[::1]/hook",
+		"https://[fe80::1]/hook",
+	} {
+		require.Error(t, ValidateOutboundUrl(rawUrl), rawUrl)
+	}
+	channels := []NotificationChannel{{Type: NotificationChannelWebhook, Target: "http://example.com/hook"}}
+	require.Error(t, ValidateChannels(channels))
+
+	// Hosts are also rejected when they resolve to a non-public address
+	defer func(lookup func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = lookup }(lookupIPAddr)
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if host == "internal.example.com" {
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}, {IP: net.ParseIP("192.168.1.10")}}, nil
+		}
+		return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+	}
+	require.NoError(t, ResolveOutboundUrl("https://example.com/hook"))
+	require.Error(t, ResolveOutboundUrl("https://internal.example.com/hook"))
+	input := ReminderInput{
+		Phone:        "16505551111",
+		NMinutes:     10,
+		ReminderText: "Book return flights from Jakarta",
+		ReminderName: "Flights",
+		Escalation: []EscalationStep{
+			{AfterMinutes: 10, Channel: &NotificationChannel{Type: NotificationChannelSlack, Target: "https://internal.example.com/hook"}},
+		},
+	}
+	err := input.Validate()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "non-public")
+}
+
+func Test_OutboundHttpClientRefusesNonPublicAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	_, err := NewOutboundHttpClient(time.Second).Get(server.URL)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "isn't public")
+}
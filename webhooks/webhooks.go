@@ -0,0 +1,109 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"reminders/app"
+	"reminders/app/utils"
+)
+
+// Headers sent with every event. Subscribers verify a delivery by computing
+// the HMAC-SHA256 of "<timestamp>.<body>" with their secret, and should
+// reject old timestamps to prevent replays.
+const (
+	EventHeader     = "X-Reminder-Event"
+	EventIdHeader   = "X-Reminder-Event-Id"
+	TimestampHeader = "X-Reminder-Timestamp"
+	SignatureHeader = "X-Reminder-Signature-256"
+)
+
+// Event is the JSON body of a delivery.
+type Event struct {
+	Id         string                 `json:"id"` // the same for every attempt, so subscribers can ignore repeats
+	Event      string                 `json:"event"`
+	OccurredAt time.Time              `json:"occurredAt"`
+	Reminder   utils.ReminderResponse `json:"reminder"`
+}
+
+func DeliveryError(resp *http.Response) error {
+	return errors.New(fmt.Sprintf("Webhook subscriber responded with status %s", resp.Status))
+}
+
+// Sign returns the signature header value for a body sent at timestamp.
+func Sign(body []byte, timestamp time.Time, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Subscribers' URLs are given by API callers, so only public addresses are
+// dialled.
+var httpClient = utils.NewOutboundHttpClient(10 * time.Second)
+
+// NewEvent describes a lifecycle event of the reminder.
+func NewEvent(id string, event string, occurredAt time.Time, reminderDetails utils.ReminderDetails) Event {
+	return Event{
+		Id:         id,
+		Event:      event,
+		OccurredAt: occurredAt.UTC(),
+		Reminder: utils.ReminderResponse{
+			ReferenceId:  reminderDetails.ReferenceId,
+			ReminderName: reminderDetails.ReminderName,
+			ReminderText: reminderDetails.ReminderText,
+			ReminderTime: reminderDetails.GetReminderTime().Format(app.TIME_FORMAT),
+			Recurrence:   reminderDetails.Recurrence,
+			Status:       reminderDetails.Status,
+			Phone:        reminderDetails.Phone,
+		},
+	}
+}
+
+// Deliver POSTs the event to url, signed with secret. It returns the
+// response's status code, or 0 if there wasn't one; non-2xx responses are
+// errors.
+func Deliver(url string, secret string, event Event) (int, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event.Event)
+	req.Header.Set(EventIdHeader, event.Id)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(SignatureHeader, Sign(body, now, secret))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, DeliveryError(resp)
+	}
+	return resp.StatusCode, nil
+}
+
+// IsPermanentFailure reports whether retrying a delivery that got this status
+// is pointless: client errors other than timeouts and rate limiting.
+func IsPermanentFailure(statusCode int) bool {
+	return statusCode >= 400 && statusCode < 500 &&
+		statusCode != http.StatusRequestTimeout && statusCode != http.StatusTooManyRequests
+}
@@ -0,0 +1,70 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"reminders/app/utils"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Deliver(t *testing.T) {
+	var received *http.Request
+	var body []byte
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	// The test server listens on loopback, which deliveries can't reach
+	defaultClient := httpClient
+	httpClient = server.Client()
+	defer func() { httpClient = defaultClient }()
+
+	occurredAt := time.Date(2022, time.July, 11, 9, 0, 0, 0, time.UTC)
+	event := NewEvent("run-1-5", "fired", occurredAt, utils.ReminderDetails{
+		ReminderTime: occurredAt,
+		ReminderName: "Flights",
+		ReminderText: "Book return flights from Jakarta",
+		Phone:        "16505551111",
+		ReferenceId:  "cmVtaW5kZXItMTY1MDU1NTExMTEtMQ==",
+		Status:       utils.ReminderStatusFired,
+	})
+	statusCode, err := Deliver(server.URL, "secret", event)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, statusCode)
+
+	require.Equal(t, "fired", received.Header.Get(EventHeader))
+	require.Equal(t, "run-1-5", received.Header.Get(EventIdHeader))
+	seconds, err := strconv.ParseInt(received.Header.Get(TimestampHeader), 10, 64)
+	require.NoError(t, err)
+	require.Equal(t, Sign(body, time.Unix(seconds, 0), "secret"), received.Header.Get(SignatureHeader))
+	require.NotEqual(t, Sign(body, time.Unix(seconds, 0), "other"), received.Header.Get(SignatureHeader))
+	var decoded Event
+	require.NoError(t, json.Unmarshal(body, &decoded))
+	require.Equal(t, event, decoded)
+
+	status = http.StatusGone
+	statusCode, err = Deliver(server.URL, "secret", event)
+	require.Error(t, err)
+	require.Equal(t, http.StatusGone, statusCode)
+	require.True(t, IsPermanentFailure(statusCode))
+	require.False(t, IsPermanentFailure(http.StatusTooManyRequests))
+	require.False(t, IsPermanentFailure(http.StatusBadGateway))
+}
+
+func Test_Sign(t *testing.T) {
+	// Computed independently with
+	// printf '1657530000.{}' | openssl dgst -sha256 -hmac secret
+	require.Equal(t,
+		"sha256=963f19e56af4aa465fb517053fe2d43773078beaea6672c60e800600b3820406",
+		Sign([]byte("{}"), time.Unix(1657530000, 0), "secret"))
+}
@@ -0,0 +1,92 @@
+package whatsapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// GraphApiError is an error response from the Cloud API.
+// See https://developers.facebook.com/docs/whatsapp/cloud-api/support/error-codes
+type GraphApiError struct {
+	StatusCode int
+	Code       int
+	Subcode    int
+	Message    string
+	RetryAfter time.Duration // from the Retry-After header; 0 if absent
+}
+
+func (e *GraphApiError) Error() string {
+	return fmt.Sprintf("Error sending WhatsApp request. status=%d code=%d: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Graph API error codes that clear up by themselves: throttling and service
+// outages.
+var retryableGraphApiCodes = []int{
+	1,      // API unknown
+	2,      // API service
+	4,      // API too many calls
+	80007,  // rate limit issues
+	130429, // rate limit hit
+	131000, // something went wrong
+	131016, // service unavailable
+	131048, // spam rate limit hit
+	131056, // pair rate limit hit
+	133004, // server temporarily unavailable
+}
+
+// Retryable reports whether sending the same request again later may work.
+func (e *GraphApiError) Retryable() bool {
+	if e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500 {
+		return true
+	}
+	return slices.Contains(retryableGraphApiCodes, e.Code)
+}
+
+// RateLimitedError is returned instead of waiting too long to send from a
+// phone number that has reached WHATSAPP_MESSAGES_PER_SECOND.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("WhatsApp send rate exceeded; retry after %s", e.RetryAfter)
+}
+
+type graphApiErrorResponse struct {
+	Error struct {
+		Message string `json:"message"`
+		Code    int    `json:"code"`
+		Subcode int    `json:"error_subcode"`
+	} `json:"error"`
+}
+
+func WhatsappRequestError(resp *http.Response, body []byte) error {
+	var errorResponse graphApiErrorResponse
+	json.Unmarshal(body, &errorResponse)
+	return &GraphApiError{
+		StatusCode: resp.StatusCode,
+		Code:       errorResponse.Error.Code,
+		Subcode:    errorResponse.Error.Subcode,
+		Message:    errorResponse.Error.Message,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// parseRetryAfter reads either form of the header: seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
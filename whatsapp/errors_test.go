@@ -0,0 +1,96 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func Test_SendMessageGraphApiErrors(t *testing.T) {
+	tests := []struct {
+		status     int
+		retryAfter string
+		body       string
+		code       int
+		retryable  bool
+	}{
+		{http.StatusTooManyRequests, "30", `{"error": {"message": "Rate limit hit", "code": 130429}}`, 130429, true},
+		{http.StatusBadRequest, "", `{"error": {"message": "(#131056) (Business Account, Consumer Account) pair rate limit hit", "code": 131056}}`, 131056, true},
+		{http.StatusServiceUnavailable, "", `upstream unavailable`, 0, true},
+		{http.StatusBadRequest, "", `{"error": {"message": "Re-engagement message", "code": 131047}}`, 131047, false},
+		{http.StatusBadRequest, "", `{"error": {"message": "Invalid parameter", "code": 100, "error_subcode": 2494010}}`, 100, false},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if test.retryAfter != "" {
+				w.Header().Set("Retry-After", test.retryAfter)
+			}
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+		client := _LiveWhatsappClient{Tokens: StaticToken("token"), AccountId: "102925089154632", ApiUrl: server.URL}
+		_, err := client.SendMessage(context.Background(), "16505551111", "Flights")
+		server.Close()
+		var apiErr *GraphApiError
+		require.True(t, errors.As(err, &apiErr), test.body)
+		require.Equal(t, test.status, apiErr.StatusCode)
+		require.Equal(t, test.code, apiErr.Code)
+		require.Equal(t, test.retryable, apiErr.Retryable(), test.body)
+		if test.retryAfter != "" {
+			require.Equal(t, 30*time.Second, apiErr.RetryAfter)
+		}
+	}
+}
+
+func Test_ParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, time.July, 11, 8, 0, 0, 0, time.UTC)
+	require.Equal(t, 2*time.Minute, parseRetryAfter("120", now))
+	require.Equal(t, 90*time.Second, parseRetryAfter("Mon, 11 Jul 2022 08:01:30 GMT", now))
+	require.Equal(t, time.Duration(0), parseRetryAfter("Mon, 11 Jul 2022 07:59:00 GMT", now))
+	require.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+	require.Equal(t, time.Duration(0), parseRetryAfter("", now))
+}
+
+func Test_LimiterPerPhoneNumber(t *testing.T) {
+	require.Same(t, getLimiter("102925089154632"), getLimiter("102925089154632"))
+	require.NotSame(t, getLimiter("102925089154632"), getLimiter("102925089154633"))
+}
+
+func Test_SendMessageRateLimited(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"messages": [{"id": "wamid.1"}]}`))
+	}))
+	defer server.Close()
+	limitersMu.Lock()
+	limiters["rate-limited"] = rate.NewLimiter(rate.Every(time.Minute), 1)
+	limiters["deadline"] = rate.NewLimiter(rate.Every(2*time.Second), 1)
+	limitersMu.Unlock()
+
+	// Waits longer than MaxRateLimitWait fail without sending, so they can be retried
+	client := _LiveWhatsappClient{Tokens: StaticToken("token"), AccountId: "rate-limited", ApiUrl: server.URL}
+	_, err := client.SendMessage(context.Background(), "16505551111", "Flights")
+	require.NoError(t, err)
+	_, err = client.SendMessage(context.Background(), "16505551111", "Flights")
+	var rateErr *RateLimitedError
+	require.True(t, errors.As(err, &rateErr))
+	require.Greater(t, rateErr.RetryAfter, MaxRateLimitWait)
+	require.Equal(t, 1, requests)
+
+	// as do shorter ones that would outlast the caller's deadline
+	client.AccountId = "deadline"
+	_, err = client.SendMessage(context.Background(), "16505551111", "Flights")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = client.SendMessage(ctx, "16505551111", "Flights")
+	require.True(t, errors.As(err, &rateErr))
+	require.Equal(t, 2, requests)
+}
@@ -0,0 +1,138 @@
+package whatsapp
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Length limits, in characters, of the Cloud API's message fields.
+const (
+	MaxTextBodyLength          = 4096
+	MaxInteractiveBodyLength   = 1024
+	MaxReplyButtonTitleLength  = 20
+	MaxReplyButtonIdLength     = 256
+	MaxTemplateParameterLength = 1024
+)
+
+// Request bodies for POST /messages. Each message type sets one of Text,
+// Interactive or Template, matching Type.
+type messageRequest struct {
+	MessagingProduct string                  `json:"messaging_product"`
+	RecipientType    string                  `json:"recipient_type"`
+	To               string                  `json:"to"`
+	Type             string                  `json:"type"`
+	Text             *textMessageBody        `json:"text,omitempty"`
+	Interactive      *interactiveMessageBody `json:"interactive,omitempty"`
+	Template         *templateMessageBody    `json:"template,omitempty"`
+}
+
+type textMessageBody struct {
+	Body string `json:"body"`
+}
+
+type interactiveMessageBody struct {
+	Type string `json:"type"`
+	Body struct {
+		Text string `json:"text"`
+	} `json:"body"`
+	Action struct {
+		Buttons []interactiveButton `json:"buttons"`
+	} `json:"action"`
+}
+
+type interactiveButton struct {
+	Type  string `json:"type"`
+	Reply struct {
+		Id    string `json:"id"`
+		Title string `json:"title"`
+	} `json:"reply"`
+}
+
+type templateMessageBody struct {
+	Name     string `json:"name"`
+	Language struct {
+		Code string `json:"code"`
+	} `json:"language"`
+	Components []templateComponent `json:"components"`
+}
+
+type templateComponent struct {
+	Type       string              `json:"type"`
+	SubType    string              `json:"sub_type,omitempty"`
+	Index      string              `json:"index,omitempty"`
+	Parameters []templateParameter `json:"parameters"`
+}
+
+type templateParameter struct {
+	Type    string `json:"type"`
+	Text    string `json:"text,omitempty"`
+	Payload string `json:"payload,omitempty"`
+}
+
+// newMessageRequest addresses a message body, which must be one of the
+// *MessageBody types, to a normalized phone number.
+func newMessageRequest(to string, body interface{}) messageRequest {
+	request := messageRequest{MessagingProduct: "whatsapp", RecipientType: "individual", To: to}
+	switch body := body.(type) {
+	case *textMessageBody:
+		request.Type, request.Text = "text", body
+	case *interactiveMessageBody:
+		request.Type, request.Interactive = "interactive", body
+	case *templateMessageBody:
+		request.Type, request.Template = "template", body
+	}
+	return request
+}
+
+func InvalidPhoneError(phone string) error {
+	return errors.New(fmt.Sprintf("Invalid phone number %q; expected E.164, e.g. +16505551111", phone))
+}
+
+// Separators people commonly write phone numbers with.
+var phoneSeparators = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "")
+
+var e164Digits = regexp.MustCompile(`^[1-9][0-9]{6,14}$`)
+
+// NormalizePhone checks that phone is an E.164 number, optionally written
+// with a leading + and separators, and returns its digits, which is how
+// WhatsApp identifies users.
+func NormalizePhone(phone string) (string, error) {
+	digits := strings.TrimPrefix(phoneSeparators.Replace(phone), "+")
+	if !e164Digits.MatchString(digits) {
+		return "", InvalidPhoneError(phone)
+	}
+	return digits, nil
+}
+
+// truncate shortens text to at most limit characters, ending with an
+// ellipsis if anything was cut.
+func truncate(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:limit-1]) + "…"
+}
+
+// splitMessage breaks text into parts of at most limit characters, preferably
+// at a line break or space.
+func splitMessage(text string, limit int) []string {
+	parts := []string{}
+	runes := []rune(text)
+	for len(runes) > limit {
+		cut := limit
+		for i := limit; i >= limit/2; i-- {
+			if unicode.IsSpace(runes[i]) {
+				cut = i
+				break
+			}
+		}
+		parts = append(parts, strings.TrimRightFunc(string(runes[:cut]), unicode.IsSpace))
+		runes = []rune(strings.TrimLeftFunc(string(runes[cut:]), unicode.IsSpace))
+	}
+	return append(parts, string(runes))
+}
@@ -0,0 +1,129 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Reminder text that would break, or add fields to, a hand-built JSON body.
+const hostileText = "Book \"flights\" \\ from Jakarta\n\t</script>\", \"type\": \"template\", \"to\": \"15555550000"
+
+// startGraphApi records the bodies of messages posted to it.
+func startGraphApi(t *testing.T) (_LiveWhatsappClient, *[]map[string]interface{}) {
+	received := []map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v13.0/102925089154632/messages", r.URL.Path)
+		require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		body, _ := ioutil.ReadAll(r.Body)
+		var request map[string]interface{}
+		require.NoError(t, json.Unmarshal(body, &request), string(body))
+		received = append(received, request)
+		w.Write([]byte(`{"messages": [{"id": "wamid.` + string(rune('0'+len(received))) + `"}]}`))
+	}))
+	t.Cleanup(server.Close)
+	return _LiveWhatsappClient{Tokens: StaticToken("token"), AccountId: "102925089154632", ApiUrl: server.URL}, &received
+}
+
+func Test_SendMessageHostileText(t *testing.T) {
+	client, received := startGraphApi(t)
+	id, err := client.SendMessage(context.Background(), "+1 (650) 555-1111", hostileText)
+	require.NoError(t, err)
+	require.Equal(t, "wamid.1", id)
+	require.Equal(t, []map[string]interface{}{{
+		"messaging_product": "whatsapp",
+		"recipient_type":    "individual",
+		"to":                "16505551111",
+		"type":              "text",
+		"text":              map[string]interface{}{"body": hostileText},
+	}}, *received)
+}
+
+func Test_SendInteractiveMessageHostileText(t *testing.T) {
+	client, received := startGraphApi(t)
+	_, err := client.SendInteractiveMessage(context.Background(), "16505551111", hostileText, []ReplyButton{
+		{Id: "Done \"abc\"", Title: "Done, and \"dusted\" today"},
+	})
+	require.NoError(t, err)
+	require.Len(t, *received, 1)
+	request := (*received)[0]
+	require.Equal(t, "interactive", request["type"])
+	interactive := request["interactive"].(map[string]interface{})
+	require.Equal(t, hostileText, interactive["body"].(map[string]interface{})["text"])
+	button := interactive["action"].(map[string]interface{})["buttons"].([]interface{})[0].(map[string]interface{})
+	require.Equal(t, "Done \"abc\"", button["reply"].(map[string]interface{})["id"])
+	require.Equal(t, "Done, and \"dusted\" …", button["reply"].(map[string]interface{})["title"])
+}
+
+func Test_SendTemplateMessageHostileText(t *testing.T) {
+	client, received := startGraphApi(t)
+	_, err := client.SendTemplateMessage(context.Background(), "16505551111", Template{
+		Name:               "reminder",
+		Language:           "en_US",
+		Parameters:         []string{"Flights", hostileText},
+		QuickReplyPayloads: []string{"Done \"abc\""},
+	})
+	require.NoError(t, err)
+	require.Len(t, *received, 1)
+	template := (*received)[0]["template"].(map[string]interface{})
+	require.Equal(t, "reminder", template["name"])
+	components := template["components"].([]interface{})
+	require.Len(t, components, 2)
+	parameters := components[0].(map[string]interface{})["parameters"].([]interface{})
+	require.Equal(t, strings.Join(strings.Fields(hostileText), " "), parameters[1].(map[string]interface{})["text"])
+	button := components[1].(map[string]interface{})
+	require.Equal(t, "quick_reply", button["sub_type"])
+	require.Equal(t, "Done \"abc\"", button["parameters"].([]interface{})[0].(map[string]interface{})["payload"])
+}
+
+func Test_SendMessageSplitsLongText(t *testing.T) {
+	client, received := startGraphApi(t)
+	text := strings.Repeat("Book return flights from Jakarta. ", 200)
+	id, err := client.SendMessage(context.Background(), "16505551111", text)
+	require.NoError(t, err)
+	require.Equal(t, "wamid.1", id)
+	require.Len(t, *received, 2)
+	var parts []string
+	for _, request := range *received {
+		body := request["text"].(map[string]interface{})["body"].(string)
+		require.LessOrEqual(t, utf8.RuneCountInString(body), MaxTextBodyLength)
+		parts = append(parts, body)
+	}
+	require.Equal(t, strings.TrimSpace(text), strings.TrimSpace(strings.Join(parts, " ")))
+}
+
+func Test_SendMessageInvalidPhone(t *testing.T) {
+	client, received := startGraphApi(t)
+	_, err := client.SendMessage(context.Background(), "16505551111\", \"type\": \"template", "Hello")
+	require.Error(t, err)
+	require.Empty(t, *received)
+}
+
+func Test_NormalizePhone(t *testing.T) {
+	for _, phone := range []string{"16505551111", "+16505551111", "+1 650-555-1111", "+44 (20) 7946.0958"} {
+		normalized, err := NormalizePhone(phone)
+		require.NoError(t, err, phone)
+		require.Regexp(t, `^[1-9][0-9]+$`, normalized)
+	}
+	for _, phone := range []string{"", "+", "06505551111", "12345", "1650555111122223", "1650555111a", "+1\n6505551111"} {
+		_, err := NormalizePhone(phone)
+		require.Error(t, err, phone)
+	}
+}
+
+func Test_SplitMessage(t *testing.T) {
+	require.Equal(t, []string{"Hello"}, splitMessage("Hello", 10))
+	require.Equal(t, []string{"Book", "return", "flights"}, splitMessage("Book return flights", 8))
+	// Words longer than the limit are broken
+	require.Equal(t, []string{"Jakar", "ta"}, splitMessage("Jakarta", 5))
+	require.Equal(t, []string{"日本語の", "テキスト"}, splitMessage("日本語のテキスト", 4))
+	require.Equal(t, "Flig…", truncate("Flights", 5))
+	require.Equal(t, "日本…", truncate("日本語の", 3))
+}
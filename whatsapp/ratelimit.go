@@ -0,0 +1,59 @@
+package whatsapp
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"reminders/app"
+
+	"golang.org/x/time/rate"
+)
+
+// Each business phone number has its own throughput limit, so messages are
+// throttled per phone number ID, across all the clients in the process.
+var limiters = map[string]*rate.Limiter{}
+var limitersMu sync.Mutex
+
+func getLimiter(phoneNumberId string) *rate.Limiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	limiter, ok := limiters[phoneNumberId]
+	if !ok {
+		limit := rate.Limit(app.WhatsappMessagesPerSecond)
+		if app.WhatsappMessagesPerSecond <= 0 {
+			limit = rate.Inf
+		}
+		limiter = rate.NewLimiter(limit, app.WhatsappMessagesPerSecond)
+		limiters[phoneNumberId] = limiter
+	}
+	return limiter
+}
+
+// Sends wait at most this long for the phone number's rate limiter; longer
+// waits are returned as errors, so callers can retry later instead.
+const MaxRateLimitWait = 5 * time.Second
+
+// waitToSend blocks until the phone number may send another message, unless
+// that would take longer than MaxRateLimitWait or run past ctx's deadline.
+func waitToSend(ctx context.Context, phoneNumberId string) error {
+	reservation := getLimiter(phoneNumberId).Reserve()
+	delay := reservation.Delay()
+	if delay == 0 {
+		return nil
+	}
+	deadline, hasDeadline := ctx.Deadline()
+	if delay > MaxRateLimitWait || (hasDeadline && time.Now().Add(delay).After(deadline)) {
+		reservation.Cancel()
+		return &RateLimitedError{RetryAfter: delay}
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		reservation.Cancel()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,50 @@
+package whatsapp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testAppSecret = "test-app-secret"
+
+// A webhook body and its signature with testAppSecret, computed independently
+// with `openssl dgst -sha256 -hmac`.
+const signedFixtureBody = `{"object":"whatsapp_business_account","entry":[{"id":"0","changes":[{"field":"messages","value":{"messages":[{"from":"16505551111","id":"wamid.1","timestamp":"1657526400","text":{"body":"New reminder Flights: Book return flights: 1H"},"type":"text"}]}}]}]}`
+const signedFixtureSignature = "sha256=c7ff6297d80773614f93d237cc4a9d4429617c349f5df3767170eb8f0c2ce74c"
+
+func Test_VerifySignature(t *testing.T) {
+	body := []byte(signedFixtureBody)
+	signature := Sign(body, testAppSecret)
+	require.NoError(t, VerifySignature(body, signature, testAppSecret))
+
+	tests := []struct {
+		name      string
+		body      []byte
+		signature string
+		secret    string
+		reason    string
+	}{
+		{"no secret configured", body, signature, "", SignatureNotConfigured},
+		{"missing header", body, "", testAppSecret, SignatureMissing},
+		{"sha1 signature", body, "sha1=da39a3ee5e6b4b0d3255bfef95601890afd80709", testAppSecret, SignatureMalformed},
+		{"not hex", body, "sha256=not-a-digest", testAppSecret, SignatureMalformed},
+		{"truncated", body, signature[:len(signature)-2], testAppSecret, SignatureMalformed},
+		{"wrong secret", body, Sign(body, "another-secret"), testAppSecret, SignatureMismatch},
+		{"forged body", []byte(`{"entry":[{"changes":[{"value":{"messages":[{"from":"15555550000"}]}}]}]}`), signature, testAppSecret, SignatureMismatch},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifySignature(test.body, test.signature, test.secret)
+			var signatureErr *SignatureError
+			require.ErrorAs(t, err, &signatureErr)
+			require.Equal(t, test.reason, signatureErr.Reason)
+		})
+	}
+}
+
+func Test_VerifySignatureFixture(t *testing.T) {
+	require.NoError(t, VerifySignature([]byte(signedFixtureBody), signedFixtureSignature, testAppSecret))
+	// Signatures cover the raw bytes, so re-encoding the same JSON invalidates them
+	require.Error(t, VerifySignature([]byte(signedFixtureBody+"\n"), signedFixtureSignature, testAppSecret))
+}
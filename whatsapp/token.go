@@ -0,0 +1,186 @@
+package whatsapp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"reminders/app"
+)
+
+// ITokenProvider supplies the access token for Cloud API requests.
+type ITokenProvider interface {
+	GetToken() (string, error)
+	// Invalidate reports that the API rejected token, so that the next
+	// GetToken fetches a new one.
+	Invalidate(token string)
+}
+
+// StaticToken always provides the same token.
+type StaticToken string
+
+func (t StaticToken) GetToken() (string, error) {
+	return string(t), nil
+}
+
+func (t StaticToken) Invalidate(token string) {}
+
+func TokenError(reason string) error {
+	return errors.New(fmt.Sprintf("Unable to get WhatsApp access token: %s", reason))
+}
+
+// Long-lived tokens are refreshed once less than this much of their lifetime
+// is left.
+const tokenRefreshMargin = 24 * time.Hour
+
+// TokenProvider exchanges a seed token for a long-lived one with the Graph
+// API and caches it until shortly before it expires. The seed is read from
+// TokenFile if set, and otherwise from Token, so a rotated token can be
+// picked up by rewriting the file without restarting anything. Without an
+// app ID and secret the seed is used as is, which suits system-user tokens.
+type TokenProvider struct {
+	Token     string
+	TokenFile string
+	AppId     string
+	AppSecret string
+	ApiUrl    string // defaults to GraphApiUrl
+	now       func() time.Time
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time // zero if the token doesn't expire
+}
+
+func (p *TokenProvider) GetToken() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.getNow()
+	if p.token != "" && (p.expiresAt.IsZero() || now.Before(p.expiresAt.Add(-tokenRefreshMargin))) {
+		return p.token, nil
+	}
+	err := p.refresh(now)
+	if err != nil && p.token != "" && now.Before(p.expiresAt) {
+		// Keep using the current token until it actually expires
+		log.Println("Unable to refresh WhatsApp access token", err)
+		return p.token, nil
+	}
+	return p.token, err
+}
+
+func (p *TokenProvider) Invalidate(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token == token {
+		p.token = ""
+		p.expiresAt = time.Time{}
+	}
+}
+
+// refresh replaces the cached token; p.mu must be held.
+func (p *TokenProvider) refresh(now time.Time) error {
+	seed, err := p.readSeed()
+	if err != nil {
+		return err
+	}
+	if p.AppId == "" || p.AppSecret == "" {
+		p.token, p.expiresAt = seed, time.Time{}
+		return nil
+	}
+	// A long-lived token that is still valid can be exchanged for a fresh one
+	if p.token != "" && now.Before(p.expiresAt) {
+		seed = p.token
+	}
+	token, expiresIn, err := p.exchange(seed)
+	if err != nil {
+		return err
+	}
+	p.token, p.expiresAt = token, time.Time{}
+	if expiresIn > 0 {
+		p.expiresAt = now.Add(expiresIn)
+	}
+	return nil
+}
+
+func (p *TokenProvider) readSeed() (string, error) {
+	if p.TokenFile == "" {
+		if p.Token == "" {
+			return "", TokenError("no token configured")
+		}
+		return p.Token, nil
+	}
+	data, err := ioutil.ReadFile(p.TokenFile)
+	if err != nil {
+		return "", TokenError(err.Error())
+	}
+	seed := strings.TrimSpace(string(data))
+	if seed == "" {
+		return "", TokenError(fmt.Sprintf("%s is empty", p.TokenFile))
+	}
+	return seed, nil
+}
+
+type tokenExchangeResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"` // seconds; absent for tokens that don't expire
+}
+
+func (p *TokenProvider) exchange(seed string) (string, time.Duration, error) {
+	apiUrl := p.ApiUrl
+	if apiUrl == "" {
+		apiUrl = GraphApiUrl
+	}
+	query := url.Values{
+		"grant_type":        {"fb_exchange_token"},
+		"client_id":         {p.AppId},
+		"client_secret":     {p.AppSecret},
+		"fb_exchange_token": {seed},
+	}
+	resp, err := tokenHttpClient.Get(fmt.Sprintf("%s/v13.0/oauth/access_token?%s", apiUrl, query.Encode()))
+	if err != nil {
+		return "", 0, TokenError(err.Error())
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		return "", 0, TokenError(fmt.Sprintf("exchange failed. status=%s", resp.Status))
+	}
+	var exchanged tokenExchangeResponse
+	if err := json.Unmarshal(body, &exchanged); err != nil || exchanged.AccessToken == "" {
+		return "", 0, TokenError("exchange response has no access_token")
+	}
+	log.Println("Refreshed WhatsApp access token; expires in", exchanged.ExpiresIn, "seconds")
+	return exchanged.AccessToken, time.Duration(exchanged.ExpiresIn) * time.Second, nil
+}
+
+func (p *TokenProvider) getNow() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+var tokenHttpClient = &http.Client{Timeout: 30 * time.Second}
+
+var tokenProvider *TokenProvider
+var tokenProviderOnce sync.Once
+
+// GetTokenProvider returns the process-wide token provider, configured from
+// the environment, which every live client shares.
+func GetTokenProvider() ITokenProvider {
+	tokenProviderOnce.Do(func() {
+		tokenProvider = &TokenProvider{
+			Token:     app.WhatsappToken,
+			TokenFile: app.WhatsappTokenFile,
+			AppId:     app.WhatsappAppId,
+			AppSecret: app.WhatsappAppSecret,
+		}
+	})
+	return tokenProvider
+}
@@ -0,0 +1,104 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startTokenExchange issues long-lived-N tokens, valid for a day, and records
+// the tokens it was asked to exchange.
+func startTokenExchange(t *testing.T) (*httptest.Server, *[]string) {
+	exchanged := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v13.0/oauth/access_token", r.URL.Path)
+		require.Equal(t, "app-id", r.URL.Query().Get("client_id"))
+		require.Equal(t, "app-secret", r.URL.Query().Get("client_secret"))
+		exchanged = append(exchanged, r.URL.Query().Get("fb_exchange_token"))
+		fmt.Fprintf(w, `{"access_token": "long-lived-%d", "token_type": "bearer", "expires_in": %d}`, len(exchanged), 7*24*60*60)
+	}))
+	t.Cleanup(server.Close)
+	return server, &exchanged
+}
+
+func Test_TokenProviderRefresh(t *testing.T) {
+	server, exchanged := startTokenExchange(t)
+	now := time.Date(2022, time.July, 11, 9, 0, 0, 0, time.UTC)
+	provider := &TokenProvider{Token: "short-lived", AppId: "app-id", AppSecret: "app-secret", ApiUrl: server.URL, now: func() time.Time { return now }}
+
+	token, err := provider.GetToken()
+	require.NoError(t, err)
+	require.Equal(t, "long-lived-1", token)
+	now = now.Add(5 * 24 * time.Hour)
+	token, err = provider.GetToken()
+	require.NoError(t, err)
+	require.Equal(t, "long-lived-1", token)
+	require.Equal(t, []string{"short-lived"}, *exchanged)
+
+	// Within a day of expiry, the token is exchanged for a fresh one
+	now = now.Add(36 * time.Hour)
+	token, err = provider.GetToken()
+	require.NoError(t, err)
+	require.Equal(t, "long-lived-2", token)
+	require.Equal(t, []string{"short-lived", "long-lived-1"}, *exchanged)
+
+	// Once rejected, it's replaced even though it hasn't expired
+	provider.Invalidate("long-lived-1")
+	token, _ = provider.GetToken()
+	require.Equal(t, "long-lived-2", token)
+	provider.Invalidate("long-lived-2")
+	token, _ = provider.GetToken()
+	require.Equal(t, "long-lived-3", token)
+	require.Equal(t, "short-lived", (*exchanged)[2])
+}
+
+func Test_TokenProviderFile(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "whatsapp-token")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("system-user-1\n"), 0600))
+	provider := &TokenProvider{Token: "from-env", TokenFile: tokenFile}
+	token, err := provider.GetToken()
+	require.NoError(t, err)
+	require.Equal(t, "system-user-1", token)
+
+	// A rotated token is picked up once the old one is rejected
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("system-user-2\n"), 0600))
+	token, _ = provider.GetToken()
+	require.Equal(t, "system-user-1", token)
+	provider.Invalidate(token)
+	token, err = provider.GetToken()
+	require.NoError(t, err)
+	require.Equal(t, "system-user-2", token)
+
+	_, err = (&TokenProvider{}).GetToken()
+	require.Error(t, err)
+}
+
+func Test_SendMessageRefreshesRejectedToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "whatsapp-token")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("revoked"), 0600))
+	authorizations := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer rotated" {
+			// The file is rotated while the old token is in use
+			ioutil.WriteFile(tokenFile, []byte("rotated"), 0600)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"messages": [{"id": "wamid.1"}]}`))
+	}))
+	defer server.Close()
+
+	client := _LiveWhatsappClient{Tokens: &TokenProvider{TokenFile: tokenFile}, AccountId: "102925089154632", ApiUrl: server.URL}
+	id, err := client.SendMessage(context.Background(), "16505551111", "Reminder: Flights: Book return flights from Jakarta")
+	require.NoError(t, err)
+	require.Equal(t, "wamid.1", id)
+	require.Equal(t, []string{"Bearer revoked", "Bearer rotated"}, authorizations)
+}
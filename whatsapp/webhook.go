@@ -0,0 +1,199 @@
+package whatsapp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Webhook payloads posted by the WhatsApp Cloud API. A single delivery may
+// batch several entries, changes, messages and statuses.
+// See https://developers.facebook.com/docs/whatsapp/cloud-api/webhooks/components
+
+type WebhookPayload struct {
+	Object string         `json:"object"`
+	Entry  []WebhookEntry `json:"entry"`
+}
+
+type WebhookEntry struct {
+	Id      string          `json:"id"`
+	Changes []WebhookChange `json:"changes"`
+}
+
+type WebhookChange struct {
+	Field string       `json:"field"`
+	Value WebhookValue `json:"value"`
+}
+
+type WebhookValue struct {
+	MessagingProduct string          `json:"messaging_product"`
+	Metadata         WebhookMetadata `json:"metadata"`
+	Contacts         []Contact       `json:"contacts"`
+	Messages         []Message       `json:"messages"`
+	Statuses         []Status        `json:"statuses"`
+	Errors           []WebhookError  `json:"errors"`
+}
+
+type WebhookMetadata struct {
+	DisplayPhoneNumber string `json:"display_phone_number"`
+	PhoneNumberId      string `json:"phone_number_id"`
+}
+
+type Contact struct {
+	WaId    string `json:"wa_id"`
+	Profile struct {
+		Name string `json:"name"`
+	} `json:"profile"`
+}
+
+// Message types handled by DispatchWebhook; any other type is unsupported.
+const (
+	MessageTypeText        = "text"
+	MessageTypeInteractive = "interactive"
+	MessageTypeButton      = "button"
+	MessageTypeReaction    = "reaction"
+)
+
+type Message struct {
+	From        string              `json:"from"`
+	Id          string              `json:"id"`
+	Timestamp   string              `json:"timestamp"` // Unix seconds
+	Type        string              `json:"type"`
+	Context     *MessageContext     `json:"context,omitempty"`
+	Text        *TextMessage        `json:"text,omitempty"`
+	Interactive *InteractiveMessage `json:"interactive,omitempty"`
+	Button      *ButtonMessage      `json:"button,omitempty"`
+	Reaction    *Reaction           `json:"reaction,omitempty"`
+	Errors      []WebhookError      `json:"errors,omitempty"`
+}
+
+// MessageContext identifies the message being replied to.
+type MessageContext struct {
+	From string `json:"from"`
+	Id   string `json:"id"`
+}
+
+type TextMessage struct {
+	Body string `json:"body"`
+}
+
+type InteractiveMessage struct {
+	Type        string       `json:"type"`
+	ButtonReply *ReplyAnswer `json:"button_reply,omitempty"`
+	ListReply   *ReplyAnswer `json:"list_reply,omitempty"`
+}
+
+// ReplyAnswer is the reply button or list row the user picked.
+type ReplyAnswer struct {
+	Id    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// ButtonMessage is a tap on a template's quick-reply button.
+type ButtonMessage struct {
+	Payload string `json:"payload"`
+	Text    string `json:"text"`
+}
+
+type Reaction struct {
+	MessageId string `json:"message_id"`
+	Emoji     string `json:"emoji"` // empty when a reaction is removed
+}
+
+// Status is a delivery receipt for a message we sent.
+type Status struct {
+	Id          string         `json:"id"`
+	Status      string         `json:"status"` // sent, delivered, read or failed
+	Timestamp   string         `json:"timestamp"`
+	RecipientId string         `json:"recipient_id"`
+	Errors      []WebhookError `json:"errors,omitempty"`
+}
+
+type WebhookError struct {
+	Code    int    `json:"code"`
+	Title   string `json:"title"`
+	Message string `json:"message,omitempty"`
+}
+
+func WebhookParseError(reason string) error {
+	return errors.New(fmt.Sprintf("Unable to parse WhatsApp webhook: %s", reason))
+}
+
+func ParseWebhook(body []byte) (WebhookPayload, error) {
+	var payload WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return payload, WebhookParseError(err.Error())
+	}
+	return payload, nil
+}
+
+// GetTime returns when the message was sent.
+func (m Message) GetTime() (time.Time, error) {
+	return parseWebhookTimestamp(m.Timestamp)
+}
+
+// GetTime returns when the message reached this status.
+func (s Status) GetTime() (time.Time, error) {
+	return parseWebhookTimestamp(s.Timestamp)
+}
+
+func parseWebhookTimestamp(timestamp string) (time.Time, error) {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, WebhookParseError(fmt.Sprintf("invalid timestamp %q", timestamp))
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// IWebhookHandler receives the messages and statuses in a webhook, one at a
+// time, from DispatchWebhook.
+type IWebhookHandler interface {
+	HandleText(message Message, text string) error
+	// HandleButtonReply receives the ID of a tapped reply button, or the
+	// payload of a template quick-reply button.
+	HandleButtonReply(message Message, id string) error
+	HandleReaction(message Message, reaction Reaction) error
+	HandleStatus(status Status) error
+	HandleUnsupported(message Message) error
+}
+
+// DispatchWebhook routes every message and status in the payload to the
+// handler. A failure doesn't stop the rest of the payload from being
+// dispatched; the first error is returned.
+func DispatchWebhook(payload WebhookPayload, handler IWebhookHandler) error {
+	var firstErr error
+	for _, entry := range payload.Entry {
+		for _, change := range entry.Changes {
+			for _, message := range change.Value.Messages {
+				if err := dispatchMessage(message, handler); err != nil && firstErr == nil {
+					firstErr = err
+				}
+			}
+			for _, status := range change.Value.Statuses {
+				if err := handler.HandleStatus(status); err != nil && firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}
+	return firstErr
+}
+
+func dispatchMessage(message Message, handler IWebhookHandler) error {
+	switch {
+	case message.Type == MessageTypeText && message.Text != nil:
+		return handler.HandleText(message, message.Text.Body)
+	case message.Type == MessageTypeInteractive && message.Interactive != nil && message.Interactive.ButtonReply != nil:
+		return handler.HandleButtonReply(message, message.Interactive.ButtonReply.Id)
+	case message.Type == MessageTypeInteractive && message.Interactive != nil && message.Interactive.ListReply != nil:
+		return handler.HandleButtonReply(message, message.Interactive.ListReply.Id)
+	case message.Type == MessageTypeButton && message.Button != nil:
+		return handler.HandleButtonReply(message, message.Button.Payload)
+	case message.Type == MessageTypeReaction && message.Reaction != nil:
+		return handler.HandleReaction(message, *message.Reaction)
+	default:
+		return handler.HandleUnsupported(message)
+	}
+}
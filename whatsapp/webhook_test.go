@@ -0,0 +1,119 @@
+package whatsapp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const batchedWebhookBody = `{
+	"object": "whatsapp_business_account",
+	"entry": [
+		{
+			"id": "102925089154632",
+			"changes": [{
+				"field": "messages",
+				"value": {
+					"messaging_product": "whatsapp",
+					"metadata": {"display_phone_number": "15550001111", "phone_number_id": "105954558954427"},
+					"contacts": [{"profile": {"name": "Kerry"}, "wa_id": "16505551111"}],
+					"messages": [
+						{"from": "16505551111", "id": "wamid.1", "timestamp": "1657526400", "type": "text", "text": {"body": "New reminder Flights: Book return flights: 1H"}},
+						{"from": "16505551111", "id": "wamid.2", "timestamp": "1657526401", "type": "interactive",
+							"interactive": {"type": "button_reply", "button_reply": {"id": "Done abc", "title": "Done"}}},
+						{"from": "16505551111", "id": "wamid.3", "timestamp": "1657526402", "type": "reaction",
+							"reaction": {"message_id": "wamid.0", "emoji": "👍"}}
+					]
+				}
+			}]
+		},
+		{
+			"id": "102925089154632",
+			"changes": [{
+				"field": "messages",
+				"value": {
+					"messaging_product": "whatsapp",
+					"messages": [
+						{"from": "16505552222", "id": "wamid.4", "timestamp": "1657526403", "type": "button",
+							"button": {"payload": "Snooze abc: 15m", "text": "Snooze"}},
+						{"from": "16505552222", "id": "wamid.5", "timestamp": "1657526404", "type": "image",
+							"image": {"id": "1", "mime_type": "image/jpeg"}}
+					],
+					"statuses": [
+						{"id": "wamid.0", "status": "delivered", "timestamp": "1657526405", "recipient_id": "16505551111"},
+						{"id": "wamid.9", "status": "failed", "timestamp": "1657526406", "recipient_id": "16505552222",
+							"errors": [{"code": 131047, "title": "Re-engagement message"}]}
+					]
+				}
+			}]
+		}
+	]
+}`
+
+type recordingWebhookHandler struct {
+	calls []string
+	fail  string // message ID to fail on
+}
+
+func (h *recordingWebhookHandler) record(call string, id string) error {
+	h.calls = append(h.calls, call)
+	if id == h.fail {
+		return errors.New("failed " + id)
+	}
+	return nil
+}
+
+func (h *recordingWebhookHandler) HandleText(message Message, text string) error {
+	return h.record("text "+text, message.Id)
+}
+
+func (h *recordingWebhookHandler) HandleButtonReply(message Message, id string) error {
+	return h.record("button "+id, message.Id)
+}
+
+func (h *recordingWebhookHandler) HandleReaction(message Message, reaction Reaction) error {
+	return h.record("reaction "+reaction.Emoji+" "+reaction.MessageId, message.Id)
+}
+
+func (h *recordingWebhookHandler) HandleStatus(status Status) error {
+	return h.record("status "+status.Status+" "+status.Id, status.Id)
+}
+
+func (h *recordingWebhookHandler) HandleUnsupported(message Message) error {
+	return h.record("unsupported "+message.Type, message.Id)
+}
+
+func Test_DispatchWebhook(t *testing.T) {
+	payload, err := ParseWebhook([]byte(batchedWebhookBody))
+	require.NoError(t, err)
+	require.Len(t, payload.Entry, 2)
+	require.Equal(t, "Kerry", payload.Entry[0].Changes[0].Value.Contacts[0].Profile.Name)
+	require.Equal(t, 131047, payload.Entry[1].Changes[0].Value.Statuses[1].Errors[0].Code)
+
+	sentAt, err := payload.Entry[0].Changes[0].Value.Messages[0].GetTime()
+	require.NoError(t, err)
+	require.True(t, time.Date(2022, time.July, 11, 8, 0, 0, 0, time.UTC).Equal(sentAt))
+
+	handler := &recordingWebhookHandler{fail: "wamid.2"}
+	err = DispatchWebhook(payload, handler)
+	require.EqualError(t, err, "failed wamid.2")
+	require.Equal(t, []string{
+		"text New reminder Flights: Book return flights: 1H",
+		"button Done abc",
+		"reaction \U0001F44D wamid.0",
+		"button Snooze abc: 15m",
+		"unsupported image",
+		"status delivered wamid.0",
+		"status failed wamid.9",
+	}, handler.calls)
+}
+
+func Test_ParseWebhookInvalid(t *testing.T) {
+	_, err := ParseWebhook([]byte(`{"entry": "not a list"}`))
+	require.Error(t, err)
+
+	_, err = Message{Timestamp: "yesterday"}.GetTime()
+	require.Error(t, err)
+}
@@ -2,60 +2,214 @@ package whatsapp
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"regexp"
+	"strings"
+	"time"
 )
 
-func WhatsappRequestError(resp *http.Response) error {
-	return errors.New(fmt.Sprintf("Error sending WhatsApp request. status=%s", resp.Status))
+// IWhatsappClient sends messages, returning the WhatsApp message ID that
+// delivery statuses posted to the webhook will refer to. Sends that would have
+// to wait for the rate limiter past MaxRateLimitWait, or past ctx's deadline,
+// fail with a *RateLimitedError instead.
+type IWhatsappClient interface {
+	SendMessage(ctx context.Context, toPhone string, message string) (string, error)
+	SendInteractiveMessage(ctx context.Context, toPhone string, message string, buttons []ReplyButton) (string, error)
+	// SendTemplateMessage sends a pre-approved template, which unlike other
+	// messages can be sent outside of the session window.
+	SendTemplateMessage(ctx context.Context, toPhone string, template Template) (string, error)
 }
 
-type IWhatsappClient interface {
-	SendMessage(toPhone string, message string) error
+// Template is a message template approved in WhatsApp Manager.
+type Template struct {
+	Name     string
+	Language string // e.g. "en_US"
+	// Substituted for the body's {{1}}, {{2}}, ... placeholders
+	Parameters []string
+	// Payloads of the template's quick-reply buttons, in order. WhatsApp posts
+	// a tapped button's payload back to the webhook as a button message.
+	QuickReplyPayloads []string
+}
+
+// Free-form messages may only be sent within this long of the recipient's
+// last message; after that, only templates are delivered.
+const SessionWindow = 24 * time.Hour
+
+// IsSessionOpen reports whether a free-form message can be sent at now to a
+// user whose last message was at lastInbound.
+func IsSessionOpen(lastInbound time.Time, now time.Time) bool {
+	return !lastInbound.IsZero() && now.Sub(lastInbound) < SessionWindow
+}
+
+// ReplyButton is a quick-reply button on an interactive message. When tapped,
+// WhatsApp posts the button's Id back to the webhook as a button_reply.
+type ReplyButton struct {
+	Id    string
+	Title string // at most 20 characters
 }
 
+// WhatsApp allows at most three reply buttons per message.
+const MaxReplyButtons = 3
+
 type _LiveWhatsappClient struct {
-	AuthToken string
+	Tokens    ITokenProvider
 	AccountId string
+	ApiUrl    string // defaults to GraphApiUrl
+}
+
+const GraphApiUrl = "https://graph.facebook.com"
+
+// SendMessage splits messages longer than WhatsApp allows, and returns the ID
+// of the first part.
+func (w _LiveWhatsappClient) SendMessage(ctx context.Context, toPhone string, message string) (string, error) {
+	to, err := NormalizePhone(toPhone)
+	if err != nil {
+		return "", err
+	}
+	var firstId string
+	for _, part := range splitMessage(message, MaxTextBodyLength) {
+		id, err := w.send(ctx, newMessageRequest(to, &textMessageBody{Body: part}))
+		if err != nil {
+			return firstId, err
+		}
+		if firstId == "" {
+			firstId = id
+		}
+	}
+	return firstId, nil
+}
+
+func (w _LiveWhatsappClient) SendInteractiveMessage(ctx context.Context, toPhone string, message string, buttons []ReplyButton) (string, error) {
+	if len(buttons) > MaxReplyButtons {
+		return "", errors.New(fmt.Sprintf("WhatsApp messages support at most %d reply buttons", MaxReplyButtons))
+	}
+	to, err := NormalizePhone(toPhone)
+	if err != nil {
+		return "", err
+	}
+	interactive := &interactiveMessageBody{Type: "button"}
+	interactive.Body.Text = truncate(message, MaxInteractiveBodyLength)
+	for _, button := range buttons {
+		replyButton := interactiveButton{Type: "reply"}
+		replyButton.Reply.Id = truncate(button.Id, MaxReplyButtonIdLength)
+		replyButton.Reply.Title = truncate(button.Title, MaxReplyButtonTitleLength)
+		interactive.Action.Buttons = append(interactive.Action.Buttons, replyButton)
+	}
+	return w.send(ctx, newMessageRequest(to, interactive))
+}
+
+func (w _LiveWhatsappClient) SendTemplateMessage(ctx context.Context, toPhone string, template Template) (string, error) {
+	to, err := NormalizePhone(toPhone)
+	if err != nil {
+		return "", err
+	}
+	body := &templateMessageBody{Name: template.Name, Components: []templateComponent{}}
+	body.Language.Code = template.Language
+	if len(template.Parameters) > 0 {
+		component := templateComponent{Type: "body"}
+		for _, parameter := range template.Parameters {
+			component.Parameters = append(component.Parameters, templateParameter{Type: "text", Text: makeTemplateParameter(parameter)})
+		}
+		body.Components = append(body.Components, component)
+	}
+	for i, payload := range template.QuickReplyPayloads {
+		body.Components = append(body.Components, templateComponent{
+			Type:       "button",
+			SubType:    "quick_reply",
+			Index:      fmt.Sprint(i),
+			Parameters: []templateParameter{{Type: "payload", Payload: payload}},
+		})
+	}
+	return w.send(ctx, newMessageRequest(to, body))
+}
+
+// Template parameters can't contain newlines, tabs or runs of spaces.
+var templateParameterSpace = regexp.MustCompile(`\s+`)
+
+func makeTemplateParameter(text string) string {
+	return truncate(templateParameterSpace.ReplaceAllString(strings.TrimSpace(text), " "), MaxTemplateParameterLength)
 }
 
-func (w _LiveWhatsappClient) SendMessage(toPhone string, message string) error {
-	url := fmt.Sprintf("https://graph.facebook.com/v13.0/%s/messages", w.AccountId)
-	auth := fmt.Sprintf("Bearer %s", w.AuthToken)
+func (w _LiveWhatsappClient) send(ctx context.Context, request messageRequest) (string, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	log.Println("Sending WhatsApp", request.Type, "message. data:", string(data))
+	return w.post(ctx, data)
+}
 
-	data := fmt.Sprintf(`{
-		"messaging_product": "whatsapp",
-  		"recipient_type": "individual",
-  		"to": "%s",
-  		"type": "text",
-  		"text": {
-			"body": "%s"
+// post sends a message and returns its ID. If the token has been revoked or
+// has expired, it is refreshed and the message sent once more.
+func (w _LiveWhatsappClient) post(ctx context.Context, query []byte) (string, error) {
+	token, err := w.Tokens.GetToken()
+	if err != nil {
+		return "", err
+	}
+	resp, body, err := w.postWithToken(ctx, query, token)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		log.Println("WhatsApp token rejected; refreshing it")
+		w.Tokens.Invalidate(token)
+		if token, err = w.Tokens.GetToken(); err != nil {
+			return "", err
 		}
-	}`, toPhone, message)
-	log.Println("Sending WhatsApp reminder. url:", url, "data:", data)
+		resp, body, err = w.postWithToken(ctx, query, token)
+	}
+	if err != nil {
+		log.Println("Panicked sending WhatsApp request")
+		return "", err
+	}
+	if resp.StatusCode >= 400 {
+		log.Println("Error sending WhatsApp request")
+		return "", WhatsappRequestError(resp, body)
+	}
+	var sent sendMessageResponse
+	if err := json.Unmarshal(body, &sent); err != nil || len(sent.Messages) == 0 {
+		log.Println("WhatsApp response has no message ID", err)
+		return "", nil
+	}
+	return sent.Messages[0].Id, nil
+}
+
+func (w _LiveWhatsappClient) postWithToken(ctx context.Context, query []byte, token string) (*http.Response, []byte, error) {
+	apiUrl := w.ApiUrl
+	if apiUrl == "" {
+		apiUrl = GraphApiUrl
+	}
+	url := fmt.Sprintf("%s/v13.0/%s/messages", apiUrl, w.AccountId)
+	auth := fmt.Sprintf("Bearer %s", token)
+	if err := waitToSend(ctx, w.AccountId); err != nil {
+		return nil, nil, err
+	}
 
-	var query = []byte(data)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(query))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(query))
+	if err != nil {
+		return nil, nil, err
+	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", auth)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Println("Panicked sending WhatsApp request")
-		return err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 	body, _ := ioutil.ReadAll(resp.Body)
 	log.Println("WhatsApp response received. status:", resp.Status, "headers:", resp.Header, "body:", string(body))
-	if resp.StatusCode >= 400 {
-		log.Println("Error sending WhatsApp request")
-		return WhatsappRequestError(resp)
-	}
-	return nil
+	return resp, body, nil
+}
+
+type sendMessageResponse struct {
+	Messages []struct {
+		Id string `json:"id"`
+	} `json:"messages"`
 }
 
 type _MockWhatsappClient struct {
@@ -63,6 +217,18 @@ type _MockWhatsappClient struct {
 	AccountId string
 }
 
-func (f _MockWhatsappClient) SendMessage(toPhone string, message string) error {
-	return nil
+func (f _MockWhatsappClient) SendMessage(ctx context.Context, toPhone string, message string) (string, error) {
+	return makeMockMessageId(), nil
+}
+
+func (f _MockWhatsappClient) SendInteractiveMessage(ctx context.Context, toPhone string, message string, buttons []ReplyButton) (string, error) {
+	return makeMockMessageId(), nil
+}
+
+func (f _MockWhatsappClient) SendTemplateMessage(ctx context.Context, toPhone string, template Template) (string, error) {
+	return makeMockMessageId(), nil
+}
+
+func makeMockMessageId() string {
+	return fmt.Sprintf("wamid.mock.%d", time.Now().UnixNano())
 }
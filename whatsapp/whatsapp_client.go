@@ -11,8 +11,8 @@ import (
 func GetWhatsappClient() IWhatsappClient {
 	if slices.Contains([]string{"PROD", "DEV"}, app.ENV) {
 		return _LiveWhatsappClient{
-			app.WhatsappToken,
-			app.WhatsappAccountId,
+			Tokens:    GetTokenProvider(),
+			AccountId: app.WhatsappAccountId,
 		}
 	} else {
 		return _MockWhatsappClient{"", ""}
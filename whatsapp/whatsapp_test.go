@@ -0,0 +1,19 @@
+package whatsapp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_IsSessionOpen(t *testing.T) {
+	lastInbound := time.Date(2022, time.July, 11, 9, 0, 0, 0, time.UTC)
+	require.False(t, IsSessionOpen(time.Time{}, lastInbound))
+	require.True(t, IsSessionOpen(lastInbound, lastInbound.Add(23*time.Hour)))
+	require.False(t, IsSessionOpen(lastInbound, lastInbound.Add(24*time.Hour)))
+}
+
+func Test_MakeTemplateParameter(t *testing.T) {
+	require.Equal(t, "Book return flights from Jakarta", makeTemplateParameter(" Book return\n\tflights     from Jakarta\n"))
+}
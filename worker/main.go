@@ -8,13 +8,18 @@ import (
 
 	"reminders/app"
 	"reminders/app/activities"
+	"reminders/app/codec"
 	"reminders/app/workflows"
 )
 
 // @@@SNIPSTART reminders-worker
 func main() {
 	// Create the client object just once per process
-	c, err := client.NewClient(client.Options{})
+	clientOptions, err := codec.ClientOptions()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	c, err := client.NewClient(clientOptions)
 	if err != nil {
 		log.Fatalln("unable to create Temporal client", err)
 	}
@@ -23,8 +28,14 @@ func main() {
 	w := worker.New(c, app.ReminderTaskQueueName, worker.Options{})
 	w.RegisterWorkflow(workflows.MakeReminderWorkflow)
 	w.RegisterActivity(activities.Create)
+	w.RegisterActivity(activities.Update)
 	w.RegisterActivity(activities.Delete)
+	w.RegisterActivity(activities.Acknowledge)
 	w.RegisterActivity(activities.SendReminder)
+	w.RegisterActivity(activities.RecordDelivery)
+	w.RegisterActivity(activities.Escalate)
+	w.RegisterActivity(activities.Notify)
+	w.RegisterActivity(activities.PublishEvent)
 	// Start listening to the Task Queue
 	err = w.Run(worker.InterruptCh())
 	if err != nil {
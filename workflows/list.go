@@ -0,0 +1,160 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"reminders/app"
+	"reminders/app/codec"
+	"reminders/app/utils"
+
+	commonpb "go.temporal.io/api/common/v1"
+	enums "go.temporal.io/api/enums/v1"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+)
+
+const DefaultListPageSize = 20
+const MaxListPageSize = 100
+
+var listSortOrders = map[string]string{
+	"reminderTime":  fmt.Sprintf("%s ASC", app.ReminderTimeSearchAttribute),
+	"-reminderTime": fmt.Sprintf("%s DESC", app.ReminderTimeSearchAttribute),
+	"startTime":     "StartTime ASC",
+	"-startTime":    "StartTime DESC",
+}
+
+// Statuses reported for workflows that did not complete normally, which the
+// workflow itself has no chance to record.
+var executionStatuses = map[enums.WorkflowExecutionStatus]string{
+	enums.WORKFLOW_EXECUTION_STATUS_CANCELED:   utils.ReminderStatusCancelled,
+	enums.WORKFLOW_EXECUTION_STATUS_TERMINATED: utils.ReminderStatusFailed,
+	enums.WORKFLOW_EXECUTION_STATUS_FAILED:     utils.ReminderStatusFailed,
+	enums.WORKFLOW_EXECUTION_STATUS_TIMED_OUT:  utils.ReminderStatusFailed,
+}
+
+func ListQueryError(reason string) error {
+	return errors.New(fmt.Sprintf("Invalid reminder list request: %s", reason))
+}
+
+// ListWorkflows returns the reminders started by StartWorkflow that match the
+// filter, along with a token for the next page (nil on the last page).
+func ListWorkflows(c client.Client, filter utils.ReminderListFilter) ([]utils.ReminderDetails, []byte, error) {
+	query, err := makeListQuery(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	pageSize := filter.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultListPageSize
+	}
+	if pageSize > MaxListPageSize {
+		pageSize = MaxListPageSize
+	}
+	return listWorkflows(c, query, pageSize, filter.NextPageToken)
+}
+
+func makeListQuery(filter utils.ReminderListFilter) (string, error) {
+	// Runs that continued as new are superseded by the reminder's latest run
+	conditions := []string{"WorkflowType = 'MakeReminderWorkflow'", "ExecutionStatus != 'ContinuedAsNew'"}
+	if filter.Phone != "" {
+		conditions = append(conditions, fmt.Sprintf("%s = %s", app.ReminderPhoneSearchAttribute, quoteQueryValue(filter.Phone)))
+	}
+	switch filter.Status {
+	case "":
+	case utils.ReminderStatusCancelled:
+		conditions = append(conditions, fmt.Sprintf(
+			"(ExecutionStatus = 'Canceled' OR %s = '%s')", app.ReminderStatusSearchAttribute, utils.ReminderStatusCancelled))
+	case utils.ReminderStatusFailed:
+		conditions = append(conditions, "ExecutionStatus IN ('Failed', 'Terminated', 'TimedOut')")
+	case utils.ReminderStatusPending, utils.ReminderStatusFired, utils.ReminderStatusAcknowledged:
+		conditions = append(conditions, fmt.Sprintf(
+			"ExecutionStatus IN ('Running', 'Completed') AND %s = '%s'", app.ReminderStatusSearchAttribute, filter.Status))
+	default:
+		return "", ListQueryError(fmt.Sprintf("unrecognized status %s", filter.Status))
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("%s >= '%s'", app.ReminderTimeSearchAttribute, filter.From.UTC().Format(time.RFC3339)))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("%s <= '%s'", app.ReminderTimeSearchAttribute, filter.To.UTC().Format(time.RFC3339)))
+	}
+	query := strings.Join(conditions, " AND ")
+	if filter.Sort != "" {
+		order, ok := listSortOrders[filter.Sort]
+		if !ok {
+			return "", ListQueryError(fmt.Sprintf("unrecognized sort %s", filter.Sort))
+		}
+		query = fmt.Sprintf("%s ORDER BY %s", query, order)
+	}
+	return query, nil
+}
+
+func listWorkflows(c client.Client, query string, pageSize int, nextPageToken []byte) ([]utils.ReminderDetails, []byte, error) {
+	log.Println("Listing reminders. query:", query)
+	resp, err := c.ListWorkflow(context.Background(), &workflowservice.ListWorkflowExecutionsRequest{
+		Namespace:     client.DefaultNamespace,
+		PageSize:      int32(pageSize),
+		NextPageToken: nextPageToken,
+		Query:         query,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	memoConverter, err := codec.DataConverter()
+	if err != nil {
+		return nil, nil, err
+	}
+	reminders := []utils.ReminderDetails{}
+	for _, execution := range resp.Executions {
+		reminders = append(reminders, makeReminderDetailsFromExecution(execution, memoConverter))
+	}
+	return reminders, resp.NextPageToken, nil
+}
+
+// makeReminderDetailsFromExecution reads a listed reminder. Memos are written
+// with the client's data converter, so may be encrypted, and are decoded with
+// memoConverter.
+func makeReminderDetailsFromExecution(execution *workflowpb.WorkflowExecutionInfo, memoConverter converter.DataConverter) utils.ReminderDetails {
+	reminderDetails := utils.ReminderDetails{
+		WorkflowId: execution.Execution.GetWorkflowId(),
+		RunId:      execution.Execution.GetRunId(),
+	}
+	reminderDetails.ReferenceId, _ = utils.MakeReferenceId(reminderDetails.WorkflowId)
+	if execution.StartTime != nil {
+		reminderDetails.FromTime = *execution.StartTime
+	}
+	searchAttributes := execution.GetSearchAttributes().GetIndexedFields()
+	defaultConverter := converter.GetDefaultDataConverter()
+	decodePayload(defaultConverter, searchAttributes[app.ReminderPhoneSearchAttribute], &reminderDetails.Phone)
+	decodePayload(defaultConverter, searchAttributes[app.ReminderStatusSearchAttribute], &reminderDetails.Status)
+	decodePayload(defaultConverter, searchAttributes[app.ReminderTimeSearchAttribute], &reminderDetails.ReminderTime)
+	memo := execution.GetMemo().GetFields()
+	decodePayload(memoConverter, memo["ReminderName"], &reminderDetails.ReminderName)
+	decodePayload(memoConverter, memo["ReminderText"], &reminderDetails.ReminderText)
+	decodePayload(memoConverter, memo["Recurrence"], &reminderDetails.Recurrence)
+	if status, ok := executionStatuses[execution.GetStatus()]; ok {
+		reminderDetails.Status = status
+	}
+	return reminderDetails
+}
+
+func decodePayload(dataConverter converter.DataConverter, payload *commonpb.Payload, valuePtr interface{}) {
+	if payload == nil {
+		return
+	}
+	if err := dataConverter.FromPayload(payload, valuePtr); err != nil {
+		log.Println("Unable to decode payload", err)
+	}
+}
+
+// quoteQueryValue quotes a user-supplied value for use in a visibility query.
+func quoteQueryValue(value string) string {
+	return fmt.Sprintf("'%s'", strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value))
+}
@@ -2,41 +2,84 @@ package workflows
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"reminders/app"
 	"reminders/app/utils"
 	"reminders/app/whatsapp"
 	"time"
 
+	"github.com/google/uuid"
 	enums "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	workflowpb "go.temporal.io/api/workflow/v1"
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/workflow"
 	"golang.org/x/exp/slices"
 )
 
 func StartWorkflow(c client.Client, input *utils.ReminderInput) (utils.ReminderDetails, error) {
+	if err := input.Validate(); err != nil {
+		return utils.ReminderDetails{}, err
+	}
+	reminderTime := input.GetReminderTime()
+	workflowId := utils.MakeWorkflowId(input.Phone, input.IdempotencyKey)
 	options := client.StartWorkflowOptions{
-		ID:        "reminder-workflow",
+		ID:        workflowId,
 		TaskQueue: app.ReminderTaskQueueName,
+		// Workflow IDs are never reused, so that a retried request can find
+		// the reminder it created, even after that reminder has completed.
+		WorkflowIDReusePolicy:                    enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE,
+		WorkflowExecutionErrorWhenAlreadyStarted: true,
+		SearchAttributes: map[string]interface{}{
+			app.ReminderPhoneSearchAttribute:  input.Phone,
+			app.ReminderStatusSearchAttribute: utils.ReminderStatusPending,
+			app.ReminderTimeSearchAttribute:   reminderTime,
+			// Search attributes carry over when the reminder continues as new
+			app.ReminderShortCodeSearchAttribute: utils.MakeShortCode(workflowId),
+		},
+		Memo: map[string]interface{}{
+			"ReminderName": input.ReminderName,
+			"ReminderText": input.ReminderText,
+			"Recurrence":   input.Recurrence,
+		},
 	}
-	remindInMinutes := time.Minute * time.Duration(input.NMinutes)
+	remindInMinutes := reminderTime.Sub(input.FromTime)
 	reminderDetails := utils.ReminderDetails{
 		FromTime:     input.FromTime,
 		NMinutes:     remindInMinutes,
 		Phone:        input.Phone,
-		ReminderTime: input.FromTime.Add(remindInMinutes),
+		ReminderTime: reminderTime,
 		ReminderText: input.ReminderText,
 		ReminderName: input.ReminderName,
+		AckWindow:    app.ReminderAckWindow,
+		Escalation:   input.Escalation,
+		Channels:     input.Channels,
+	}
+	if input.Recurrence != "" {
+		reminderDetails.Recurrence = input.Recurrence
+		reminderDetails.RecurrenceStart = reminderTime
+		reminderDetails.TimeZone = input.TimeZone
+		if reminderDetails.TimeZone == "" {
+			reminderDetails.TimeZone = reminderTime.Location().String()
+		}
 	}
 	log.Println("Starting workflow to remind", input.Phone, "in", remindInMinutes, "minutes, at", reminderDetails.GetReminderTime().Format(app.TIME_FORMAT))
 	we, err := c.ExecuteWorkflow(context.Background(), options, MakeReminderWorkflow, reminderDetails)
+	var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+	if errors.As(err, &alreadyStarted) {
+		log.Println("Reminder already created for idempotency key", input.IdempotencyKey, "workflowId", options.ID, "runId", alreadyStarted.RunId)
+		return getExistingWorkflow(c, options.ID, alreadyStarted.RunId)
+	}
 	if err != nil {
-		log.Fatalln("error starting Reminder workflow", err)
+		log.Println("error starting Reminder workflow", err)
+		return reminderDetails, err
 	}
 	workflowId, runId := we.GetID(), we.GetRunID()
 	reminderDetails.RunId = runId
 	reminderDetails.WorkflowId = workflowId
-	referenceId, err := utils.MakeReferenceId(workflowId, runId)
+	referenceId, err := utils.MakeReferenceId(workflowId)
 	if err != nil {
 		return reminderDetails, err
 	}
@@ -44,27 +87,202 @@ func StartWorkflow(c client.Client, input *utils.ReminderInput) (utils.ReminderD
 	return reminderDetails, err
 }
 
+// getExistingWorkflow returns the reminder an earlier request with the same
+// idempotency key created.
+func getExistingWorkflow(c client.Client, workflowId string, runId string) (utils.ReminderDetails, error) {
+	reminderDetails, err := GetWorkflow(c, workflowId, runId)
+	if err != nil {
+		log.Println("Unable to query existing reminder", workflowId, runId, err)
+		reminderDetails = utils.ReminderDetails{}
+	}
+	reminderDetails.WorkflowId = workflowId
+	reminderDetails.RunId = runId
+	reminderDetails.ReferenceId, err = utils.MakeReferenceId(workflowId)
+	return reminderDetails, err
+}
+
+// How long UpdateWorkflow waits for the workflow to apply an update.
+const UpdateResultTimeout = 10 * time.Second
+const UpdateResultPollInterval = 100 * time.Millisecond
+
+// UpdateRejectedError is returned when a reminder refuses an update, e.g.
+// because it has already fired.
+type UpdateRejectedError struct {
+	Reason string
+}
+
+func (e *UpdateRejectedError) Error() string {
+	return e.Reason
+}
+
+// UpdateWorkflow signals the update to the reminder, then waits until the
+// workflow has applied or rejected it and returns the resulting details. An
+// update retried with the same idempotency key returns the original result.
 func UpdateWorkflow(c client.Client, workflowId string, runId string, input *utils.ReminderInput) (utils.ReminderDetails, error) {
+	if err := input.Validate(); err != nil {
+		return utils.ReminderDetails{}, err
+	}
+	ctx := context.Background()
+	var reminderDetails utils.ReminderDetails
+	execution, done, err := describeWorkflow(c, ctx, workflowId, runId)
+	if err != nil {
+		return reminderDetails, err
+	}
+	requestId := uuid.NewString()
+	if input.IdempotencyKey != "" {
+		requestId = input.IdempotencyKey
+		result, err := getUpdateResult(c, ctx, workflowId, execution.Execution.GetRunId(), requestId)
+		if err != nil {
+			return reminderDetails, err
+		}
+		if result.Done {
+			log.Println("Returning the result of update", requestId, "to a retried request")
+			return getUpdateResultDetails(result)
+		}
+	}
+	if done {
+		if execution.Status == enums.WORKFLOW_EXECUTION_STATUS_COMPLETED {
+			return reminderDetails, &UpdateRejectedError{"Reminder has already fired."}
+		}
+		return reminderDetails, &UpdateRejectedError{"Reminder has been cancelled."}
+	}
+
 	signal := utils.UpdateReminderSignal{
+		RequestId:    requestId,
 		Phone:        input.Phone,
 		NMinutes:     input.NMinutes,
+		ReminderTime: input.ReminderTime,
 		ReminderName: input.ReminderName,
 		ReminderText: input.ReminderText,
+		Escalation:   input.Escalation,
+		Channels:     input.Channels,
 	}
-	ctx := context.Background()
-	var reminderDetails utils.ReminderDetails
-	err := c.SignalWorkflow(ctx, workflowId, runId, app.UpdateReminderSignalChannelName, signal)
+	err = c.SignalWorkflow(ctx, workflowId, runId, app.UpdateReminderSignalChannelName, signal)
 	if err != nil {
-		log.Fatalln("Error sending the UpdateReminder Signal", err)
+		log.Println("Error sending the UpdateReminder Signal", err)
 		return reminderDetails, err
 	}
-	return reminderDetails, err
+
+	// The run that received the signal may continue as new before the result
+	// is read, so check both it and the workflow's latest run.
+	signalledRunId := execution.Execution.GetRunId()
+	deadline := time.Now().Add(UpdateResultTimeout)
+	for {
+		result, err := getUpdateResult(c, ctx, workflowId, runId, signal.RequestId)
+		if err != nil {
+			return reminderDetails, err
+		}
+		if !result.Done && signalledRunId != "" && signalledRunId != runId {
+			result, err = getUpdateResult(c, ctx, workflowId, signalledRunId, signal.RequestId)
+			if err != nil {
+				return reminderDetails, err
+			}
+		}
+		if result.Done {
+			return getUpdateResultDetails(result)
+		}
+		if time.Now().After(deadline) {
+			return reminderDetails, errors.New(fmt.Sprintf("Timed out waiting for reminder %s to apply update", workflowId))
+		}
+		time.Sleep(UpdateResultPollInterval)
+	}
+}
+
+func getUpdateResultDetails(result utils.UpdateReminderResult) (utils.ReminderDetails, error) {
+	if result.Error != "" {
+		return result.ReminderDetails, &UpdateRejectedError{result.Error}
+	}
+	return result.ReminderDetails, nil
+}
+
+func getUpdateResult(c client.Client, ctx context.Context, workflowId string, runId string, requestId string) (utils.UpdateReminderResult, error) {
+	var result utils.UpdateReminderResult
+	value, err := c.QueryWorkflow(ctx, workflowId, runId, "getUpdateResult", requestId)
+	if err != nil {
+		return result, err
+	}
+	err = value.Get(&result)
+	return result, err
+}
+
+// SignalReminderAction snoozes or dismisses a reminder on behalf of its recipient.
+func SignalReminderAction(c client.Client, workflowId string, runId string, action utils.ReminderActionSignal) error {
+	err := c.SignalWorkflow(context.Background(), workflowId, runId, app.ReminderActionSignalChannelName, action)
+	if err != nil {
+		log.Println("Error sending the ReminderAction Signal", err)
+	}
+	return err
+}
+
+// FindFiredWorkflow returns the reminder for phone that fired most recently
+// and is still waiting for a reply.
+func FindFiredWorkflow(c client.Client, phone string) (string, string, error) {
+	query := fmt.Sprintf(
+		"WorkflowType = 'MakeReminderWorkflow' AND ExecutionStatus = 'Running' AND %s = %s AND %s = '%s' ORDER BY %s DESC",
+		app.ReminderPhoneSearchAttribute, quoteQueryValue(phone),
+		app.ReminderStatusSearchAttribute, utils.ReminderStatusFired,
+		app.ReminderTimeSearchAttribute,
+	)
+	reminders, _, err := listWorkflows(c, query, 1, nil)
+	if err != nil {
+		return "", "", err
+	}
+	if len(reminders) == 0 {
+		return "", "", errors.New(fmt.Sprintf("No fired reminder awaiting a reply for %s", phone))
+	}
+	return reminders[0].WorkflowId, reminders[0].RunId, nil
+}
+
+// FindWorkflowByShortCode returns the phone's running reminder with the code.
+// Codes can collide, in which case the full reference ID is needed.
+func FindWorkflowByShortCode(c client.Client, phone string, shortCode string) (string, string, error) {
+	query := fmt.Sprintf(
+		"WorkflowType = 'MakeReminderWorkflow' AND ExecutionStatus = 'Running' AND %s = %s AND %s = %s",
+		app.ReminderPhoneSearchAttribute, quoteQueryValue(phone),
+		app.ReminderShortCodeSearchAttribute, quoteQueryValue(shortCode),
+	)
+	reminders, _, err := listWorkflows(c, query, 2, nil)
+	if err != nil {
+		return "", "", err
+	}
+	if len(reminders) == 0 {
+		return "", "", errors.New(fmt.Sprintf("No reminder with code %s for %s", shortCode, phone))
+	}
+	if len(reminders) > 1 {
+		return "", "", errors.New(fmt.Sprintf("More than one reminder has code %s; please use its Reference ID", shortCode))
+	}
+	return reminders[0].WorkflowId, "", nil
+}
+
+// SignalDeliveryStatus relays a WhatsApp status webhook to the running
+// reminder that sent the message.
+func SignalDeliveryStatus(c client.Client, deliveryStatus utils.DeliveryStatusSignal) error {
+	query := fmt.Sprintf(
+		"WorkflowType = 'MakeReminderWorkflow' AND ExecutionStatus = 'Running' AND %s = %s",
+		app.ReminderMessageIdSearchAttribute, quoteQueryValue(deliveryStatus.MessageId),
+	)
+	reminders, _, err := listWorkflows(c, query, 1, nil)
+	if err != nil {
+		return err
+	}
+	if len(reminders) == 0 {
+		return errors.New(fmt.Sprintf("No running reminder sent message %s", deliveryStatus.MessageId))
+	}
+	err = c.SignalWorkflow(context.Background(), reminders[0].WorkflowId, "", app.DeliveryStatusSignalChannelName, deliveryStatus)
+	if err != nil {
+		log.Println("Error sending the DeliveryStatus Signal", err)
+	}
+	return err
 }
 
 func updateReminderDetails(ctx workflow.Context, reminderUpdate *utils.UpdateReminderSignal, reminderDetails *utils.ReminderDetails) *utils.ReminderDetails {
-	newReminderTime := time.Duration(reminderUpdate.NMinutes) * time.Minute
-	reminderDetails.NMinutes = newReminderTime
-	reminderDetails.ReminderTime = utils.GetReminderTime(workflow.Now(ctx), newReminderTime)
+	if !reminderUpdate.ReminderTime.IsZero() {
+		reminderDetails.ReminderTime = reminderUpdate.ReminderTime
+	} else {
+		newReminderTime := time.Duration(reminderUpdate.NMinutes) * time.Minute
+		reminderDetails.ReminderTime = utils.GetReminderTime(workflow.Now(ctx), newReminderTime)
+	}
+	reminderDetails.NMinutes = reminderDetails.ReminderTime.Sub(reminderDetails.FromTime)
 	if reminderUpdate.Phone != "" {
 		reminderDetails.Phone = reminderUpdate.Phone
 	}
@@ -74,6 +292,12 @@ func updateReminderDetails(ctx workflow.Context, reminderUpdate *utils.UpdateRem
 	if reminderUpdate.ReminderName != "" {
 		reminderDetails.ReminderName = reminderUpdate.ReminderName
 	}
+	if reminderUpdate.Escalation != nil {
+		reminderDetails.Escalation = reminderUpdate.Escalation
+	}
+	if reminderUpdate.Channels != nil {
+		reminderDetails.Channels = reminderUpdate.Channels
+	}
 	return reminderDetails
 }
 
@@ -85,15 +309,22 @@ var WorkflowStatusDone = []enums.WorkflowExecutionStatus{
 }
 
 func workflowStatusIsDone(c client.Client, ctx context.Context, workflowId string, runId string) (enums.WorkflowExecutionStatus, bool, error) {
-	status, err := c.DescribeWorkflowExecution(ctx, workflowId, runId)
+	execution, done, err := describeWorkflow(c, ctx, workflowId, runId)
 	if err != nil {
 		return 0, false, err
 	}
-	if slices.Contains(WorkflowStatusDone, status.WorkflowExecutionInfo.Status) {
-		return status.WorkflowExecutionInfo.Status, true, nil
-	} else {
-		return status.WorkflowExecutionInfo.Status, false, nil
+	return execution.Status, done, nil
+}
+
+// describeWorkflow returns the execution info of a run, or of the latest run
+// when runId is empty.
+func describeWorkflow(c client.Client, ctx context.Context, workflowId string, runId string) (*workflowpb.WorkflowExecutionInfo, bool, error) {
+	status, err := c.DescribeWorkflowExecution(ctx, workflowId, runId)
+	if err != nil {
+		return nil, false, err
 	}
+	execution := status.WorkflowExecutionInfo
+	return execution, slices.Contains(WorkflowStatusDone, execution.Status), nil
 }
 
 func getPhone(c client.Client, ctx context.Context, workflowId string, runId string) (string, error) {
@@ -107,6 +338,33 @@ func getPhone(c client.Client, ctx context.Context, workflowId string, runId str
 	return result, err
 }
 
+// GetWorkflow returns the current state of a reminder. Unknown workflows
+// return a *serviceerror.NotFound error.
+func GetWorkflow(c client.Client, workflowId string, runId string) (utils.ReminderDetails, error) {
+	var reminderDetails utils.ReminderDetails
+	value, err := c.QueryWorkflow(context.Background(), workflowId, runId, "getReminderDetails")
+	if err != nil {
+		return reminderDetails, err
+	}
+	err = value.Get(&reminderDetails)
+	return reminderDetails, err
+}
+
+func getStatus(c client.Client, ctx context.Context, workflowId string, runId string) (string, error) {
+	value, err := c.QueryWorkflow(ctx, workflowId, runId, "getStatus")
+	if err != nil {
+		return "", err
+	}
+	var result string
+	err = value.Get(&result)
+	return result, err
+}
+
+// GetPhone returns the phone number a reminder is sent to.
+func GetPhone(c client.Client, workflowId string, runId string) (string, error) {
+	return getPhone(c, context.Background(), workflowId, runId)
+}
+
 func DeleteWorkflow(c client.Client, wc whatsapp.IWhatsappClient, workflowId string, runId string) error {
 	ctx := context.Background()
 
@@ -124,7 +382,7 @@ func DeleteWorkflow(c client.Client, wc whatsapp.IWhatsappClient, workflowId str
 
 	if done == true {
 		log.Println("Workflow", workflowId, runId, "already complete with status", status)
-		wc.SendMessage(phone, "")
+		wc.SendMessage(ctx, phone, "")
 		return nil
 	}
 
@@ -137,7 +395,7 @@ func printResults(reminderDetails utils.ReminderDetails, workflowId, runId strin
 		"\nCreating reminder for %s (%s) at %s. workflowId=%s runID=%s\n",
 		reminderDetails.ReminderName,
 		reminderDetails.ReminderText,
-		utils.GetReminderTime(reminderDetails.FromTime, reminderDetails.NMinutes),
+		reminderDetails.GetReminderTime(),
 		workflowId,
 		runId,
 	)
@@ -1,15 +1,31 @@
 package workflows
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"reminders/app"
 	"reminders/app/activities"
+	"reminders/app/codec"
 	"reminders/app/utils"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
 )
 
+func init() {
+	// Reference IDs are signed with the development key
+	app.ENV = "TEST"
+}
+
 func Test_Workflow(t *testing.T) {
 	testSuite := &testsuite.WorkflowTestSuite{}
 	env := testSuite.NewTestWorkflowEnvironment()
@@ -18,9 +34,568 @@ func Test_Workflow(t *testing.T) {
 		ReminderText: "Book return flights from Jakarta",
 		ReminderName: "Flights",
 	}
-	env.OnActivity(activities.Create, mock.Anything, testDetails).Return(nil)
+	// The workflow records its own identifiers before creating the reminder
+	createdDetails := testDetails
+	createdDetails.WorkflowId = "default-test-workflow-id"
+	createdDetails.RunId = "default-test-run-id"
+	createdDetails.ReferenceId, _ = utils.MakeReferenceId(createdDetails.WorkflowId)
+	env.OnActivity(activities.Create, mock.Anything, createdDetails).Return(nil)
 	env.OnActivity(activities.Delete, mock.Anything, testDetails).Return(nil)
 	env.ExecuteWorkflow(MakeReminderWorkflow, testDetails)
 	require.True(t, env.IsWorkflowCompleted())
 	require.NoError(t, env.GetWorkflowError())
 }
+
+func Test_RecurringWorkflow(t *testing.T) {
+	testSuite := &testsuite.WorkflowTestSuite{}
+	env := testSuite.NewTestWorkflowEnvironment()
+	startTime := time.Date(2022, time.July, 11, 8, 0, 0, 0, time.UTC)
+	reminderTime := startTime.Add(time.Hour)
+	env.SetStartTime(startTime)
+	testDetails := utils.ReminderDetails{
+		FromTime:        startTime,
+		ReminderTime:    reminderTime,
+		ReminderText:    "Stand-up",
+		ReminderName:    "Work",
+		Recurrence:      "FREQ=DAILY;COUNT=3",
+		RecurrenceStart: reminderTime,
+		TimeZone:        "UTC",
+	}
+	var sentAt []time.Time
+	env.OnActivity(activities.Create, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(activities.SendReminder, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, reminderDetails utils.ReminderDetails) (string, error) {
+			sentAt = append(sentAt, reminderDetails.ReminderTime)
+			return fmt.Sprintf("wamid.%d", len(sentAt)), nil
+		}).Times(3)
+	env.ExecuteWorkflow(MakeReminderWorkflow, testDetails)
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	require.Equal(t, []time.Time{reminderTime, reminderTime.AddDate(0, 0, 1), reminderTime.AddDate(0, 0, 2)}, sentAt)
+	env.AssertExpectations(t)
+}
+
+func Test_SnoozeAndDismissWorkflow(t *testing.T) {
+	testSuite := &testsuite.WorkflowTestSuite{}
+	env := testSuite.NewTestWorkflowEnvironment()
+	startTime := time.Date(2022, time.July, 11, 8, 0, 0, 0, time.UTC)
+	reminderTime := startTime.Add(time.Hour)
+	env.SetStartTime(startTime)
+	testDetails := utils.ReminderDetails{
+		FromTime:     startTime,
+		ReminderTime: reminderTime,
+		ReminderText: "Take medication",
+		ReminderName: "Health",
+		AckWindow:    30 * time.Minute,
+	}
+	var sentAt []time.Time
+	env.OnActivity(activities.Create, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(activities.SendReminder, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, reminderDetails utils.ReminderDetails) (string, error) {
+			sentAt = append(sentAt, reminderDetails.ReminderTime)
+			return fmt.Sprintf("wamid.%d", len(sentAt)), nil
+		}).Times(2)
+	env.OnActivity(activities.Acknowledge, mock.Anything, mock.MatchedBy(func(reminderDetails utils.ReminderDetails) bool {
+		return reminderDetails.Status == utils.ReminderStatusAcknowledged
+	})).Return(nil).Once()
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(app.ReminderActionSignalChannelName, utils.ReminderActionSignal{Action: utils.ReminderActionSnooze, SnoozeFor: 10 * time.Minute})
+	}, time.Hour+5*time.Minute)
+	env.RegisterDelayedCallback(func() {
+		res, err := env.QueryWorkflow("getStatus")
+		require.NoError(t, err)
+		var status string
+		require.NoError(t, res.Get(&status))
+		require.Equal(t, utils.ReminderStatusFired, status)
+		res, err = env.QueryWorkflow("getReminderDetails")
+		require.NoError(t, err)
+		var details utils.ReminderDetails
+		require.NoError(t, res.Get(&details))
+		require.Equal(t, []utils.SnoozeRecord{{
+			SnoozedAt:    reminderTime.Add(5 * time.Minute),
+			SnoozedUntil: reminderTime.Add(15 * time.Minute),
+		}}, details.SnoozeHistory)
+		env.SignalWorkflow(app.ReminderActionSignalChannelName, utils.ReminderActionSignal{Action: utils.ReminderActionDone})
+	}, time.Hour+20*time.Minute)
+	env.ExecuteWorkflow(MakeReminderWorkflow, testDetails)
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	require.Equal(t, []time.Time{reminderTime, reminderTime.Add(15 * time.Minute)}, sentAt)
+	env.AssertExpectations(t)
+}
+
+func Test_DismissPendingWorkflow(t *testing.T) {
+	testSuite := &testsuite.WorkflowTestSuite{}
+	env := testSuite.NewTestWorkflowEnvironment()
+	startTime := time.Date(2022, time.July, 11, 8, 0, 0, 0, time.UTC)
+	env.SetStartTime(startTime)
+	testDetails := utils.ReminderDetails{
+		FromTime:     startTime,
+		ReminderTime: startTime.Add(time.Hour),
+		ReminderText: "Take medication",
+		ReminderName: "Health",
+	}
+	env.OnActivity(activities.Create, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(activities.Delete, mock.Anything, mock.Anything).Return(nil).Once()
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(app.ReminderActionSignalChannelName, utils.ReminderActionSignal{Action: utils.ReminderActionDismiss})
+	}, time.Minute)
+	env.ExecuteWorkflow(MakeReminderWorkflow, testDetails)
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	env.AssertExpectations(t)
+}
+
+func Test_MakeListQuery(t *testing.T) {
+	query, err := makeListQuery(utils.ReminderListFilter{
+		Phone:  "16505551111",
+		Status: utils.ReminderStatusPending,
+		From:   time.Date(2022, time.July, 11, 8, 0, 0, 0, time.UTC),
+		Sort:   "-reminderTime",
+	})
+	require.NoError(t, err)
+	require.Equal(t,
+		"WorkflowType = 'MakeReminderWorkflow' AND ExecutionStatus != 'ContinuedAsNew' AND ReminderPhone = '16505551111' AND "+
+			"ExecutionStatus IN ('Running', 'Completed') AND ReminderStatus = 'pending' AND "+
+			"ReminderTime >= '2022-07-11T08:00:00Z' ORDER BY ReminderTime DESC",
+		query)
+
+	query, err = makeListQuery(utils.ReminderListFilter{Phone: "' OR 'a' = 'a"})
+	require.NoError(t, err)
+	require.Equal(t, `WorkflowType = 'MakeReminderWorkflow' AND ExecutionStatus != 'ContinuedAsNew' AND ReminderPhone = '\' OR \'a\' = \'a'`, query)
+
+	_, err = makeListQuery(utils.ReminderListFilter{Status: "snoozing"})
+	require.Error(t, err)
+	_, err = makeListQuery(utils.ReminderListFilter{Sort: "name"})
+	require.Error(t, err)
+}
+
+func Test_MakeReminderDetailsFromEncryptedExecution(t *testing.T) {
+	keyring, err := codec.ParseKeyring("2022-07:a-long-enough-secret-1")
+	require.NoError(t, err)
+	payloadCodec, err := codec.NewEncryptionCodec(keyring)
+	require.NoError(t, err)
+	memoConverter := converter.NewCodecDataConverter(converter.GetDefaultDataConverter(), payloadCodec)
+	encode := func(dataConverter converter.DataConverter, value interface{}) *commonpb.Payload {
+		payload, err := dataConverter.ToPayload(value)
+		require.NoError(t, err)
+		return payload
+	}
+
+	reminderDetails := makeReminderDetailsFromExecution(&workflowpb.WorkflowExecutionInfo{
+		Execution: &commonpb.WorkflowExecution{WorkflowId: "reminder-1", RunId: "run-1"},
+		Memo: &commonpb.Memo{Fields: map[string]*commonpb.Payload{
+			"ReminderName": encode(memoConverter, "Family"),
+			"ReminderText": encode(memoConverter, "call mom"),
+		}},
+		SearchAttributes: &commonpb.SearchAttributes{IndexedFields: map[string]*commonpb.Payload{
+			app.ReminderPhoneSearchAttribute: encode(converter.GetDefaultDataConverter(), "16505551111"),
+		}},
+	}, memoConverter)
+	require.Equal(t, "Family", reminderDetails.ReminderName)
+	require.Equal(t, "call mom", reminderDetails.ReminderText)
+	require.Equal(t, "16505551111", reminderDetails.Phone)
+}
+
+func Test_UpdateWorkflowResult(t *testing.T) {
+	testSuite := &testsuite.WorkflowTestSuite{}
+	env := testSuite.NewTestWorkflowEnvironment()
+	startTime := time.Date(2022, time.July, 11, 8, 0, 0, 0, time.UTC)
+	env.SetStartTime(startTime)
+	testDetails := utils.ReminderDetails{
+		FromTime:     startTime,
+		ReminderTime: startTime.Add(time.Hour),
+		ReminderText: "Book return flights from Jakarta",
+		ReminderName: "Flights",
+		AckWindow:    30 * time.Minute,
+	}
+	env.OnActivity(activities.Create, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(activities.SendReminder, mock.Anything, mock.Anything).Return("wamid.1", nil)
+	getUpdateResult := func(requestId string) utils.UpdateReminderResult {
+		res, err := env.QueryWorkflow("getUpdateResult", requestId)
+		require.NoError(t, err)
+		var result utils.UpdateReminderResult
+		require.NoError(t, res.Get(&result))
+		return result
+	}
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(app.UpdateReminderSignalChannelName, utils.UpdateReminderSignal{
+			RequestId:    "move",
+			ReminderTime: startTime.Add(2 * time.Hour),
+			ReminderName: "Travel",
+		})
+		env.SignalWorkflow(app.UpdateReminderSignalChannelName, utils.UpdateReminderSignal{
+			RequestId:    "past",
+			ReminderTime: startTime,
+		})
+		// A retried update isn't applied again
+		env.SignalWorkflow(app.UpdateReminderSignalChannelName, utils.UpdateReminderSignal{
+			RequestId:    "move",
+			ReminderName: "Retried",
+		})
+	}, time.Minute)
+	env.RegisterDelayedCallback(func() {
+		result := getUpdateResult("move")
+		require.True(t, result.Done)
+		require.Equal(t, "", result.Error)
+		require.Equal(t, "Travel", result.ReminderDetails.ReminderName)
+		require.True(t, startTime.Add(2*time.Hour).Equal(result.ReminderDetails.ReminderTime))
+
+		result = getUpdateResult("past")
+		require.True(t, result.Done)
+		require.NotEqual(t, "", result.Error)
+
+		require.False(t, getUpdateResult("unknown").Done)
+
+		res, err := env.QueryWorkflow("getReminderDetails")
+		require.NoError(t, err)
+		var reminderDetails utils.ReminderDetails
+		require.NoError(t, res.Get(&reminderDetails))
+		require.Equal(t, "Travel", reminderDetails.ReminderName)
+	}, 2*time.Minute)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(app.UpdateReminderSignalChannelName, utils.UpdateReminderSignal{RequestId: "late", NMinutes: 10})
+		env.SignalWorkflow(app.UpdateReminderSignalChannelName, utils.UpdateReminderSignal{RequestId: "move", NMinutes: 10})
+	}, 2*time.Hour+time.Minute)
+	env.RegisterDelayedCallback(func() {
+		result := getUpdateResult("late")
+		require.True(t, result.Done)
+		require.Equal(t, "Reminder has already fired.", result.Error)
+		require.Equal(t, "", getUpdateResult("move").Error)
+	}, 2*time.Hour+2*time.Minute)
+	env.ExecuteWorkflow(MakeReminderWorkflow, testDetails)
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+}
+
+func Test_ContinueAsNewWorkflow(t *testing.T) {
+	defer func(threshold int) { ContinueAsNewEventThreshold = threshold }(ContinueAsNewEventThreshold)
+	ContinueAsNewEventThreshold = 2 * eventsPerWakeup
+
+	testSuite := &testsuite.WorkflowTestSuite{}
+	env := testSuite.NewTestWorkflowEnvironment()
+	startTime := time.Date(2022, time.July, 11, 8, 0, 0, 0, time.UTC)
+	env.SetStartTime(startTime)
+	testDetails := utils.ReminderDetails{
+		FromTime:     startTime,
+		ReminderTime: startTime.Add(time.Hour),
+		ReminderText: "Book return flights from Jakarta",
+		ReminderName: "Flights",
+	}
+	env.OnActivity(activities.Create, mock.Anything, mock.Anything).Return(nil).Once()
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(app.UpdateReminderSignalChannelName, utils.UpdateReminderSignal{RequestId: "a", NMinutes: 60, ReminderName: "Travel"})
+	}, time.Minute)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(app.UpdateReminderSignalChannelName, utils.UpdateReminderSignal{RequestId: "b", NMinutes: 90})
+	}, 2*time.Minute)
+	env.ExecuteWorkflow(MakeReminderWorkflow, testDetails)
+	require.True(t, env.IsWorkflowCompleted())
+
+	var continueAsNew *workflow.ContinueAsNewError
+	require.True(t, errors.As(env.GetWorkflowError(), &continueAsNew))
+	var carriedDetails utils.ReminderDetails
+	require.NoError(t, converter.GetDefaultDataConverter().FromPayloads(continueAsNew.Input, &carriedDetails))
+	require.Equal(t, utils.ReminderStatusPending, carriedDetails.Status)
+	require.Equal(t, "Travel", carriedDetails.ReminderName)
+	require.True(t, startTime.Add(92*time.Minute).Equal(carriedDetails.ReminderTime))
+	require.True(t, startTime.Add(time.Hour).Equal(carriedDetails.OccurrenceTime))
+
+	// The next run picks up where the last one left off, without creating the
+	// reminder again, starting with any signals the last run didn't get to
+	carriedDetails.PendingUpdates = []utils.UpdateReminderSignal{{RequestId: "c", NMinutes: 60, ReminderText: "Book hotel"}}
+	env = testSuite.NewTestWorkflowEnvironment()
+	env.SetStartTime(startTime.Add(2 * time.Minute))
+	env.OnActivity(activities.SendReminder, mock.Anything, mock.Anything).Return("wamid.1", nil).Once()
+	env.RegisterDelayedCallback(func() {
+		res, err := env.QueryWorkflow("getUpdateResult", "c")
+		require.NoError(t, err)
+		var result utils.UpdateReminderResult
+		require.NoError(t, res.Get(&result))
+		require.True(t, result.Done)
+		require.Equal(t, "Book hotel", result.ReminderDetails.ReminderText)
+		require.Equal(t, carriedDetails.ReferenceId, result.ReminderDetails.ReferenceId)
+	}, time.Minute)
+	env.ExecuteWorkflow(MakeReminderWorkflow, carriedDetails)
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	env.AssertExpectations(t)
+}
+
+func Test_DeliveryStatusWorkflow(t *testing.T) {
+	testSuite := &testsuite.WorkflowTestSuite{}
+	env := testSuite.NewTestWorkflowEnvironment()
+	startTime := time.Date(2022, time.July, 11, 8, 0, 0, 0, time.UTC)
+	env.SetStartTime(startTime)
+	testDetails := utils.ReminderDetails{
+		FromTime:     startTime,
+		ReminderTime: startTime.Add(time.Hour),
+		ReminderText: "Book return flights from Jakarta",
+		ReminderName: "Flights",
+		AckWindow:    30 * time.Minute,
+	}
+	readAt := startTime.Add(time.Hour + 2*time.Minute)
+	env.OnActivity(activities.Create, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(activities.SendReminder, mock.Anything, mock.Anything).Return("wamid.1", nil)
+	env.OnActivity(activities.RecordDelivery, mock.Anything, mock.Anything).Return(nil).Once()
+	env.RegisterDelayedCallback(func() {
+		// A status for some other message, then a read receipt that arrives
+		// before the delivery receipt
+		env.SignalWorkflow(app.DeliveryStatusSignalChannelName, utils.DeliveryStatusSignal{
+			MessageId: "wamid.0", Status: utils.DeliveryStatusFailed, Timestamp: readAt,
+		})
+		env.SignalWorkflow(app.DeliveryStatusSignalChannelName, utils.DeliveryStatusSignal{
+			MessageId: "wamid.1", Status: utils.DeliveryStatusRead, Timestamp: readAt,
+		})
+		env.SignalWorkflow(app.DeliveryStatusSignalChannelName, utils.DeliveryStatusSignal{
+			MessageId: "wamid.1", Status: utils.DeliveryStatusDelivered, Timestamp: readAt.Add(-time.Minute),
+		})
+	}, time.Hour+2*time.Minute)
+	env.RegisterDelayedCallback(func() {
+		res, err := env.QueryWorkflow("getReminderDetails")
+		require.NoError(t, err)
+		var reminderDetails utils.ReminderDetails
+		require.NoError(t, res.Get(&reminderDetails))
+		require.Equal(t, "wamid.1", reminderDetails.MessageId)
+		require.Equal(t, utils.DeliveryStatusRead, reminderDetails.DeliveryStatus)
+		require.True(t, readAt.Equal(reminderDetails.ReadAt))
+		require.True(t, readAt.Equal(reminderDetails.DeliveredAt))
+		require.Equal(t, "", reminderDetails.DeliveryError)
+	}, time.Hour+3*time.Minute)
+	env.ExecuteWorkflow(MakeReminderWorkflow, testDetails)
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	env.AssertExpectations(t)
+}
+
+func Test_EscalationWorkflow(t *testing.T) {
+	startTime := time.Date(2022, time.July, 11, 8, 0, 0, 0, time.UTC)
+	testDetails := utils.ReminderDetails{
+		FromTime:     startTime,
+		ReminderTime: startTime.Add(time.Hour),
+		ReminderText: "Book return flights from Jakarta",
+		ReminderName: "Flights",
+		Phone:        "16505551111",
+		AckWindow:    30 * time.Minute,
+		Escalation: []utils.EscalationStep{
+			{AfterMinutes: 10},
+			{AfterMinutes: 10, Phone: "16505552222"},
+		},
+	}
+
+	t.Run("unacknowledged", func(t *testing.T) {
+		testSuite := &testsuite.WorkflowTestSuite{}
+		env := testSuite.NewTestWorkflowEnvironment()
+		env.SetStartTime(startTime)
+		env.OnActivity(activities.Create, mock.Anything, mock.Anything).Return(nil)
+		env.OnActivity(activities.SendReminder, mock.Anything, mock.Anything).Return("wamid.1", nil).Once()
+		env.OnActivity(activities.SendReminder, mock.Anything, mock.Anything).Return("wamid.2", nil).Once()
+		env.OnActivity(activities.Escalate, mock.Anything, mock.Anything, utils.EscalationStep{AfterMinutes: 10, Phone: "16505552222"}).Return(nil).Once()
+		env.ExecuteWorkflow(MakeReminderWorkflow, testDetails)
+		require.True(t, env.IsWorkflowCompleted())
+		require.NoError(t, env.GetWorkflowError())
+		env.AssertExpectations(t)
+	})
+
+	t.Run("first message read after re-sending", func(t *testing.T) {
+		testSuite := &testsuite.WorkflowTestSuite{}
+		env := testSuite.NewTestWorkflowEnvironment()
+		env.SetStartTime(startTime)
+		env.OnActivity(activities.Create, mock.Anything, mock.Anything).Return(nil)
+		env.OnActivity(activities.SendReminder, mock.Anything, mock.Anything).Return("wamid.1", nil).Once()
+		env.OnActivity(activities.SendReminder, mock.Anything, mock.Anything).Return("wamid.2", nil).Once()
+		env.OnActivity(activities.RecordDelivery, mock.Anything, mock.Anything).Return(nil).Once()
+		// The secondary phone isn't notified, since the reminder has been read
+		env.RegisterDelayedCallback(func() {
+			res, err := env.QueryWorkflow("getReminderDetails")
+			require.NoError(t, err)
+			var reminderDetails utils.ReminderDetails
+			require.NoError(t, res.Get(&reminderDetails))
+			require.Equal(t, []string{"wamid.1", "wamid.2"}, reminderDetails.MessageIds)
+			env.SignalWorkflow(app.DeliveryStatusSignalChannelName, utils.DeliveryStatusSignal{
+				MessageId: "wamid.1", Status: utils.DeliveryStatusRead, Timestamp: startTime.Add(time.Hour + 15*time.Minute),
+			})
+		}, time.Hour+15*time.Minute)
+		env.ExecuteWorkflow(MakeReminderWorkflow, testDetails)
+		require.True(t, env.IsWorkflowCompleted())
+		require.NoError(t, env.GetWorkflowError())
+		env.AssertExpectations(t)
+	})
+
+	t.Run("escalated to a channel", func(t *testing.T) {
+		testSuite := &testsuite.WorkflowTestSuite{}
+		env := testSuite.NewTestWorkflowEnvironment()
+		env.SetStartTime(startTime)
+		slack := utils.NotificationChannel{Type: utils.NotificationChannelSlack, Target: "https://hooks.slack.com/services/T0/B0/X"}
+		channelDetails := testDetails
+		channelDetails.Escalation = []utils.EscalationStep{{AfterMinutes: 10, Channel: &slack}}
+		env.OnActivity(activities.Create, mock.Anything, mock.Anything).Return(nil)
+		env.OnActivity(activities.SendReminder, mock.Anything, mock.Anything).Return("wamid.1", nil).Once()
+		env.OnActivity(activities.Notify, mock.Anything, mock.Anything, slack).Return(nil).Once()
+		env.ExecuteWorkflow(MakeReminderWorkflow, channelDetails)
+		require.True(t, env.IsWorkflowCompleted())
+		require.NoError(t, env.GetWorkflowError())
+		env.AssertExpectations(t)
+	})
+
+	t.Run("read before escalating", func(t *testing.T) {
+		testSuite := &testsuite.WorkflowTestSuite{}
+		env := testSuite.NewTestWorkflowEnvironment()
+		env.SetStartTime(startTime)
+		env.OnActivity(activities.Create, mock.Anything, mock.Anything).Return(nil)
+		env.OnActivity(activities.SendReminder, mock.Anything, mock.Anything).Return("wamid.1", nil).Once()
+		env.OnActivity(activities.RecordDelivery, mock.Anything, mock.Anything).Return(nil).Once()
+		env.RegisterDelayedCallback(func() {
+			env.SignalWorkflow(app.DeliveryStatusSignalChannelName, utils.DeliveryStatusSignal{
+				MessageId: "wamid.1", Status: utils.DeliveryStatusRead, Timestamp: startTime.Add(time.Hour + 5*time.Minute),
+			})
+		}, time.Hour+5*time.Minute)
+		env.ExecuteWorkflow(MakeReminderWorkflow, testDetails)
+		require.True(t, env.IsWorkflowCompleted())
+		require.NoError(t, env.GetWorkflowError())
+		env.AssertExpectations(t)
+	})
+}
+
+func Test_NotificationChannelsWorkflow(t *testing.T) {
+	testSuite := &testsuite.WorkflowTestSuite{}
+	env := testSuite.NewTestWorkflowEnvironment()
+	startTime := time.Date(2022, time.July, 11, 8, 0, 0, 0, time.UTC)
+	env.SetStartTime(startTime)
+	email := utils.NotificationChannel{Type: utils.NotificationChannelEmail, Target: "kerry@example.com"}
+	slack := utils.NotificationChannel{Type: utils.NotificationChannelSlack, Target: "https://hooks.slack.com/services/T0/B0/X"}
+	testDetails := utils.ReminderDetails{
+		FromTime:     startTime,
+		ReminderTime: startTime.Add(time.Hour),
+		ReminderText: "Book return flights from Jakarta",
+		ReminderName: "Flights",
+		Channels:     []utils.NotificationChannel{email, slack},
+	}
+	env.OnActivity(activities.Create, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(activities.SendReminder, mock.Anything, mock.Anything).Return("wamid.1", nil).Once()
+	// Each channel is retried on its own; one failing doesn't fail the reminder
+	env.OnActivity(activities.Notify, mock.Anything, mock.Anything, email).Return(errors.New("SMTP unavailable")).Times(5)
+	env.OnActivity(activities.Notify, mock.Anything, mock.Anything, slack).Return(nil).Once()
+	env.ExecuteWorkflow(MakeReminderWorkflow, testDetails)
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	env.AssertExpectations(t)
+}
+
+func Test_PublishEventsWorkflow(t *testing.T) {
+	testSuite := &testsuite.WorkflowTestSuite{}
+	env := testSuite.NewTestWorkflowEnvironment()
+	startTime := time.Date(2022, time.July, 11, 8, 0, 0, 0, time.UTC)
+	env.SetStartTime(startTime)
+	testDetails := utils.ReminderDetails{
+		FromTime:     startTime,
+		ReminderTime: startTime.Add(time.Hour),
+		ReminderText: "Book return flights from Jakarta",
+		ReminderName: "Flights",
+		AckWindow:    30 * time.Minute,
+	}
+	events := []string{}
+	env.OnActivity(activities.Create, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(activities.Update, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(activities.SendReminder, mock.Anything, mock.Anything).Return("wamid.1", nil)
+	env.OnActivity(activities.PublishEvent, mock.Anything, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, event string, reminderDetails utils.ReminderDetails) error {
+			events = append(events, event)
+			return nil
+		})
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(app.UpdateReminderSignalChannelName, utils.UpdateReminderSignal{
+			RequestId: "1", ReminderName: "Return flights", ReminderTime: startTime.Add(2 * time.Hour),
+		})
+	}, time.Minute)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(app.ReminderActionSignalChannelName, utils.ReminderActionSignal{Action: utils.ReminderActionSnooze, SnoozeFor: 10 * time.Minute})
+	}, 2*time.Hour+time.Minute)
+	env.ExecuteWorkflow(MakeReminderWorkflow, testDetails)
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	require.Equal(t, []string{"created", "updated", "fired", "snoozed", "fired"}, events)
+}
+
+func Test_PublishEventsInBackgroundWorkflow(t *testing.T) {
+	testSuite := &testsuite.WorkflowTestSuite{}
+	env := testSuite.NewTestWorkflowEnvironment()
+	startTime := time.Date(2022, time.July, 11, 8, 0, 0, 0, time.UTC)
+	env.SetStartTime(startTime)
+	testDetails := utils.ReminderDetails{
+		FromTime:     startTime,
+		ReminderTime: startTime.Add(5 * time.Second),
+		ReminderText: "Book return flights from Jakarta",
+		ReminderName: "Flights",
+		AckWindow:    30 * time.Minute,
+	}
+	events := []string{}
+	var firedAt time.Time
+	env.OnActivity(activities.Create, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(activities.Acknowledge, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(activities.SendReminder, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, reminderDetails utils.ReminderDetails) (string, error) {
+			firedAt = env.Now()
+			return "wamid.1", nil
+		})
+	// An unreachable subscriber is retried for longer than the reminder is away
+	env.OnActivity(activities.PublishEvent, mock.Anything, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, event string, reminderDetails utils.ReminderDetails) error {
+			events = append(events, event)
+			return errors.New("connection refused")
+		})
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(app.ReminderActionSignalChannelName, utils.ReminderActionSignal{Action: utils.ReminderActionDone})
+	}, time.Minute)
+	env.ExecuteWorkflow(MakeReminderWorkflow, testDetails)
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	require.True(t, testDetails.ReminderTime.Equal(firedAt), "fired at %s", firedAt)
+	require.Contains(t, events, "acknowledged")
+	// Every event was still retried before the run ended
+	require.Len(t, events, 3*5)
+}
+
+func Test_SendReminderRetryWorkflow(t *testing.T) {
+	startTime := time.Date(2022, time.July, 11, 8, 0, 0, 0, time.UTC)
+	testDetails := utils.ReminderDetails{
+		FromTime:     startTime,
+		ReminderTime: startTime.Add(time.Hour),
+		ReminderText: "Book return flights from Jakarta",
+		ReminderName: "Flights",
+	}
+
+	t.Run("waits for Retry-After", func(t *testing.T) {
+		testSuite := &testsuite.WorkflowTestSuite{}
+		env := testSuite.NewTestWorkflowEnvironment()
+		env.SetStartTime(startTime)
+		var sentAt []time.Time
+		env.OnActivity(activities.Create, mock.Anything, mock.Anything).Return(nil)
+		env.OnActivity(activities.SendReminder, mock.Anything, mock.Anything).Return(
+			func(ctx context.Context, reminderDetails utils.ReminderDetails) (string, error) {
+				sentAt = append(sentAt, env.Now())
+				if len(sentAt) == 1 {
+					return "", temporal.NewApplicationError("rate limited", activities.WhatsappErrorType, 10*time.Minute)
+				}
+				return "wamid.1", nil
+			}).Times(2)
+		env.ExecuteWorkflow(MakeReminderWorkflow, testDetails)
+		require.True(t, env.IsWorkflowCompleted())
+		require.NoError(t, env.GetWorkflowError())
+		env.AssertExpectations(t)
+		require.GreaterOrEqual(t, sentAt[1].Sub(sentAt[0]), 10*time.Minute)
+	})
+
+	t.Run("doesn't retry permanent errors", func(t *testing.T) {
+		testSuite := &testsuite.WorkflowTestSuite{}
+		env := testSuite.NewTestWorkflowEnvironment()
+		env.SetStartTime(startTime)
+		env.OnActivity(activities.Create, mock.Anything, mock.Anything).Return(nil)
+		env.OnActivity(activities.SendReminder, mock.Anything, mock.Anything).Return(
+			"", temporal.NewNonRetryableApplicationError("recipient not on WhatsApp", activities.WhatsappErrorType, nil)).Once()
+		env.ExecuteWorkflow(MakeReminderWorkflow, testDetails)
+		require.True(t, env.IsWorkflowCompleted())
+		require.NoError(t, env.GetWorkflowError())
+		env.AssertExpectations(t)
+	})
+}
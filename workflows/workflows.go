@@ -1,15 +1,19 @@
 package workflows
 
 import (
+	"errors"
 	"log"
 	"reminders/app"
 	"reminders/app/activities"
+	"reminders/app/codec"
+	"reminders/app/storage"
 	"reminders/app/utils"
 	"time"
 
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/workflow"
+	"golang.org/x/exp/slices"
 )
 
 type WorkflowClientDefinition interface{}
@@ -23,9 +27,24 @@ func (f MockWorkflowClient) GetWorkflowClient() (WorkflowClientDefinition, error
 }
 
 func (w WorkflowClient) GetWorkflowClient() (WorkflowClientDefinition, error) {
-	return client.NewClient(client.Options{})
+	options, err := codec.ClientOptions()
+	if err != nil {
+		return nil, err
+	}
+	return client.NewClient(options)
 }
 
+// ContinueAsNewEventThreshold is roughly how many history events a run may
+// accumulate before the reminder continues as new.
+var ContinueAsNewEventThreshold = 2000
+
+// A rough count of the history events each wakeup of the workflow produces:
+// the signal or timer, a new timer, the workflow task and any activity.
+const eventsPerWakeup = 8
+
+// MakeReminderWorkflow isn't versioned with workflow.GetVersion: reminders
+// started by an older worker must be drained or terminated before a worker
+// with changed commands is deployed; see the README.
 func MakeReminderWorkflow(ctx workflow.Context, reminderDetails utils.ReminderDetails) error {
 	// RetryPolicy specifies how to automatically handle retries if an Activity fails.
 	retrypolicy := &temporal.RetryPolicy{
@@ -43,6 +62,12 @@ func MakeReminderWorkflow(ctx workflow.Context, reminderDetails utils.ReminderDe
 	}
 	ctx = workflow.WithActivityOptions(ctx, options)
 
+	// Record this run's identifiers so activities can refer back to it
+	info := workflow.GetInfo(ctx)
+	reminderDetails.WorkflowId = info.WorkflowExecution.ID
+	reminderDetails.RunId = info.WorkflowExecution.RunID
+	reminderDetails.ReferenceId, _ = utils.MakeReferenceId(reminderDetails.WorkflowId)
+
 	// Set query handlers
 	err := workflow.SetQueryHandler(ctx, "getPhone", func() (string, error) {
 		return reminderDetails.Phone, nil
@@ -50,18 +75,309 @@ func MakeReminderWorkflow(ctx workflow.Context, reminderDetails utils.ReminderDe
 	if err != nil {
 		return err
 	}
-
-	// Create a reminder
-	err = workflow.ExecuteActivity(ctx, activities.Create, reminderDetails).Get(ctx, nil)
+	err = workflow.SetQueryHandler(ctx, "getStatus", func() (string, error) {
+		return reminderDetails.Status, nil
+	})
+	if err != nil {
+		return err
+	}
+	err = workflow.SetQueryHandler(ctx, "getReminderDetails", func() (utils.ReminderDetails, error) {
+		return reminderDetails, nil
+	})
+	if err != nil {
+		return err
+	}
+	state := &workflowState{results: updateResults{}, events: workflow.NewWaitGroup(ctx)}
+	err = workflow.SetQueryHandler(ctx, "getUpdateResult", func(requestId string) (utils.UpdateReminderResult, error) {
+		return state.results[requestId], nil
+	})
 	if err != nil {
 		return err
 	}
 
-	// Handle any incoming updates and/or wait until the reminder time has elapsed
-	var reminderUpdateVal utils.UpdateReminderSignal
+	// Create a reminder, unless an earlier run already has
+	if reminderDetails.Status == "" {
+		err = workflow.ExecuteActivity(ctx, activities.Create, reminderDetails).Get(ctx, nil)
+		if err != nil {
+			return err
+		}
+		publishEvent(ctx, state, storage.EventCreated, reminderDetails)
+		reminderDetails.OccurrenceTime = reminderDetails.ReminderTime
+	}
+
 	updateReminderChannel := workflow.GetSignalChannel(ctx, app.UpdateReminderSignalChannelName)
+	reminderActionChannel := workflow.GetSignalChannel(ctx, app.ReminderActionSignalChannelName)
+	deliveryStatusChannel := workflow.GetSignalChannel(ctx, app.DeliveryStatusSignalChannelName)
+	// Delivery statuses may arrive at any point, so handle them alongside the main loop
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		for {
+			var deliveryStatusVal utils.DeliveryStatusSignal
+			deliveryStatusChannel.Receive(ctx, &deliveryStatusVal)
+			state.historyEvents += eventsPerWakeup
+			handleDeliveryStatus(ctx, &reminderDetails, deliveryStatusVal)
+		}
+	})
+	for ctx.Err() == nil {
+		if state.shouldContinueAsNew() {
+			return continueAsNew(ctx, state, reminderDetails, updateReminderChannel, reminderActionChannel, deliveryStatusChannel)
+		}
+		// Handle any incoming updates and/or wait until the reminder time has elapsed
+		setReminderStatus(ctx, &reminderDetails, utils.ReminderStatusPending)
+		if !waitForReminderTime(ctx, &reminderDetails, state, updateReminderChannel, reminderActionChannel) {
+			if ctx.Err() == nil && state.shouldContinueAsNew() {
+				return continueAsNew(ctx, state, reminderDetails, updateReminderChannel, reminderActionChannel, deliveryStatusChannel)
+			}
+			break
+		}
+		if reminderDetails.Status == utils.ReminderStatusCancelled {
+			setReminderStatus(ctx, &reminderDetails, utils.ReminderStatusCancelled)
+			err = workflow.ExecuteActivity(ctx, activities.Delete, reminderDetails).Get(ctx, nil)
+			publishEvent(ctx, state, storage.EventCancelled, reminderDetails)
+			state.events.Wait(ctx)
+			return err
+		}
+		var messageId string
+		messageId, err = sendReminder(ctx, reminderDetails)
+		log.Println("Reminder fired")
+		reminderDetails.MessageIds = nil
+		recordSentMessage(ctx, &reminderDetails, messageId, err)
+		notifyChannels(ctx, reminderDetails)
+		setReminderStatus(ctx, &reminderDetails, utils.ReminderStatusFired)
+		publishEvent(ctx, state, storage.EventFired, reminderDetails)
+
+		// Give the recipient a chance to snooze or dismiss the reminder
+		if snoozeUntil, snoozed := waitForAcknowledgement(ctx, &reminderDetails, state, updateReminderChannel, reminderActionChannel); snoozed {
+			reminderDetails.ReminderTime = snoozeUntil
+			recordSnooze(ctx, &reminderDetails)
+			log.Println("Reminder snoozed until", snoozeUntil.Format(app.TIME_FORMAT))
+			recordUpdate(ctx, state, reminderDetails)
+			continue
+		}
+
+		next, ok := reminderDetails.GetNextOccurrence(reminderDetails.OccurrenceTime)
+		if !ok {
+			state.events.Wait(ctx)
+			return nil
+		}
+		reminderDetails.ReminderTime = next
+		reminderDetails.OccurrenceTime = next
+		log.Println("Next occurrence at", next.Format(app.TIME_FORMAT))
+	}
+	if ctx.Err() != nil {
+		setReminderStatus(ctx, &reminderDetails, utils.ReminderStatusCancelled)
+		// The workflow's own context is canceled, so record it on a fresh one
+		disconnectedCtx, _ := workflow.NewDisconnectedContext(ctx)
+		_ = workflow.ExecuteActivity(disconnectedCtx, activities.Delete, reminderDetails).Get(disconnectedCtx, nil)
+		publishEvent(disconnectedCtx, state, storage.EventCancelled, reminderDetails)
+		state.events.Wait(disconnectedCtx)
+	}
+	return ctx.Err()
+}
+
+// recordUpdate lets the Update activity, and webhook subscribers, know the
+// reminder has been edited or snoozed. Failures don't affect the reminder.
+func recordUpdate(ctx workflow.Context, state *workflowState, reminderDetails utils.ReminderDetails) {
+	_ = workflow.ExecuteActivity(ctx, activities.Update, reminderDetails).Get(ctx, nil)
+	if reminderDetails.IsSnoozed() {
+		publishEvent(ctx, state, storage.EventSnoozed, reminderDetails)
+	} else {
+		publishEvent(ctx, state, storage.EventUpdated, reminderDetails)
+	}
+}
+
+// publishEvent sends a lifecycle event to webhook subscribers in the
+// background, so that slow subscribers never hold up the reminder. Deliveries
+// are retried for a few minutes at most, then given up on; the run waits for
+// them before it ends.
+func publishEvent(ctx workflow.Context, state *workflowState, event string, reminderDetails utils.ReminderDetails) {
+	ctx = workflow.WithStartToCloseTimeout(ctx, 5*time.Minute)
+	ctx = workflow.WithRetryPolicy(ctx, temporal.RetryPolicy{
+		InitialInterval:    time.Second,
+		BackoffCoefficient: 2.0,
+		MaximumInterval:    time.Minute,
+		MaximumAttempts:    5,
+	})
+	future := workflow.ExecuteActivity(ctx, activities.PublishEvent, event, reminderDetails)
+	state.events.Add(1)
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		defer state.events.Done()
+		if err := future.Get(ctx, nil); err != nil {
+			log.Println("Unable to publish", event, "event", err)
+		}
+	})
+}
+
+// How many times sendReminder tries to send a reminder.
+const sendReminderAttempts = 5
+
+// sendReminder runs the SendReminder activity, retrying it with exponential
+// backoff. A retry is put off for at least as long as the Graph API's
+// Retry-After asked, and errors it reports as permanent aren't retried.
+func sendReminder(ctx workflow.Context, reminderDetails utils.ReminderDetails) (string, error) {
+	ctx = workflow.WithRetryPolicy(ctx, temporal.RetryPolicy{MaximumAttempts: 1})
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		var messageId string
+		err := workflow.ExecuteActivity(ctx, activities.SendReminder, reminderDetails).Get(ctx, &messageId)
+		if err == nil || attempt == sendReminderAttempts || ctx.Err() != nil {
+			return messageId, err
+		}
+		delay := backoff
+		var appErr *temporal.ApplicationError
+		if errors.As(err, &appErr) {
+			if appErr.NonRetryable() {
+				log.Println("Not retrying reminder", err)
+				return messageId, err
+			}
+			var retryAfter time.Duration
+			if appErr.HasDetails() && appErr.Details(&retryAfter) == nil && retryAfter > delay {
+				delay = retryAfter
+			}
+		}
+		log.Println("Retrying reminder in", delay, err)
+		if err := workflow.Sleep(ctx, delay); err != nil {
+			return messageId, err
+		}
+		if backoff *= 2; backoff > time.Minute {
+			backoff = time.Minute
+		}
+	}
+}
+
+// continueAsNew starts a fresh run of the reminder, to keep its history
+// bounded. Signals that haven't been handled yet are carried over.
+func continueAsNew(ctx workflow.Context, state *workflowState, reminderDetails utils.ReminderDetails, updateReminderChannel workflow.ReceiveChannel, reminderActionChannel workflow.ReceiveChannel, deliveryStatusChannel workflow.ReceiveChannel) error {
+	state.events.Wait(ctx)
+	for {
+		var deliveryStatusVal utils.DeliveryStatusSignal
+		if !deliveryStatusChannel.ReceiveAsync(&deliveryStatusVal) {
+			break
+		}
+		handleDeliveryStatus(ctx, &reminderDetails, deliveryStatusVal)
+	}
+	for {
+		var reminderUpdateVal utils.UpdateReminderSignal
+		if !updateReminderChannel.ReceiveAsync(&reminderUpdateVal) {
+			break
+		}
+		reminderDetails.PendingUpdates = append(reminderDetails.PendingUpdates, reminderUpdateVal)
+	}
+	for {
+		var reminderActionVal utils.ReminderActionSignal
+		if !reminderActionChannel.ReceiveAsync(&reminderActionVal) {
+			break
+		}
+		reminderDetails.PendingActions = append(reminderDetails.PendingActions, reminderActionVal)
+	}
+	log.Println("Continuing reminder as new", reminderDetails.WorkflowId)
+	return workflow.NewContinueAsNewError(ctx, MakeReminderWorkflow, reminderDetails)
+}
+
+// recordSentMessage starts tracking the delivery of a newly sent reminder
+// message, and indexes its ID, along with those of the messages sent before it
+// for the same occurrence, so that status webhooks can find the reminder.
+func recordSentMessage(ctx workflow.Context, reminderDetails *utils.ReminderDetails, messageId string, sendErr error) {
+	reminderDetails.MessageId = messageId
+	reminderDetails.DeliveryStatus = ""
+	reminderDetails.DeliveredAt = time.Time{}
+	reminderDetails.ReadAt = time.Time{}
+	reminderDetails.DeliveryError = ""
+	if sendErr != nil {
+		reminderDetails.DeliveryStatus = utils.DeliveryStatusFailed
+		reminderDetails.DeliveryError = sendErr.Error()
+		return
+	}
+	reminderDetails.MessageIds = append(reminderDetails.MessageIds, messageId)
+	err := workflow.UpsertSearchAttributes(ctx, map[string]interface{}{
+		app.ReminderMessageIdSearchAttribute: reminderDetails.MessageIds,
+	})
+	if err != nil {
+		log.Println("Unable to update search attributes", err)
+	}
+}
+
+// Statuses can arrive out of order; a status never replaces a later one.
+var deliveryStatusOrder = map[string]int{
+	utils.DeliveryStatusSent:      1,
+	utils.DeliveryStatusDelivered: 2,
+	utils.DeliveryStatusRead:      3,
+	utils.DeliveryStatusFailed:    3,
+}
+
+// handleDeliveryStatus applies a status webhook for the reminder's latest
+// message, and records it with the RecordDelivery activity. The recipient
+// reading an earlier message sent for the same occurrence, such as the one an
+// escalation re-sent, counts as reading the reminder.
+func handleDeliveryStatus(ctx workflow.Context, reminderDetails *utils.ReminderDetails, deliveryStatus utils.DeliveryStatusSignal) {
+	if deliveryStatus.MessageId != reminderDetails.MessageId {
+		if deliveryStatus.Status != utils.DeliveryStatusRead || !slices.Contains(reminderDetails.MessageIds, deliveryStatus.MessageId) {
+			log.Println("Ignoring status for earlier message", deliveryStatus.MessageId)
+			return
+		}
+	}
+	order, ok := deliveryStatusOrder[deliveryStatus.Status]
+	if !ok || order <= deliveryStatusOrder[reminderDetails.DeliveryStatus] {
+		return
+	}
+	reminderDetails.DeliveryStatus = deliveryStatus.Status
+	switch deliveryStatus.Status {
+	case utils.DeliveryStatusDelivered:
+		reminderDetails.DeliveredAt = deliveryStatus.Timestamp
+	case utils.DeliveryStatusRead:
+		// Read receipts imply delivery, which may not have been reported
+		if reminderDetails.DeliveredAt.IsZero() {
+			reminderDetails.DeliveredAt = deliveryStatus.Timestamp
+		}
+		reminderDetails.ReadAt = deliveryStatus.Timestamp
+	case utils.DeliveryStatusFailed:
+		reminderDetails.DeliveryError = deliveryStatus.Error
+	}
+	log.Println("Reminder message", reminderDetails.MessageId, reminderDetails.DeliveryStatus)
+	_ = workflow.ExecuteActivity(ctx, activities.RecordDelivery, *reminderDetails).Get(ctx, nil)
+}
+
+func recordSnooze(ctx workflow.Context, reminderDetails *utils.ReminderDetails) {
+	reminderDetails.SnoozeHistory = append(reminderDetails.SnoozeHistory, utils.SnoozeRecord{
+		SnoozedAt:    workflow.Now(ctx),
+		SnoozedUntil: reminderDetails.ReminderTime,
+	})
+}
+
+// setReminderStatus records the reminder's status, and mirrors it into the
+// search attributes GET /reminders filters on.
+func setReminderStatus(ctx workflow.Context, reminderDetails *utils.ReminderDetails, status string) {
+	reminderDetails.Status = status
+	err := workflow.UpsertSearchAttributes(ctx, map[string]interface{}{
+		app.ReminderStatusSearchAttribute: status,
+		app.ReminderTimeSearchAttribute:   reminderDetails.ReminderTime,
+	})
+	if err != nil {
+		log.Println("Unable to update search attributes", err)
+	}
+}
+
+// waitForReminderTime blocks until the reminder is due, applying any updates
+// received in the meantime. It returns false if the workflow was canceled or
+// should continue as new, and sets the status to cancelled if the recipient
+// dismissed the reminder early.
+func waitForReminderTime(ctx workflow.Context, reminderDetails *utils.ReminderDetails, state *workflowState, updateReminderChannel workflow.ReceiveChannel, reminderActionChannel workflow.ReceiveChannel) bool {
+	// Handle signals carried over from the previous run first
+	pendingUpdates, pendingActions := reminderDetails.PendingUpdates, reminderDetails.PendingActions
+	reminderDetails.PendingUpdates, reminderDetails.PendingActions = nil, nil
+	for i := range pendingUpdates {
+		applyReminderUpdate(ctx, reminderDetails, state, &pendingUpdates[i])
+	}
+	for _, reminderActionVal := range pendingActions {
+		if applyReminderAction(ctx, reminderDetails, state, reminderActionVal) {
+			return true
+		}
+	}
+
+	var reminderUpdateVal utils.UpdateReminderSignal
+	var reminderActionVal utils.ReminderActionSignal
 	timerFired := false
-	for !timerFired && ctx.Err() == nil {
+	for !timerFired && ctx.Err() == nil && !state.shouldContinueAsNew() {
+		state.historyEvents += eventsPerWakeup
 		timerCtx, timerCancel := workflow.WithCancel(ctx)
 		timeToReminder := reminderDetails.GetMinutesToReminder(timerCtx)
 		timer := workflow.NewTimer(timerCtx, timeToReminder)
@@ -69,9 +385,7 @@ func MakeReminderWorkflow(ctx workflow.Context, reminderDetails utils.ReminderDe
 		workflow.NewSelector(timerCtx).
 			AddFuture(timer, func(f workflow.Future) {
 				err := f.Get(timerCtx, nil)
-				_ = workflow.ExecuteActivity(timerCtx, activities.SendReminder, reminderDetails).Get(timerCtx, nil)
 				if err == nil {
-					log.Println("Reminder fired")
 					timerFired = true
 				} else if ctx.Err() != nil {
 					// if a timer returned an error then it was canceled
@@ -81,16 +395,200 @@ func MakeReminderWorkflow(ctx workflow.Context, reminderDetails utils.ReminderDe
 			AddReceive(updateReminderChannel, func(c workflow.ReceiveChannel, more bool) {
 				timerCancel() // Create a new timer even if the reminder time hasn't been updated
 				c.Receive(timerCtx, &reminderUpdateVal)
-				originalNMinutes := reminderDetails.NMinutes
-				updated := updateReminderDetails(timerCtx, &reminderUpdateVal, &reminderDetails)
-				log.Println("ReminderDetails updated: ", reminderDetails)
+				applyReminderUpdate(ctx, reminderDetails, state, &reminderUpdateVal)
+			}).
+			AddReceive(reminderActionChannel, func(c workflow.ReceiveChannel, more bool) {
+				timerCancel()
+				c.Receive(timerCtx, &reminderActionVal)
+				timerFired = applyReminderAction(ctx, reminderDetails, state, reminderActionVal)
+			}).
+			Select(timerCtx)
+	}
+	return timerFired
+}
 
-				if updated.NMinutes != originalNMinutes {
-					log.Println("New reminder time set:", reminderDetails.ReminderTime.Format(app.TIME_FORMAT))
-				}
+// applyReminderUpdate validates and applies an update to a pending reminder,
+// recording the outcome for UpdateWorkflow.
+func applyReminderUpdate(ctx workflow.Context, reminderDetails *utils.ReminderDetails, state *workflowState, reminderUpdate *utils.UpdateReminderSignal) {
+	if state.results[reminderUpdate.RequestId].Done {
+		log.Println("Ignoring repeated update", reminderUpdate.RequestId)
+		return
+	}
+	if err := validateReminderUpdate(ctx, reminderUpdate); err != nil {
+		log.Println("ReminderDetails update rejected: ", err)
+		state.results.reject(reminderUpdate.RequestId, *reminderDetails, err.Error())
+		return
+	}
+	originalReminderTime := reminderDetails.ReminderTime
+	updated := updateReminderDetails(ctx, reminderUpdate, reminderDetails)
+	log.Println("ReminderDetails updated: ", reminderDetails)
+	state.results.apply(reminderUpdate.RequestId, *reminderDetails)
 
+	if !updated.ReminderTime.Equal(originalReminderTime) {
+		log.Println("New reminder time set:", reminderDetails.ReminderTime.Format(app.TIME_FORMAT))
+	}
+	recordUpdate(ctx, state, *reminderDetails)
+}
+
+// applyReminderAction snoozes or dismisses a pending reminder. It returns true
+// if the reminder was dismissed.
+func applyReminderAction(ctx workflow.Context, reminderDetails *utils.ReminderDetails, state *workflowState, reminderAction utils.ReminderActionSignal) bool {
+	switch reminderAction.Action {
+	case utils.ReminderActionSnooze:
+		reminderDetails.ReminderTime = workflow.Now(ctx).Add(reminderAction.SnoozeFor)
+		recordSnooze(ctx, reminderDetails)
+		log.Println("Reminder snoozed until", reminderDetails.ReminderTime.Format(app.TIME_FORMAT))
+		recordUpdate(ctx, state, *reminderDetails)
+	case utils.ReminderActionDone, utils.ReminderActionDismiss:
+		reminderDetails.Status = utils.ReminderStatusCancelled
+		return true
+	}
+	return false
+}
+
+// waitForAcknowledgement keeps a fired reminder open for replies for up to
+// AckWindow after its last escalation step. It returns the new reminder time
+// if the recipient snoozed it. Updates received in the meantime are rejected,
+// since the reminder has fired.
+func waitForAcknowledgement(ctx workflow.Context, reminderDetails *utils.ReminderDetails, state *workflowState, updateReminderChannel workflow.ReceiveChannel, reminderActionChannel workflow.ReceiveChannel) (time.Time, bool) {
+	if reminderDetails.AckWindow <= 0 {
+		return time.Time{}, false
+	}
+	var reminderUpdateVal utils.UpdateReminderSignal
+	var reminderActionVal utils.ReminderActionSignal
+	var snoozeUntil time.Time
+	snoozed := false
+	done := false
+	timerCtx, timerCancel := workflow.WithCancel(ctx)
+	defer timerCancel()
+	timer := workflow.NewTimer(timerCtx, reminderDetails.AckWindow+reminderDetails.GetEscalationDelay())
+	escalation := reminderDetails.Escalation
+	var escalationTimer workflow.Future
+	if len(escalation) > 0 {
+		escalationTimer = workflow.NewTimer(timerCtx, time.Duration(escalation[0].AfterMinutes)*time.Minute)
+	}
+	for !done && ctx.Err() == nil {
+		state.historyEvents += eventsPerWakeup
+		selector := workflow.NewSelector(timerCtx)
+		if escalationTimer != nil {
+			selector.AddFuture(escalationTimer, func(f workflow.Future) {
+				escalationTimer = nil
+				if reminderDetails.DeliveryStatus == utils.DeliveryStatusRead {
+					log.Println("Reminder read; not escalating")
+					return
+				}
+				escalate(ctx, reminderDetails, escalation[0])
+				if escalation = escalation[1:]; len(escalation) > 0 {
+					escalationTimer = workflow.NewTimer(timerCtx, time.Duration(escalation[0].AfterMinutes)*time.Minute)
+				}
+			})
+		}
+		selector.
+			AddFuture(timer, func(f workflow.Future) {
+				log.Println("Reminder acknowledgement window elapsed")
+				done = true
+			}).
+			AddReceive(reminderActionChannel, func(c workflow.ReceiveChannel, more bool) {
+				c.Receive(timerCtx, &reminderActionVal)
+				switch reminderActionVal.Action {
+				case utils.ReminderActionSnooze:
+					snoozeUntil = workflow.Now(ctx).Add(reminderActionVal.SnoozeFor)
+					snoozed = true
+				case utils.ReminderActionDone, utils.ReminderActionDismiss:
+					setReminderStatus(ctx, reminderDetails, utils.ReminderStatusAcknowledged)
+					log.Println("Reminder acknowledged")
+					_ = workflow.ExecuteActivity(ctx, activities.Acknowledge, *reminderDetails).Get(ctx, nil)
+					publishEvent(ctx, state, storage.EventAcknowledged, *reminderDetails)
+				}
+				done = true
+			}).
+			AddReceive(updateReminderChannel, func(c workflow.ReceiveChannel, more bool) {
+				c.Receive(timerCtx, &reminderUpdateVal)
+				state.results.reject(reminderUpdateVal.RequestId, *reminderDetails, "Reminder has already fired.")
 			}).
 			Select(timerCtx)
 	}
-	return ctx.Err()
+	return snoozeUntil, snoozed
+}
+
+// workflowState is what a single run tracks besides the reminder itself.
+type workflowState struct {
+	results       updateResults
+	historyEvents int                // estimated, see eventsPerWakeup
+	events        workflow.WaitGroup // lifecycle events still being published
+}
+
+func (s *workflowState) shouldContinueAsNew() bool {
+	return s.historyEvents >= ContinueAsNewEventThreshold
+}
+
+// notifyChannels delivers a fired reminder to each of its channels as a
+// separate activity, so that retrying one destination never re-sends to the
+// others. Failures are logged; the reminder has already gone out by WhatsApp.
+func notifyChannels(ctx workflow.Context, reminderDetails utils.ReminderDetails) {
+	ctx = workflow.WithRetryPolicy(ctx, temporal.RetryPolicy{
+		InitialInterval:    time.Second,
+		BackoffCoefficient: 2.0,
+		MaximumInterval:    time.Minute,
+		MaximumAttempts:    5,
+	})
+	futures := make([]workflow.Future, len(reminderDetails.Channels))
+	for i, channel := range reminderDetails.Channels {
+		futures[i] = workflow.ExecuteActivity(ctx, activities.Notify, reminderDetails, channel)
+	}
+	for i, future := range futures {
+		if err := future.Get(ctx, nil); err != nil {
+			log.Println("Unable to notify", reminderDetails.Channels[i].Type, reminderDetails.Channels[i].Target, err)
+		}
+	}
+}
+
+// escalate carries out an escalation step for an unacknowledged reminder.
+func escalate(ctx workflow.Context, reminderDetails *utils.ReminderDetails, step utils.EscalationStep) {
+	if step.Channel != nil {
+		log.Println("Reminder not acknowledged; sending it by", step.Channel.Type, "to", step.Channel.Target)
+		if err := workflow.ExecuteActivity(ctx, activities.Notify, *reminderDetails, *step.Channel).Get(ctx, nil); err != nil {
+			log.Println("Unable to notify", step.Channel.Type, step.Channel.Target, err)
+		}
+		return
+	}
+	if step.Phone == "" || step.Phone == reminderDetails.Phone {
+		log.Println("Reminder not acknowledged; sending it again")
+		messageId, err := sendReminder(ctx, *reminderDetails)
+		recordSentMessage(ctx, reminderDetails, messageId, err)
+		return
+	}
+	log.Println("Reminder not acknowledged; notifying", step.Phone)
+	_ = workflow.ExecuteActivity(ctx, activities.Escalate, *reminderDetails, step).Get(ctx, nil)
+}
+
+// updateResults remembers the outcome of each update signal, so that
+// UpdateWorkflow can query for it once the signal has been handled.
+type updateResults map[string]utils.UpdateReminderResult
+
+func (r updateResults) apply(requestId string, reminderDetails utils.ReminderDetails) {
+	if requestId != "" {
+		r[requestId] = utils.UpdateReminderResult{Done: true, ReminderDetails: reminderDetails}
+	}
+}
+
+// reject leaves the outcome of an update already handled under requestId as
+// is, so that a retried update returns it.
+func (r updateResults) reject(requestId string, reminderDetails utils.ReminderDetails, reason string) {
+	if requestId != "" && !r[requestId].Done {
+		r[requestId] = utils.UpdateReminderResult{Done: true, ReminderDetails: reminderDetails, Error: reason}
+	}
+}
+
+func validateReminderUpdate(ctx workflow.Context, reminderUpdate *utils.UpdateReminderSignal) error {
+	if !reminderUpdate.ReminderTime.IsZero() && reminderUpdate.ReminderTime.Before(workflow.Now(ctx)) {
+		return app.ReminderInPastError(reminderUpdate.ReminderTime)
+	}
+	if reminderUpdate.NMinutes < 0 {
+		return app.ReminderInPastError(workflow.Now(ctx).Add(time.Duration(reminderUpdate.NMinutes) * time.Minute))
+	}
+	if err := utils.ValidateEscalation(reminderUpdate.Escalation); err != nil {
+		return err
+	}
+	return utils.ValidateChannels(reminderUpdate.Channels)
 }